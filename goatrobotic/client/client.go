@@ -0,0 +1,296 @@
+// Package client is a Go client for the chat service's HTTP API. It joins
+// as one user and sends, receives and leaves on that user's behalf, using
+// the request and response types of package model.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// Reconnect backoff used by Receive after a failed poll.
+const (
+	backoffMin = 100 * time.Millisecond
+	backoffMax = 5 * time.Second
+)
+
+// Client talks to the chat service at a base URL such as
+// "http://localhost:8080". It is safe for concurrent use, but holds the
+// session of a single user: Join must succeed before the other calls.
+type Client struct {
+	baseURL string
+	http    *http.Client
+
+	mu    sync.Mutex
+	join  model.JoinRequest
+	token string
+}
+
+// New returns a Client for the service at baseURL. A nil httpClient uses
+// http.DefaultClient; one with a Timeout must allow for the server's
+// receive wait, or long polls will fail.
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), http: httpClient}
+}
+
+// Token returns the session token issued by the last successful join.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// Join joins the service as req.ID and keeps the session token for later
+// calls. A client that already holds a token for the same ID resumes its
+// session.
+func (c *Client) Join(ctx context.Context, req model.JoinRequest) (*model.JoinResponse, error) {
+	c.mu.Lock()
+	if req.Token == "" && req.ID == c.join.ID {
+		req.Token = c.token
+	}
+	c.mu.Unlock()
+
+	var res model.JoinResponse
+	if _, _, err := c.do(ctx, http.MethodPost, "/join", "", req, &res); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	req.Token = ""
+	req.Room = res.Room
+	c.join = req
+	c.token = res.Token
+	c.mu.Unlock()
+	return &res, nil
+}
+
+// SendMessage sends req as the joined user; From and Token default to the
+// joined session's.
+func (c *Client) SendMessage(ctx context.Context, req model.SendMessageRequest) (*model.SendMessageResponse, error) {
+	id, token := c.session()
+	if req.From == "" {
+		req.From = id
+	}
+	if req.Token == "" {
+		req.Token = token
+	}
+	var res model.SendMessageResponse
+	if _, _, err := c.do(ctx, http.MethodPost, "/send", token, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Leave ends the joined session. The Client may join again afterwards.
+func (c *Client) Leave(ctx context.Context) (*model.LeaveResponse, error) {
+	id, token := c.session()
+	var res model.LeaveResponse
+	req := model.LeaveRequest{ID: id, Token: token}
+	if _, _, err := c.do(ctx, http.MethodPost, "/leave", token, req, &res); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.token = ""
+	c.mu.Unlock()
+	return &res, nil
+}
+
+// Receive long-polls for the joined user's messages and sends each on ch
+// until ctx is done, returning ctx's error. A poll that times out is
+// repeated at once. When the session is lost, for example after it was
+// evicted for inactivity, Receive joins again with the original request;
+// messages queued for the old session are lost. Transient failures are
+// retried with backoff. Receive returns any other error, such as a ban, and
+// never closes ch.
+func (c *Client) Receive(ctx context.Context, ch chan<- model.MessageResponse) error {
+	backoff := backoffMin
+	for {
+		msg, err := c.receive(ctx)
+		if err == nil {
+			backoff = backoffMin
+			select {
+			case ch <- *msg:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		switch code := errcom.Code(err); {
+		case code == "ERR_NO_MESSAGES":
+			backoff = backoffMin
+			if wait := retryAfter(err); wait > 0 {
+				if err := sleep(ctx, wait); err != nil {
+					return err
+				}
+			}
+			continue
+		case code == "ERR_USER_NOT_FOUND" || code == "ERR_USER_DISCONNECTED" || code == "ERR_UNAUTHORIZED":
+			if _, err = c.rejoin(ctx); err == nil {
+				continue
+			}
+			if !retryable(err) {
+				return err
+			}
+		case !retryable(err):
+			return err
+		}
+
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff = min(2*backoff, backoffMax)
+	}
+}
+
+// receive waits for one message. A 204 reply is reported as ERR_NO_MESSAGES,
+// as the server does itself when configured to answer timeouts with 408,
+// with the polling hints from its headers as details.
+func (c *Client) receive(ctx context.Context) (*model.MessageResponse, error) {
+	id, token := c.session()
+	if id == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("client has not joined"))
+	}
+	var res model.MessageResponse
+	status, header, err := c.do(ctx, http.MethodGet, "/receive/"+url.PathEscape(id), token, nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNoContent {
+		details := make(map[string]any)
+		for key, name := range map[string]string{"retry_after_ms": "X-Retry-After-Ms", "poll_timeout_ms": "X-Poll-Timeout-Ms"} {
+			if ms, err := strconv.ParseFloat(header.Get(name), 64); err == nil {
+				details[key] = ms
+			}
+		}
+		return nil, errcom.NewCustomErrorWithDetails("ERR_NO_MESSAGES", errors.New("no messages received"), details)
+	}
+	return &res, nil
+}
+
+// rejoin repeats the last join, resuming the session if it still exists.
+func (c *Client) rejoin(ctx context.Context) (*model.JoinResponse, error) {
+	c.mu.Lock()
+	req := c.join
+	c.mu.Unlock()
+	return c.Join(ctx, req)
+}
+
+func (c *Client) session() (id, token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.join.ID, c.token
+}
+
+// do sends body, if any, as JSON and decodes a successful reply into out,
+// returning the reply's status and headers. Error replies are returned as the
+// *errcom.CustomError they describe, so errcom.Code and errors.Is work on
+// them as on the server.
+func (c *Client) do(ctx context.Context, method, path, token string, body, out any) (int, http.Header, error) {
+	var payload io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		payload = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return resp.StatusCode, resp.Header, nil
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Code    string         `json:"code"`
+			Message string         `json:"message"`
+			Details map[string]any `json:"details"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Code == "" {
+			return resp.StatusCode, resp.Header, &statusError{status: resp.StatusCode}
+		}
+		return resp.StatusCode, resp.Header, errcom.NewCustomErrorWithDetails(e.Code, errors.New(e.Message), e.Details)
+	}
+	return resp.StatusCode, resp.Header, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// statusError is an error reply that did not carry an error code, such as
+// one from a proxy in front of the service.
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("chat: unexpected status %d %s", e.status, http.StatusText(e.status))
+}
+
+// retryable reports whether a failed call may succeed if repeated: network
+// failures, server errors and the server's rate limiting or shedding.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.status >= 500 || se.status == http.StatusTooManyRequests
+	}
+	switch errcom.Code(err) {
+	case "":
+		// Not a reply at all, so the request failed in transit.
+		return true
+	case "ERR_SERVICE_UNAVAILABLE", "ERR_SERVER_SHUTTING_DOWN", "ERR_DRAINING",
+		"ERR_SERVER_FULL", "ERR_RATE_LIMIT", "ERR_MEMORY_PRESSURE", "ERR_INTERNAL":
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the re-poll delay the server suggested with err.
+func retryAfter(err error) time.Duration {
+	ms, _ := errcom.Details(err)["retry_after_ms"].(float64)
+	return time.Duration(ms) * time.Millisecond
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,275 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// server is a stand-in for the chat API that records what the client sent
+// and replies from its handlers.
+type server struct {
+	mu    sync.Mutex
+	calls []string
+	auth  []string
+}
+
+func (s *server) start(t *testing.T, routes map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	for pattern, h := range routes {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			s.mu.Lock()
+			s.calls = append(s.calls, r.Method+" "+r.URL.Path)
+			s.auth = append(s.auth, r.Header.Get("Authorization"))
+			s.mu.Unlock()
+			h(w, r)
+		})
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func joined(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req model.JoinRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		reply(w, http.StatusOK, model.JoinResponse{Success: true, Room: "lobby", Token: token})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var s server
+	var sent model.SendMessageRequest
+	srv := s.start(t, map[string]http.HandlerFunc{
+		"POST /join": joined("tok1"),
+		"POST /send": func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&sent)
+			reply(w, http.StatusOK, model.SendMessageResponse{Success: true, Delivered: 1})
+		},
+		"POST /leave": func(w http.ResponseWriter, r *http.Request) {
+			reply(w, http.StatusOK, model.LeaveResponse{Success: true})
+		},
+	})
+	c := New(srv.URL+"/", nil)
+	ctx := context.Background()
+
+	if _, err := c.Join(ctx, model.JoinRequest{ID: "alice", Room: "lobby"}); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if c.Token() != "tok1" {
+		t.Fatalf("Token = %q, want tok1", c.Token())
+	}
+	res, err := c.SendMessage(ctx, model.SendMessageRequest{Message: "hi"})
+	if err != nil || res.Delivered != 1 {
+		t.Fatalf("SendMessage = %+v, %v", res, err)
+	}
+	if sent.From != "alice" || sent.Token != "tok1" || sent.Message != "hi" {
+		t.Fatalf("server received %+v, want alice's session filled in", sent)
+	}
+	if _, err := c.Leave(ctx); err != nil {
+		t.Fatalf("Leave: %v", err)
+	}
+	if c.Token() != "" {
+		t.Fatal("token kept after Leave")
+	}
+	if want := []string{"", "Bearer tok1", "Bearer tok1"}; len(s.auth) != 3 || s.auth[0] != want[0] || s.auth[1] != want[1] || s.auth[2] != want[2] {
+		t.Fatalf("Authorization headers = %q, want %q", s.auth, want)
+	}
+}
+
+func TestErrorDecoding(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      any
+		code      string
+		sentinel  error
+		retryable bool
+	}{
+		{
+			name:     "conflict",
+			status:   http.StatusConflict,
+			body:     map[string]any{"code": "ERR_ALREADY_JOINED", "message": "user already joined"},
+			code:     "ERR_ALREADY_JOINED",
+			sentinel: errcom.ErrAlreadyJoined,
+		},
+		{
+			name:      "rate limited with details",
+			status:    http.StatusTooManyRequests,
+			body:      map[string]any{"code": "ERR_RATE_LIMIT", "message": "too many messages", "details": map[string]any{"retry_after_ms": 1500}},
+			code:      "ERR_RATE_LIMIT",
+			sentinel:  errcom.ErrRateLimit,
+			retryable: true,
+		},
+		{
+			name:      "shut down",
+			status:    http.StatusServiceUnavailable,
+			body:      map[string]any{"code": "ERR_SERVICE_UNAVAILABLE", "message": "service has been shut down"},
+			code:      "ERR_SERVICE_UNAVAILABLE",
+			sentinel:  errcom.ErrServiceUnavailable,
+			retryable: true,
+		},
+		{
+			name:      "proxy error without a code",
+			status:    http.StatusBadGateway,
+			body:      "bad gateway",
+			retryable: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s server
+			srv := s.start(t, map[string]http.HandlerFunc{
+				"POST /join": func(w http.ResponseWriter, r *http.Request) {
+					reply(w, tt.status, tt.body)
+				},
+			})
+			_, err := New(srv.URL, nil).Join(context.Background(), model.JoinRequest{ID: "alice"})
+			if err == nil {
+				t.Fatal("Join succeeded, want an error")
+			}
+			if got := errcom.Code(err); got != tt.code {
+				t.Fatalf("Code(%v) = %q, want %q", err, got, tt.code)
+			}
+			if tt.sentinel != nil && !errors.Is(err, tt.sentinel) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.sentinel)
+			}
+			if m, ok := tt.body.(map[string]any); ok && errcom.Message(err) != m["message"] {
+				t.Errorf("Message = %q, want %q", errcom.Message(err), m["message"])
+			}
+			if got := retryable(err); got != tt.retryable {
+				t.Errorf("retryable = %v, want %v", got, tt.retryable)
+			}
+		})
+	}
+
+	err := errcom.NewCustomErrorWithDetails("ERR_NO_MESSAGES", nil, map[string]any{"retry_after_ms": float64(250)})
+	if got := retryAfter(err); got != 250*time.Millisecond {
+		t.Errorf("retryAfter = %v, want 250ms", got)
+	}
+}
+
+// TestReceive polls through an empty reply and a lost session: the client
+// rejoins and then delivers the next message.
+func TestReceive(t *testing.T) {
+	var s server
+	var joins, polls int
+	srv := s.start(t, map[string]http.HandlerFunc{
+		"POST /join": func(w http.ResponseWriter, r *http.Request) {
+			joins++
+			joined("tok"+strconv.Itoa(joins))(w, r)
+		},
+		"GET /receive/alice": func(w http.ResponseWriter, r *http.Request) {
+			polls++
+			switch polls {
+			case 1:
+				w.WriteHeader(http.StatusNoContent)
+			case 2:
+				reply(w, http.StatusNotFound, map[string]any{"code": "ERR_USER_NOT_FOUND", "message": "user not connected"})
+			default:
+				if got := r.Header.Get("Authorization"); got != "Bearer tok2" {
+					t.Errorf("poll after rejoining sent %q, want the new token", got)
+				}
+				reply(w, http.StatusOK, model.MessageResponse{Type: model.MessageTypeChat, Text: "hi"})
+			}
+		},
+	})
+	c := New(srv.URL, nil)
+	if _, err := c.Join(context.Background(), model.JoinRequest{ID: "alice", Room: "lobby"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch := make(chan model.MessageResponse)
+	done := make(chan error, 1)
+	go func() { done <- c.Receive(ctx, ch) }()
+	select {
+	case msg := <-ch:
+		if msg.Text != "hi" {
+			t.Fatalf("received %+v, want hi", msg)
+		}
+	case err := <-done:
+		t.Fatalf("Receive returned %v before delivering", err)
+	}
+	if c.Token() != "tok2" {
+		t.Fatalf("Token = %q after rejoining, want tok2", c.Token())
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Receive = %v after cancel, want %v", err, context.Canceled)
+	}
+}
+
+// TestReceiveHonorsPollHint waits out the re-poll delay a 204 reply
+// suggests before polling again.
+func TestReceiveHonorsPollHint(t *testing.T) {
+	var s server
+	var mu sync.Mutex
+	var polled []time.Time
+	srv := s.start(t, map[string]http.HandlerFunc{
+		"POST /join": joined("tok1"),
+		"GET /receive/alice": func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			polled = append(polled, time.Now())
+			if len(polled) == 1 {
+				w.Header().Set("X-Retry-After-Ms", "200")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			reply(w, http.StatusOK, model.MessageResponse{Type: model.MessageTypeChat, Text: "hi"})
+		},
+	})
+	c := New(srv.URL, nil)
+	if _, err := c.Join(context.Background(), model.JoinRequest{ID: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch := make(chan model.MessageResponse)
+	go c.Receive(ctx, ch)
+	<-ch
+	mu.Lock()
+	defer mu.Unlock()
+	if gap := polled[1].Sub(polled[0]); gap < 200*time.Millisecond {
+		t.Fatalf("polled again after %v, want at least the 200ms hint", gap)
+	}
+}
+
+// TestReceiveStopsOnPermanentError returns errors that retrying cannot fix.
+func TestReceiveStopsOnPermanentError(t *testing.T) {
+	var s server
+	srv := s.start(t, map[string]http.HandlerFunc{
+		"POST /join": joined("tok1"),
+		"GET /receive/alice": func(w http.ResponseWriter, r *http.Request) {
+			reply(w, http.StatusForbidden, map[string]any{"code": "ERR_BANNED", "message": "banned"})
+		},
+	})
+	c := New(srv.URL, nil)
+	if _, err := c.Join(context.Background(), model.JoinRequest{ID: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Receive(context.Background(), make(chan model.MessageResponse))
+	if !errors.Is(err, errcom.ErrBanned) {
+		t.Fatalf("Receive = %v, want ERR_BANNED", err)
+	}
+}
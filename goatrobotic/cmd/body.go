@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	errcom "chatbox/error"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// defaultMaxBodyBytes bounds request bodies unless CHAT_MAX_BODY_BYTES says
+// otherwise. It leaves room for a maximum-size attachment once base64
+// encoded.
+const defaultMaxBodyBytes = 1 << 20
+
+// errInvalidRequest is reported for request bodies that cannot be bound.
+var errInvalidRequest = errcom.NewCustomError("ERR_INVALID_REQUEST", errors.New("invalid request"))
+
+var errRequestTooLarge = errcom.NewCustomError("ERR_REQUEST_TOO_LARGE", errors.New("request body is too large"))
+
+// maxBodyBytesFromEnv reads CHAT_MAX_BODY_BYTES, falling back to
+// defaultMaxBodyBytes when it is unset or not a positive number.
+func maxBodyBytesFromEnv() int64 {
+	n, err := strconv.ParseInt(os.Getenv("CHAT_MAX_BODY_BYTES"), 10, 64)
+	if err != nil || n <= 0 {
+		return defaultMaxBodyBytes
+	}
+	return n
+}
+
+// bodyLimit fails reads of request bodies longer than n bytes.
+func bodyLimit(n int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+		}
+		c.Next()
+	}
+}
+
+// useJSONFieldNames makes binding validation errors name fields by their
+// json tag, as clients know them.
+func useJSONFieldNames() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+}
+
+// bindJSON decodes the request body into v, rejecting fields v does not
+// have, and checks its binding tags. Bodies over the bodyLimit fail with
+// ERR_REQUEST_TOO_LARGE. A field of the wrong type, an unknown field or one
+// failing its tags fails with ERR_VALIDATION, whose "fields" detail maps
+// each offending field to what is wrong with it.
+func bindJSON(c *gin.Context, v any) error {
+	if c.Request.Body == nil {
+		return errInvalidRequest
+	}
+	dec := json.NewDecoder(c.Request.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return errRequestTooLarge
+		}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return errValidation(map[string]string{typeErr.Field: "must be " + typeErr.Type.Kind().String()})
+		}
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return errValidation(map[string]string{strings.Trim(field, `"`): "unknown"})
+		}
+		return errInvalidRequest
+	}
+	if err := binding.Validator.ValidateStruct(v); err != nil {
+		var invalid validator.ValidationErrors
+		if !errors.As(err, &invalid) {
+			return errInvalidRequest
+		}
+		fields := make(map[string]string, len(invalid))
+		for _, fe := range invalid {
+			fields[fe.Field()] = fe.Tag()
+		}
+		return errValidation(fields)
+	}
+	return nil
+}
+
+// errValidation reports the fields of a request body that are not valid.
+func errValidation(fields map[string]string) error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return errcom.NewCustomErrorWithDetails("ERR_VALIDATION",
+		fmt.Errorf("invalid fields: %s", strings.Join(names, ", ")),
+		map[string]any{"fields": fields})
+}
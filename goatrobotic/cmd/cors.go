@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsConfig says which cross-origin browser clients may call the API. With
+// no origins, cross-origin requests get no CORS headers and browsers block
+// them.
+type corsConfig struct {
+	origins     []string
+	methods     string
+	headers     string
+	credentials bool
+}
+
+// corsFromEnv reads CHAT_CORS_ORIGINS, a comma-separated list of origins or
+// "*", along with CHAT_CORS_METHODS, CHAT_CORS_HEADERS and
+// CHAT_CORS_CREDENTIALS.
+func corsFromEnv() corsConfig {
+	cfg := corsConfig{
+		methods:     "GET, POST, OPTIONS",
+		headers:     "Content-Type, Authorization",
+		credentials: os.Getenv("CHAT_CORS_CREDENTIALS") == "true",
+	}
+	for _, origin := range strings.Split(os.Getenv("CHAT_CORS_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.origins = append(cfg.origins, origin)
+		}
+	}
+	if v := os.Getenv("CHAT_CORS_METHODS"); v != "" {
+		cfg.methods = v
+	}
+	if v := os.Getenv("CHAT_CORS_HEADERS"); v != "" {
+		cfg.headers = v
+	}
+	return cfg
+}
+
+func (cfg corsConfig) allowed(origin string) bool {
+	return slices.Contains(cfg.origins, origin) || slices.Contains(cfg.origins, "*")
+}
+
+// cors sets CORS headers for allowed origins and answers their preflight
+// requests, which would otherwise find no OPTIONS route.
+func cors(cfg corsConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || !cfg.allowed(origin) {
+			c.Next()
+			return
+		}
+		h := c.Writer.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+		if cfg.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", cfg.methods)
+			h.Set("Access-Control-Allow-Headers", cfg.headers)
+			h.Set("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
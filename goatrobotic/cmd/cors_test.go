@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func corsRouter(cfg corsConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(cors(cfg))
+	r.POST("/send", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func TestCORSPreflight(t *testing.T) {
+	r := corsRouter(corsConfig{origins: []string{"https://app.example.com"}, methods: "GET, POST", headers: "Content-Type", credentials: true})
+	req := httptest.NewRequest(http.MethodOptions, "/send", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("preflight status = %d, want 204", rec.Code)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Methods":     "GET, POST",
+		"Access-Control-Allow-Headers":     "Content-Type",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "600",
+		"Vary":                             "Origin",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		allowed bool
+	}{
+		{name: "listed origin", origins: []string{"https://a.example.com", "https://app.example.com"}, origin: "https://app.example.com", allowed: true},
+		{name: "wildcard", origins: []string{"*"}, origin: "https://anywhere.example.com", allowed: true},
+		{name: "unlisted origin", origins: []string{"https://app.example.com"}, origin: "https://evil.example.com"},
+		{name: "default denies", origin: "https://app.example.com"},
+		{name: "same origin", origins: []string{"*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := corsRouter(corsConfig{origins: tt.origins, methods: "GET, POST", headers: "Content-Type"})
+
+			req := httptest.NewRequest(http.MethodPost, "/send", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("POST status = %d, want the request served", rec.Code)
+			}
+			got := rec.Header().Get("Access-Control-Allow-Origin")
+			if tt.allowed && got != tt.origin {
+				t.Fatalf("Access-Control-Allow-Origin = %q, want %q", got, tt.origin)
+			}
+			if !tt.allowed && got != "" {
+				t.Fatalf("Access-Control-Allow-Origin = %q, want none", got)
+			}
+			if rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+				t.Error("credentials allowed without being configured")
+			}
+
+			// A denied origin's preflight finds no route and no CORS headers,
+			// so the browser blocks the request.
+			preflight := httptest.NewRequest(http.MethodOptions, "/send", nil)
+			preflight.Header.Set("Origin", tt.origin)
+			preflight.Header.Set("Access-Control-Request-Method", "POST")
+			rec = httptest.NewRecorder()
+			r.ServeHTTP(rec, preflight)
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); (got != "") != (tt.allowed) {
+				t.Fatalf("preflight Access-Control-Allow-Methods = %q, allowed %v", got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestCORSFromEnv(t *testing.T) {
+	t.Setenv("CHAT_CORS_ORIGINS", " https://a.example.com, ,https://b.example.com")
+	t.Setenv("CHAT_CORS_METHODS", "POST")
+	t.Setenv("CHAT_CORS_CREDENTIALS", "true")
+	cfg := corsFromEnv()
+	if len(cfg.origins) != 2 || !cfg.allowed("https://b.example.com") || cfg.allowed("https://c.example.com") {
+		t.Fatalf("origins = %q", cfg.origins)
+	}
+	if cfg.methods != "POST" || cfg.headers != "Content-Type, Authorization" || !cfg.credentials {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"chatbox/chatpb"
+	errcom "chatbox/error"
+	"chatbox/model"
+	"chatbox/service"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer serves the chat API over gRPC from the same service as the HTTP
+// handlers.
+type grpcServer struct {
+	chatpb.UnimplementedChatServer
+	cs service.ChatService
+}
+
+func newGRPCServer(cs service.ChatService) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(unaryCredential(cs)),
+		grpc.StreamInterceptor(streamCredential(cs)),
+	)
+	chatpb.RegisterChatServer(srv, &grpcServer{cs: cs})
+	return srv
+}
+
+// credential applies a bearer token from the call's authorization metadata
+// to ctx, as sessionToken does for HTTP requests.
+func credential(ctx context.Context, cs service.ChatService) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok && token != "" {
+			return withCredential(ctx, cs, token)
+		}
+	}
+	return ctx
+}
+
+func unaryCredential(cs service.ChatService) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(credential(ctx, cs), req)
+	}
+}
+
+func streamCredential(cs service.ChatService) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &credentialStream{ServerStream: stream, ctx: credential(stream.Context(), cs)})
+	}
+}
+
+// credentialStream is a server stream whose context carries the caller's
+// credential.
+type credentialStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *credentialStream) Context() context.Context { return s.ctx }
+
+func (g *grpcServer) Join(ctx context.Context, req *chatpb.JoinRequest) (*chatpb.JoinResponse, error) {
+	res, err := g.cs.Join(ctx, model.JoinRequest{ID: req.Id, Room: req.Room, Force: req.Force})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &chatpb.JoinResponse{Success: res.Success, Message: res.Message, Room: res.Room, Token: res.Token}, nil
+}
+
+func (g *grpcServer) Send(ctx context.Context, req *chatpb.SendRequest) (*chatpb.SendResponse, error) {
+	res, err := g.cs.SendMessage(ctx, model.SendMessageRequest{
+		From:           req.From,
+		Message:        req.Message,
+		Token:          req.Token,
+		IdempotencyKey: req.IdempotencyKey,
+		To:             req.To,
+		ToList:         req.ToList,
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &chatpb.SendResponse{
+		Success:   res.Success,
+		Message:   res.Message,
+		MessageId: res.MessageID,
+		Delivered: int32(res.Delivered),
+		Dropped:   int32(res.Dropped),
+	}, nil
+}
+
+func (g *grpcServer) Leave(ctx context.Context, req *chatpb.LeaveRequest) (*chatpb.LeaveResponse, error) {
+	res, err := g.cs.Leave(ctx, model.LeaveRequest{ID: req.Id, Room: req.Room, Token: req.Token})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &chatpb.LeaveResponse{Success: res.Success, Message: res.Message}, nil
+}
+
+func (g *grpcServer) Receive(req *chatpb.ReceiveRequest, stream chatpb.Chat_ReceiveServer) error {
+	ctx := service.WithToken(stream.Context(), req.Token)
+	err := g.cs.Stream(ctx, req.Id, func(msg model.MessageResponse) error {
+		return stream.Send(&chatpb.Message{
+			Id:        msg.ID,
+			Type:      msg.Type,
+			From:      msg.From,
+			Text:      msg.Text,
+			Rendered:  msg.Message,
+			System:    msg.System,
+			Timestamp: timestamppb.New(msg.Timestamp),
+		})
+	})
+	if err != nil && ctx.Err() == nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+// grpcCodes maps the HTTP statuses of service errors to gRPC codes.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusRequestTimeout:        codes.DeadlineExceeded,
+	http.StatusConflict:              codes.AlreadyExists,
+	http.StatusGone:                  codes.Unavailable,
+	http.StatusRequestEntityTooLarge: codes.InvalidArgument,
+	http.StatusUnprocessableEntity:   codes.FailedPrecondition,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+}
+
+// grpcError converts a service error to a gRPC status whose message starts
+// with the error code.
+func grpcError(err error) error {
+	code, ok := grpcCodes[errcom.HTTPStatus(err)]
+	if !ok {
+		return status.Error(codes.Internal, "internal server error")
+	}
+	return status.Error(code, errcom.Code(err)+": "+errcom.Message(err))
+}
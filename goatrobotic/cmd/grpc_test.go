@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"chatbox/chatpb"
+	"chatbox/model"
+	"chatbox/service"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// grpcClient serves cs over an in-memory connection and returns a client
+// for it.
+func grpcClient(t *testing.T, cs service.ChatService) chatpb.ChatClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(cs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return chatpb.NewChatClient(conn)
+}
+
+// bearer returns ctx with token as the call's authorization metadata.
+func bearer(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+// wantStatus fails the test unless err is a gRPC status with code whose
+// message starts with the service error code.
+func wantStatus(t *testing.T, err error, code codes.Code, errCode string) {
+	t.Helper()
+	st, _ := status.FromError(err)
+	if st.Code() != code || !strings.HasPrefix(st.Message(), errCode+":") {
+		t.Fatalf("error = %v, want %v with %s", err, code, errCode)
+	}
+}
+
+func TestGRPCCredentialIdentity(t *testing.T) {
+	cs := service.NewChatServiceWithConfig(service.DefaultConfig())
+	t.Cleanup(func() { cs.Close() })
+	client := grpcClient(t, cs)
+	ctx := context.Background()
+
+	alice, err := client.Join(ctx, &chatpb.JoinRequest{Id: "alice", Room: "lobby"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := client.Join(ctx, &chatpb.JoinRequest{Id: "bob", Room: "lobby"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Send(bearer(ctx, alice.Token), &chatpb.SendRequest{From: "alice", Message: "hi"}); err != nil {
+		t.Fatalf("Send as the authenticated user: %v", err)
+	}
+	_, err = client.Send(bearer(ctx, alice.Token), &chatpb.SendRequest{From: "bob", Token: bob.Token, Message: "hi"})
+	wantStatus(t, err, codes.PermissionDenied, "ERR_IDENTITY_MISMATCH")
+}
+
+func TestGRPCRoundTrip(t *testing.T) {
+	cs := service.NewChatServiceWithConfig(service.DefaultConfig())
+	t.Cleanup(func() { cs.Close() })
+	client := grpcClient(t, cs)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alice, err := client.Join(ctx, &chatpb.JoinRequest{Id: "alice", Room: "lobby"})
+	if err != nil || !alice.Success || alice.Token == "" || alice.Room != "lobby" {
+		t.Fatalf("Join(alice) = %v, %v", alice, err)
+	}
+	bob, err := client.Join(ctx, &chatpb.JoinRequest{Id: "bob", Room: "lobby"})
+	if err != nil {
+		t.Fatalf("Join(bob): %v", err)
+	}
+
+	stream, err := client.Receive(ctx, &chatpb.ReceiveRequest{Id: "bob", Token: bob.Token})
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	sent, err := client.Send(ctx, &chatpb.SendRequest{From: "alice", Token: alice.Token, Message: "hello"})
+	if err != nil || !sent.Success || sent.MessageId == "" {
+		t.Fatalf("Send = %v, %v", sent, err)
+	}
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		if msg.Type != model.MessageTypeChat {
+			continue
+		}
+		if msg.Id != sent.MessageId || msg.From != "alice" || msg.Text != "hello" || msg.Timestamp == nil {
+			t.Fatalf("bob received %v, want alice's message %s", msg, sent.MessageId)
+		}
+		break
+	}
+
+	left, err := client.Leave(ctx, &chatpb.LeaveRequest{Id: "alice", Token: alice.Token})
+	if err != nil || !left.Success {
+		t.Fatalf("Leave = %v, %v", left, err)
+	}
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	cs := service.NewChatServiceWithConfig(service.DefaultConfig())
+	t.Cleanup(func() { cs.Close() })
+	client := grpcClient(t, cs)
+	ctx := context.Background()
+	alice, err := client.Join(ctx, &chatpb.JoinRequest{Id: "alice", Room: "lobby"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Join(ctx, &chatpb.JoinRequest{Id: "alice", Room: "lobby"})
+	wantStatus(t, err, codes.AlreadyExists, "ERR_ALREADY_JOINED")
+	_, err = client.Join(ctx, &chatpb.JoinRequest{})
+	wantStatus(t, err, codes.InvalidArgument, "ERR_MISSING_USER_ID")
+	_, err = client.Send(ctx, &chatpb.SendRequest{From: "alice", Token: "not-a-token", Message: "hi"})
+	wantStatus(t, err, codes.Unauthenticated, "ERR_UNAUTHORIZED")
+	_, err = client.Send(ctx, &chatpb.SendRequest{From: "alice", Token: alice.Token, To: "carol", Message: "hi"})
+	wantStatus(t, err, codes.NotFound, "ERR_RECIPIENT_NOT_FOUND")
+	_, err = client.Leave(ctx, &chatpb.LeaveRequest{Id: "carol"})
+	wantStatus(t, err, codes.NotFound, "ERR_USER_NOT_FOUND")
+
+	stream, err := client.Receive(ctx, &chatpb.ReceiveRequest{Id: "alice", Token: "not-a-token"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	wantStatus(t, err, codes.Unauthenticated, "ERR_UNAUTHORIZED")
+}
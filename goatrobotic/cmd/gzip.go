@@ -0,0 +1,47 @@
+package main
+
+import (
+	"compress/gzip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipped compresses the response for clients that accept gzip. It buffers
+// output in the compressor, so it must not be used on streaming routes such
+// as /stream and /ws.
+func gzipped() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+			c.Next()
+			return
+		}
+		w := &gzipWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		if w.gz != nil {
+			w.gz.Close()
+		}
+	}
+}
+
+// gzipWriter starts compressing on the first write, so bodiless responses
+// such as 204 are sent without an encoding.
+type gzipWriter struct {
+	gin.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.gz == nil {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	return w.gz.Write(b)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chatbox/model"
+	"chatbox/service"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+func TestGzippedHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := service.DefaultConfig()
+	cfg.RateLimit = rate.Inf
+	cs := service.NewChatServiceWithConfig(cfg)
+	t.Cleanup(func() { cs.Close() })
+	ctx := context.Background()
+	alice, err := cs.Join(ctx, model.JoinRequest{ID: "alice", Room: "lobby"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Repeat("a long message near the length cap ", 10)
+	for range 20 {
+		if _, err := cs.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice.Token, Message: text}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+
+	r := gin.New()
+	r.GET("/history/:room", gzipped(), historyHandler(cs))
+	r.GET("/empty", gzipped(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	get := func(path, acceptEncoding string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+	decode := func(t *testing.T, body io.Reader) {
+		t.Helper()
+		var res model.HistoryResponse
+		if err := json.NewDecoder(body).Decode(&res); err != nil || len(res.Messages) != 20 {
+			t.Fatalf("decoded %d messages, %v; want 20", len(res.Messages), err)
+		}
+	}
+
+	t.Run("accepts gzip", func(t *testing.T) {
+		resp := get("/history/lobby?limit=50", "gzip, deflate")
+		if resp.Header.Get("Content-Encoding") != "gzip" {
+			t.Fatalf("Content-Encoding = %q, want gzip", resp.Header.Get("Content-Encoding"))
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		gz, err := gzip.NewReader(strings.NewReader(string(raw)))
+		if err != nil {
+			t.Fatalf("body is not gzip: %v", err)
+		}
+		decode(t, gz)
+		if len(raw) >= 20*len(text) {
+			t.Errorf("compressed body is %d bytes, no smaller than the messages", len(raw))
+		}
+	})
+	t.Run("plain", func(t *testing.T) {
+		resp := get("/history/lobby?limit=50", "")
+		if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+			t.Fatalf("Content-Encoding = %q without Accept-Encoding", enc)
+		}
+		if resp.Header.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Vary = %q, want Accept-Encoding", resp.Header.Get("Vary"))
+		}
+		decode(t, resp.Body)
+	})
+	t.Run("no body", func(t *testing.T) {
+		resp := get("/empty", "gzip")
+		if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Content-Encoding") != "" {
+			t.Fatalf("204 = %d with Content-Encoding %q, want no encoding", resp.StatusCode, resp.Header.Get("Content-Encoding"))
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxLoggedBody bounds how much of a request or error response is read for
+// the request log.
+const maxLoggedBody = 64 << 10
+
+// newRequestLogger builds the JSON request logger from CHAT_LOG_LEVEL
+// (debug, info, warn or error; default info) and CHAT_LOG_FILE (default
+// stderr).
+func newRequestLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("CHAT_LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	var out io.Writer = os.Stderr
+	if name := os.Getenv("CHAT_LOG_FILE"); name != "" {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	return slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})), nil
+}
+
+// requestLog logs one line per request with the acting user, status,
+// latency, response size and, on failure, the error code. Message bodies are
+// only logged when logBodies is set.
+func requestLog(logger *slog.Logger, logBodies bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		fields := requestFields(c)
+		w := &errorCapture{ResponseWriter: c.Writer}
+		c.Writer = w
+
+		c.Next()
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.FullPath()),
+			slog.Int("status", w.Status()),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", w.Size()),
+		}
+		if id := c.Param("id"); id != "" {
+			fields.ID = id
+		}
+		if fields.ID == "" {
+			fields.ID = fields.From
+		}
+		if fields.ID != "" {
+			attrs = append(attrs, slog.String("user", fields.ID))
+		}
+		if logBodies && fields.Message != "" {
+			attrs = append(attrs, slog.String("message", fields.Message))
+		}
+		level := slog.LevelInfo
+		if w.Status() >= 400 {
+			level = slog.LevelWarn
+			var body struct {
+				Code string `json:"code"`
+			}
+			if json.Unmarshal(w.body.Bytes(), &body) == nil && body.Code != "" {
+				attrs = append(attrs, slog.String("code", body.Code))
+			}
+		}
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// loggedFields are the request body fields the request log picks out.
+type loggedFields struct {
+	ID      string `json:"id"`
+	From    string `json:"from"`
+	Message string `json:"message"`
+}
+
+// requestFields peeks at a JSON request body, leaving it intact for the
+// handler.
+func requestFields(c *gin.Context) loggedFields {
+	var fields loggedFields
+	if c.Request.Body == nil || !strings.HasPrefix(c.ContentType(), "application/json") {
+		return fields
+	}
+	head, _ := io.ReadAll(io.LimitReader(c.Request.Body, maxLoggedBody))
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), c.Request.Body), c.Request.Body}
+	json.Unmarshal(head, &fields)
+	return fields
+}
+
+// errorCapture keeps the start of error responses so their code can be
+// logged.
+type errorCapture struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *errorCapture) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorCapture) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *errorCapture) capture(b []byte) {
+	if w.Status() >= 400 && w.body.Len() < maxLoggedBody {
+		w.body.Write(b[:min(len(b), maxLoggedBody-w.body.Len())])
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"chatbox/model"
+	"chatbox/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logLines parses the JSON lines written to buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	buf.Reset()
+	return lines
+}
+
+func TestRequestLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cs := service.NewChatServiceWithConfig(service.DefaultConfig())
+	t.Cleanup(func() { cs.Close() })
+	alice, err := cs.Join(context.Background(), model.JoinRequest{ID: "alice", Room: "lobby"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, logBodies := range []bool{false, true} {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, nil))
+		r := gin.New()
+		r.Use(requestLog(logger, logBodies))
+		r.POST("/send", sendHandler(cs))
+
+		// A failed send is logged as a warning with its error code.
+		rec := do(r, http.MethodPost, "/send", "", `{"from":"alice","token":"wrong","message":"secret plans"}`)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("POST /send = %d, want 401", rec.Code)
+		}
+		lines := logLines(t, &buf)
+		if len(lines) != 1 {
+			t.Fatalf("logged %d lines, want 1", len(lines))
+		}
+		entry := lines[0]
+		for field, want := range map[string]any{
+			"level":  "WARN",
+			"msg":    "request",
+			"method": "POST",
+			"path":   "/send",
+			"status": float64(http.StatusUnauthorized),
+			"bytes":  float64(rec.Body.Len()),
+			"user":   "alice",
+			"code":   "ERR_UNAUTHORIZED",
+		} {
+			if entry[field] != want {
+				t.Errorf("%s = %v, want %v", field, entry[field], want)
+			}
+		}
+		if _, ok := entry["latency"].(float64); !ok {
+			t.Errorf("latency = %v, want a duration", entry["latency"])
+		}
+		if got, logged := entry["message"]; logged != logBodies || (logBodies && got != "secret plans") {
+			t.Errorf("message = %v with logBodies %v", got, logBodies)
+		}
+
+		// The handler still saw the whole body: a valid send goes through.
+		rec = do(r, http.MethodPost, "/send", "", `{"from":"alice","token":"`+alice.Token+`","message":"hi"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST /send = %d %s, want 200", rec.Code, rec.Body)
+		}
+		entry = logLines(t, &buf)[0]
+		if entry["level"] != "INFO" || entry["status"] != float64(http.StatusOK) {
+			t.Errorf("successful send logged as %v", entry)
+		}
+		if _, ok := entry["code"]; ok {
+			t.Errorf("successful send logged code %v", entry["code"])
+		}
+	}
+}
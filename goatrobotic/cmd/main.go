@@ -1,69 +1,860 @@
-package main
-
-import (
-	"chatbox/model"
-	"chatbox/service"
-	"net/http"
-
-	"github.com/gin-gonic/gin"
-)
-
-func main() {
-	r := gin.Default()
-	cs := service.NewChatService()
-
-	r.POST("/join", func(c *gin.Context) {
-		var req model.JoinRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-			return
-		}
-		res, err := cs.Join(c.Request.Context(), req)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, res)
-	})
-
-	r.POST("/send", func(c *gin.Context) {
-		var req model.SendMessageRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-			return
-		}
-		res, err := cs.SendMessage(c.Request.Context(), req)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, res)
-	})
-
-	r.POST("/leave", func(c *gin.Context) {
-		var req model.LeaveRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-			return
-		}
-		res, err := cs.Leave(c.Request.Context(), req)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, res)
-	})
-
-	r.GET("/receive/:id", func(c *gin.Context) {
-		id := c.Param("id")
-		req := model.MessageRequest{ID: id}
-		res, err := cs.GetMessage(c.Request.Context(), req)
-		if err != nil {
-			c.JSON(http.StatusRequestTimeout, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, res)
-	})
-
-	r.Run(":8080") // start server on port 8080
-}
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+	"chatbox/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+)
+
+// drainRetryAfter is the Retry-After hint, in seconds, sent with joins
+// rejected while draining.
+const drainRetryAfter = "30"
+
+// shutdownTimeout bounds how long a signalled shutdown waits for the chat
+// service and in-flight requests.
+const shutdownTimeout = 15 * time.Second
+
+// defaultListenAddr is where the chat API is served unless CHAT_ADDR says
+// otherwise.
+const defaultListenAddr = ":8080"
+
+func main() {
+	started := time.Now()
+	// ready is set once the server is listening and cleared as soon as a
+	// shutdown begins.
+	var ready atomic.Bool
+
+	logger, err := newRequestLogger()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	useJSONFieldNames()
+	r := gin.Default()
+	r.Use(cors(corsFromEnv()))
+	r.Use(bodyLimit(maxBodyBytesFromEnv()))
+	r.Use(requestLog(logger, os.Getenv("CHAT_LOG_BODIES") == "true"))
+	r.Use(traceContext())
+	cfg := service.DefaultConfig()
+	// Every Config field can be set from CHAT_<FIELD>, e.g.
+	// CHAT_ADMIN_SECRET or CHAT_IDLE_TIMEOUT.
+	if err := service.LoadEnv(&cfg, os.Getenv); err != nil {
+		log.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	cfg.Tracer = otel.Tracer("chatbox")
+	if addr := os.Getenv("CHAT_REDIS_ADDR"); addr != "" {
+		// Claims outlive a crashed instance by at most twice the idle timeout.
+		rdb := redis.NewClient(&redis.Options{Addr: addr})
+		cfg.Backend = service.NewRedisBackend(rdb, 2*cfg.IdleTimeout)
+	}
+	cs := service.NewChatServiceWithConfig(cfg)
+	r.Use(sessionToken(cs))
+
+	r.POST("/join", func(c *gin.Context) {
+		var req model.JoinRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		req.IP = c.ClientIP()
+		res, err := cs.Join(c.Request.Context(), req)
+		if err != nil {
+			if errcom.Code(err) == "ERR_DRAINING" {
+				c.Header("Retry-After", drainRetryAfter)
+			}
+			retryAfter(c, err)
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/send", sendHandler(cs))
+
+	r.POST("/send-batch", func(c *gin.Context) {
+		var req model.SendBatchRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.SendBatch(c.Request.Context(), req)
+		if err != nil {
+			retryAfter(c, err)
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/rename", func(c *gin.Context) {
+		var req model.RenameRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Rename(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/report", func(c *gin.Context) {
+		var req model.ReportRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Report(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/status", func(c *gin.Context) {
+		var req model.StatusRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.SetStatus(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/ping", func(c *gin.Context) {
+		var req model.PingRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Ping(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/ack", func(c *gin.Context) {
+		var req model.AckRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Ack(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.GET("/receipts/:messageID", func(c *gin.Context) {
+		req := model.ReceiptsRequest{ID: c.Query("id"), MessageID: c.Param("messageID")}
+		res, err := cs.GetReceipts(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/typing", func(c *gin.Context) {
+		var req model.TypingRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Typing(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/leave", func(c *gin.Context) {
+		var req model.LeaveRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Leave(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/block", func(c *gin.Context) {
+		var req model.BlockRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Block(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/unblock", func(c *gin.Context) {
+		var req model.BlockRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Unblock(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/attributes", func(c *gin.Context) {
+		var req model.AttributesRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.SetAttributes(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	timeoutAsError := os.Getenv("CHAT_RECEIVE_TIMEOUT_STATUS") == "408"
+	r.GET("/receive/:id", gzipped(), receiveHandler(cs, timeoutAsError))
+
+	r.GET("/stream/:id", func(c *gin.Context) {
+		serveSSE(c, cs)
+	})
+
+	upgrader := newUpgrader(cfg)
+	r.GET("/ws/:id", func(c *gin.Context) {
+		serveWS(c, cs, upgrader)
+	})
+
+	r.GET("/receive-batch/:id", gzipped(), func(c *gin.Context) {
+		max, _ := strconv.Atoi(c.Query("max"))
+		req := model.MessagesRequest{
+			ID:    c.Param("id"),
+			Max:   max,
+			Order: c.Query("order"),
+		}
+		res, err := cs.GetMessages(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.POST("/receive-multi", gzipped(), func(c *gin.Context) {
+		var req model.MultiReceiveRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.ReceiveMulti(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.GET("/deadletter/:id", func(c *gin.Context) {
+		res, err := cs.GetDeadLetters(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.GET("/ratelimit/:id", func(c *gin.Context) {
+		res, err := cs.GetRateLimit(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.GET("/unread/:id", func(c *gin.Context) {
+		res, err := cs.GetUnread(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.GET("/peek/:id", func(c *gin.Context) {
+		n, _ := strconv.Atoi(c.Query("n"))
+		res, err := cs.Peek(c.Request.Context(), model.PeekRequest{ID: c.Param("id"), Max: n})
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.GET("/history/:room", gzipped(), historyHandler(cs))
+
+	r.GET("/search", gzipped(), func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		req := model.SearchRequest{Room: c.Query("room"), Query: c.Query("q"), From: c.Query("from"), Limit: limit}
+		res, err := cs.Search(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	// Exports are audit data, so they need the admin token.
+	r.GET("/export/:room", adminAuth(cfg.AdminSecret), func(c *gin.Context) {
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "text" {
+			err := errcom.NewCustomError("ERR_INVALID_FORMAT", errors.New("format must be json or text"))
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.ExportRoom(c.Request.Context(), c.Param("room"))
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		if format == "json" {
+			c.JSON(http.StatusOK, res)
+			return
+		}
+		var b strings.Builder
+		for _, msg := range res.Messages {
+			b.WriteString(msg.Timestamp.Format(time.RFC3339) + " " + msg.Message + "\n")
+		}
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": res.Room + ".txt"}))
+		c.String(http.StatusOK, b.String())
+	})
+
+	r.GET("/users", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		req := model.UsersRequest{
+			Room:   c.Query("room"),
+			Stats:  c.Query("stats") == "1",
+			Limit:  limit,
+			Cursor: c.Query("cursor"),
+		}
+		res, err := cs.GetUsers(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.GET("/users/:id/room", func(c *gin.Context) {
+		res, err := cs.GetUserRooms(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.GET("/rooms", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		offset, _ := strconv.Atoi(c.Query("offset"))
+		req := model.ListRoomsRequest{
+			Limit:  limit,
+			Offset: offset,
+			Prefix: c.Query("prefix"),
+		}
+		res, err := cs.ListRooms(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	r.GET("/metrics", gin.WrapH(metricsHandler(cs)))
+
+	// A summary of the counters for small deployments without Prometheus.
+	// Unlike /admin/stats it names no rooms, so it needs no secret.
+	r.GET("/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, cs.Stats(c.Request.Context()))
+	})
+
+	r.GET("/health", health(started))
+
+	// Load balancers stop routing new traffic here while the service starts,
+	// drains or shuts down.
+	r.GET("/ready", func(c *gin.Context) {
+		if !ready.Load() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable"})
+			return
+		}
+		if cs.Draining() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	})
+
+	admin := r.Group("/admin", adminAuth(cfg.AdminSecret))
+
+	admin.GET("/config", func(c *gin.Context) {
+		c.JSON(http.StatusOK, cs.EffectiveConfig(c.Request.Context()))
+	})
+
+	admin.GET("/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, cs.SnapshotStats(false))
+	})
+
+	admin.POST("/stats/reset", func(c *gin.Context) {
+		c.JSON(http.StatusOK, cs.SnapshotStats(true))
+	})
+
+	admin.GET("/moderation", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"pending": cs.ListQuarantine(c.Request.Context())})
+	})
+
+	admin.POST("/moderate", func(c *gin.Context) {
+		var req model.ModerateRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Moderate(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	admin.GET("/reports", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"reports": cs.ListReports(c.Request.Context())})
+	})
+
+	admin.POST("/moderators", func(c *gin.Context) {
+		var req model.ModeratorRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.SetModerator(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	admin.POST("/kick", func(c *gin.Context) {
+		var req model.KickRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Kick(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	admin.POST("/disconnect-all", func(c *gin.Context) {
+		var req model.DisconnectAllRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.DisconnectAll(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	admin.POST("/broadcast", func(c *gin.Context) {
+		var req model.AnnounceRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Announce(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	admin.POST("/ban", func(c *gin.Context) {
+		var req model.BanRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Ban(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	admin.POST("/unban", func(c *gin.Context) {
+		var req model.BanRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.Unban(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	admin.POST("/rooms/limits", func(c *gin.Context) {
+		var req model.RoomLimitsRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.SetRoomLimits(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	})
+
+	admin.POST("/drain", func(c *gin.Context) {
+		cs.SetDraining(true)
+		c.JSON(http.StatusOK, gin.H{"draining": true})
+	})
+
+	admin.POST("/undrain", func(c *gin.Context) {
+		cs.SetDraining(false)
+		c.JSON(http.StatusOK, gin.H{"draining": false})
+	})
+
+	handler := routes(r, os.Getenv("CHAT_STRICT_ROUTES") == "true")
+
+	listenAddr := os.Getenv("CHAT_ADDR")
+	if listenAddr == "" {
+		listenAddr = defaultListenAddr
+	}
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{Handler: handler}
+	// With CHAT_TLS_CERT and CHAT_TLS_KEY the API is served over HTTPS,
+	// and CHAT_TLS_REDIRECT_ADDR optionally redirects plain HTTP to it.
+	certFile, keyFile := os.Getenv("CHAT_TLS_CERT"), os.Getenv("CHAT_TLS_KEY")
+	useTLS := certFile != "" && keyFile != ""
+	go func() {
+		if err := serve(srv, ln, certFile, keyFile); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	// CHAT_GRPC_ADDR serves the gRPC API alongside the HTTP one.
+	var grpcSrv *grpc.Server
+	if addr := os.Getenv("CHAT_GRPC_ADDR"); addr != "" {
+		gln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		grpcSrv = newGRPCServer(cs)
+		go func() {
+			if err := grpcSrv.Serve(gln); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	var redirect *http.Server
+	if addr := os.Getenv("CHAT_TLS_REDIRECT_ADDR"); useTLS && addr != "" {
+		redirect = &http.Server{Addr: addr, Handler: redirectHTTPS(listenAddr)}
+		go func() {
+			if err := redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+	ready.Store(true)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	ready.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	// Shutting the chat service down first releases blocked receives and
+	// streams, so the server's own shutdown is not stuck behind them.
+	if err := cs.Shutdown(ctx); err != nil {
+		log.Printf("chat service shutdown: %v", err)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("http server shutdown: %v", err)
+	}
+	if redirect != nil {
+		redirect.Shutdown(ctx)
+	}
+	if grpcSrv != nil {
+		grpcSrv.GracefulStop()
+	}
+}
+
+// sendHandler sends the posted message.
+func sendHandler(cs service.ChatService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req model.SendMessageRequest
+		if err := bindJSON(c, &req); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		res, err := cs.SendMessage(c.Request.Context(), req)
+		if err != nil {
+			retryAfter(c, err)
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// receiveHandler receives the next message for the user in the path. A
+// receive that times out is answered with 204 so the client polls again, or
+// with ERR_NO_MESSAGES when timeoutAsError is set
+// (CHAT_RECEIVE_TIMEOUT_STATUS=408). Either way the service's polling hints
+// are sent as headers: Retry-After, X-Retry-After-Ms for clients that want
+// better than whole seconds, and X-Poll-Timeout-Ms.
+func receiveHandler(cs service.ChatService, timeoutAsError bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := model.MessageRequest{ID: c.Param("id"), Room: c.Query("room"), Since: c.Query("since"), Wait: c.Query("wait")}
+		res, err := cs.GetMessage(c.Request.Context(), req)
+		if err != nil {
+			if errcom.Code(err) == "ERR_NO_MESSAGES" {
+				retryAfter(c, err)
+				details := errcom.Details(err)
+				if ms, ok := details["retry_after_ms"].(int64); ok {
+					c.Header("X-Retry-After-Ms", strconv.FormatInt(ms, 10))
+				}
+				if ms, ok := details["poll_timeout_ms"].(int64); ok {
+					c.Header("X-Poll-Timeout-Ms", strconv.FormatInt(ms, 10))
+				}
+				if !timeoutAsError {
+					c.Status(http.StatusNoContent)
+					return
+				}
+			}
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// historyHandler serves a page of a room's history.
+func historyHandler(cs service.ChatService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		req := model.HistoryRequest{Room: c.Param("room"), Limit: limit, Cursor: c.Query("cursor")}
+		res, err := cs.GetHistory(c.Request.Context(), req)
+		if err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// health reports that the server is up and for how long.
+func health(started time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "uptime": time.Since(started).Round(time.Second).String()})
+	}
+}
+
+// serve serves srv on ln until it is shut down: over HTTPS when certFile and
+// keyFile are both set, and plain HTTP otherwise.
+func serve(srv *http.Server, ln net.Listener, certFile, keyFile string) error {
+	var err error
+	if certFile != "" && keyFile != "" {
+		err = srv.ServeTLS(ln, certFile, keyFile)
+	} else {
+		err = srv.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// redirectHTTPS sends every request to the same path over HTTPS on the port
+// of tlsAddr.
+func redirectHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = req.Host
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// routes returns the handler serving r. Unless strict is set, paths like
+// /receive/alice/ and //send are cleaned to their canonical route before gin
+// sees them; strict routing answers them with 404.
+func routes(r *gin.Engine, strict bool) http.Handler {
+	if strict {
+		r.RedirectTrailingSlash = false
+		return r
+	}
+	return cleanPath(r)
+}
+
+// cleanPath rewrites the request path to its lexically cleaned form, so
+// trailing and repeated slashes resolve to the canonical route without a
+// redirect that would drop POST bodies.
+func cleanPath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if p := path.Clean("/" + req.URL.Path); p != req.URL.Path {
+			req.URL.Path = p
+			req.URL.RawPath = ""
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// adminAuth rejects requests whose X-Admin-Token header does not match secret.
+// An empty secret disables the admin API entirely.
+func adminAuth(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Admin-Token")
+		if secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			err := errcom.NewCustomError("ERR_UNAUTHORIZED", errors.New("admin token required"))
+			c.AbortWithStatusJSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+		c.Next()
+	}
+}
+
+// traceContext continues the caller's trace, carried in W3C trace context
+// headers, in the request context.
+func traceContext() gin.HandlerFunc {
+	propagator := propagation.TraceContext{}
+	return func(c *gin.Context) {
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// sessionToken passes a bearer token from the Authorization header to the
+// service, which checks it against the user the request acts as. Event
+// streams may use a token query parameter instead, as EventSource cannot set
+// headers.
+func sessionToken(cs service.ChatService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			token = c.Query("token")
+		}
+		if token != "" {
+			c.Request = c.Request.WithContext(withCredential(c.Request.Context(), cs, token))
+		}
+		c.Next()
+	}
+}
+
+// withCredential attaches token to ctx and, when it belongs to a live
+// session, the identity of its user, so that the service refuses requests
+// acting as anyone else. An unknown token adds no identity; the service
+// rejects it wherever a token is required.
+func withCredential(ctx context.Context, cs service.ChatService, token string) context.Context {
+	ctx = service.WithToken(ctx, token)
+	if id, err := cs.Authenticate(ctx, token); err == nil {
+		ctx = service.WithIdentity(ctx, id)
+	}
+	return ctx
+}
+
+// retryAfter sets the Retry-After header, in whole seconds rounded up, from
+// the retry_after_ms detail of err if it has one.
+func retryAfter(c *gin.Context, err error) {
+	ms, ok := errcom.Details(err)["retry_after_ms"].(int64)
+	if !ok {
+		return
+	}
+	c.Header("Retry-After", strconv.FormatInt((ms+999)/1000, 10))
+}
+
+// errorBody renders err for a JSON response as its code and message, with any
+// details attached. Errors that are not CustomErrors are reported as
+// ERR_INTERNAL without their text.
+func errorBody(err error) gin.H {
+	code := errcom.Code(err)
+	if code == "" {
+		return gin.H{"code": "ERR_INTERNAL", "message": "internal server error"}
+	}
+	body := gin.H{"code": code, "message": errcom.Message(err)}
+	if details := errcom.Details(err); details != nil {
+		body["details"] = details
+	}
+	return body
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"chatbox/model"
+	"chatbox/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// do serves one request through h, with a bearer token when token is set,
+// and returns the recorded reply.
+func do(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// errorCode returns the code of an error reply.
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return body.Code
+}
+
+func TestSessionTokenIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cs := service.NewChatServiceWithConfig(service.DefaultConfig())
+	t.Cleanup(func() { cs.Close() })
+	ctx := context.Background()
+	alice, err := cs.Join(ctx, model.JoinRequest{ID: "alice", Room: "lobby"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := cs.Join(ctx, model.JoinRequest{ID: "bob", Room: "lobby"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(sessionToken(cs))
+	r.POST("/send", sendHandler(cs))
+
+	tests := []struct {
+		name   string
+		bearer string
+		body   string
+		status int
+		code   string
+	}{
+		{name: "matching identity", bearer: alice.Token, body: `{"from":"alice","message":"hi"}`, status: http.StatusOK},
+		{
+			name:   "mismatched identity",
+			bearer: alice.Token,
+			body:   `{"from":"bob","token":"` + bob.Token + `","message":"hi"}`,
+			status: http.StatusForbidden,
+			code:   "ERR_IDENTITY_MISMATCH",
+		},
+		{name: "unknown bearer token", bearer: "not-a-token", body: `{"from":"alice","message":"hi"}`, status: http.StatusUnauthorized, code: "ERR_UNAUTHORIZED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(r, http.MethodPost, "/send", tt.bearer, tt.body)
+			if rec.Code != tt.status || errorCode(t, rec) != tt.code {
+				t.Fatalf("POST /send = %d %s, want %d with code %q", rec.Code, rec.Body, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+// TestReceiveTimeout checks that a receive that times out carries the
+// service's polling hints, whether it is answered with 204 or as an error.
+func TestReceiveTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cs := service.NewChatServiceWithConfig(service.DefaultConfig())
+	t.Cleanup(func() { cs.Close() })
+	alice, err := cs.Join(context.Background(), model.JoinRequest{ID: "alice", Room: "lobby"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name           string
+		timeoutAsError bool
+		status         int
+		code           string
+	}{
+		{name: "204", status: http.StatusNoContent},
+		{name: "408", timeoutAsError: true, status: http.StatusRequestTimeout, code: "ERR_NO_MESSAGES"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(sessionToken(cs))
+			r.GET("/receive/:id", receiveHandler(cs, tt.timeoutAsError))
+			rec := do(r, http.MethodGet, "/receive/alice?wait=10ms", alice.Token, "")
+			if rec.Code != tt.status || errorCode(t, rec) != tt.code {
+				t.Fatalf("GET /receive = %d %s, want %d with code %q", rec.Code, rec.Body, tt.status, tt.code)
+			}
+			if got := rec.Header().Get("Retry-After"); got != "1" {
+				t.Errorf("Retry-After = %q, want 1", got)
+			}
+			if got := rec.Header().Get("X-Retry-After-Ms"); got != "100" {
+				t.Errorf("X-Retry-After-Ms = %q, want 100", got)
+			}
+			if got := rec.Header().Get("X-Poll-Timeout-Ms"); got != "10" {
+				t.Errorf("X-Poll-Timeout-Ms = %q, want 10", got)
+			}
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		method, path string
+		clean        int
+		strict       int
+	}{
+		{method: http.MethodPost, path: "/send", clean: http.StatusOK, strict: http.StatusOK},
+		{method: http.MethodPost, path: "/send/", clean: http.StatusOK, strict: http.StatusNotFound},
+		{method: http.MethodPost, path: "//send", clean: http.StatusOK, strict: http.StatusNotFound},
+		{method: http.MethodGet, path: "/receive/alice", clean: http.StatusOK, strict: http.StatusOK},
+		{method: http.MethodGet, path: "/receive/alice/", clean: http.StatusOK, strict: http.StatusNotFound},
+		{method: http.MethodGet, path: "/receive//alice", clean: http.StatusOK, strict: http.StatusNotFound},
+		{method: http.MethodGet, path: "//receive/alice//", clean: http.StatusOK, strict: http.StatusNotFound},
+	}
+	for _, strict := range []bool{false, true} {
+		r := gin.New()
+		r.POST("/send", func(c *gin.Context) {
+			var req model.SendMessageRequest
+			bindJSON(c, &req)
+			c.String(http.StatusOK, req.Message)
+		})
+		r.GET("/receive/:id", func(c *gin.Context) { c.String(http.StatusOK, c.Param("id")) })
+		h := routes(r, strict)
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("strict=%v %s %s", strict, tt.method, tt.path), func(t *testing.T) {
+				want := tt.clean
+				if strict {
+					want = tt.strict
+				}
+				rec := do(h, tt.method, tt.path, "", `{"message":"hi"}`)
+				if rec.Code != want {
+					t.Fatalf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, want)
+				}
+				// The POST body survives, and the user ID is not mangled.
+				if body := rec.Body.String(); want == http.StatusOK && body != "hi" && body != "alice" {
+					t.Fatalf("%s %s replied %q", tt.method, tt.path, body)
+				}
+			})
+		}
+	}
+}
+
+// selfSignedCert writes a certificate for 127.0.0.1 and its key to dir and
+// returns their paths and a pool trusting the certificate.
+func selfSignedCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "chatbox test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+	cert, _ := x509.ParseCertificate(der)
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+// startServer serves /health on a local port with serve and returns its
+// address.
+func startServer(t *testing.T, certFile, keyFile string) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/health", health(time.Now()))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: r}
+	served := make(chan error, 1)
+	go func() { served <- serve(srv, ln, certFile, keyFile) }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-served; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile, pool := selfSignedCert(t, t.TempDir())
+	addr := startServer(t, certFile, keyFile)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get("https://" + addr + "/health")
+	if err != nil {
+		t.Fatalf("GET /health over TLS: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil || !resp.TLS.HandshakeComplete {
+		t.Fatalf("GET /health = %d, TLS %+v; want 200 after a handshake", resp.StatusCode, resp.TLS)
+	}
+
+	// The same port does not answer plain HTTP.
+	resp, err = http.Get("http://" + addr + "/health")
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Fatal("plain HTTP request to the TLS listener succeeded")
+		}
+	}
+}
+
+func TestServeWithoutCertificate(t *testing.T) {
+	addr := startServer(t, "", "")
+	resp, err := http.Get("http://" + addr + "/health")
+	if err != nil {
+		t.Fatalf("GET /health: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS != nil {
+		t.Fatalf("GET /health = %d, TLS %v; want plain 200", resp.StatusCode, resp.TLS)
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		tlsAddr, host, want string
+	}{
+		{tlsAddr: ":8443", host: "chat.example.com", want: "https://chat.example.com:8443/send?x=1"},
+		{tlsAddr: ":8443", host: "chat.example.com:8080", want: "https://chat.example.com:8443/send?x=1"},
+		{tlsAddr: ":443", host: "chat.example.com:80", want: "https://chat.example.com/send?x=1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/send?x=1", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		redirectHTTPS(tt.tlsAddr).ServeHTTP(rec, req)
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tt.want {
+			t.Errorf("redirect from %s to %s = %d %q, want 308 %q", tt.host, tt.tlsAddr, rec.Code, rec.Header().Get("Location"), tt.want)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+
+	"chatbox/service"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsHandler serves the metrics of cs along with the Go runtime's and
+// the process's. The legacy text format stays the default; scrapers that
+// send an OpenMetrics Accept header get OpenMetrics, which carries the trace
+// exemplars of the receive wait histogram.
+func metricsHandler(cs service.ChatService) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		service.NewCollector(cs),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chatbox/model"
+	"chatbox/service"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func scrape(t *testing.T, h http.Handler, accept string) (contentType, body string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	b, _ := io.ReadAll(rec.Result().Body)
+	return rec.Header().Get("Content-Type"), string(b)
+}
+
+func TestMetricsNegotiationAndExemplars(t *testing.T) {
+	cs := service.NewChatServiceWithConfig(service.DefaultConfig())
+	defer cs.Close()
+	res, err := cs.Join(context.Background(), model.JoinRequest{ID: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Join(context.Background(), model.JoinRequest{ID: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	// bob's join notice is waiting, so this returns at once.
+	if _, err := cs.GetMessage(ctx, model.MessageRequest{ID: "alice", Token: res.Token}); err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	h := metricsHandler(cs)
+	contentType, body := scrape(t, h, "")
+	if !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("default Content-Type = %q, want the text format", contentType)
+	}
+	if !strings.Contains(body, "chat_receive_wait_seconds_count 1") {
+		t.Errorf("text format is missing the receive wait histogram:\n%s", body)
+	}
+
+	contentType, body = scrape(t, h, "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	if !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("OpenMetrics Content-Type = %q", contentType)
+	}
+	if !strings.Contains(body, `# {trace_id="`+traceID.String()+`"}`) {
+		t.Errorf("OpenMetrics output has no exemplar for the trace:\n%s", body)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(body), "# EOF") {
+		t.Error("OpenMetrics output does not end with # EOF")
+	}
+}
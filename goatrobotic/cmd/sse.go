@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+	"chatbox/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseKeepAlive is how often an idle event stream gets a comment line so
+// proxies do not drop the connection.
+const sseKeepAlive = 15 * time.Second
+
+// serveSSE streams the joined user's messages as Server-Sent Events until the
+// client disconnects or its stream is closed.
+func serveSSE(c *gin.Context, cs service.ChatService) {
+	id := c.Param("id")
+	ctx := c.Request.Context()
+	if c.Query("heartbeat") == "1" {
+		// The session token is taken from the request's context.
+		if err := cs.StartHeartbeat(ctx, id, ""); err != nil {
+			c.JSON(errcom.HTTPStatus(err), errorBody(err))
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	// Frames and keepalives are written from different goroutines.
+	var writeMu sync.Mutex
+	wrote := false
+	write := func(frame string) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if _, err := fmt.Fprint(c.Writer, frame); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		wrote = true
+		return nil
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(sseKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if write(":keepalive\n\n") != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	err := cs.Stream(ctx, id, func(msg model.MessageResponse) error {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return write("data: " + string(data) + "\n\n")
+	})
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	if err != nil && !wrote && ctx.Err() == nil {
+		// Nothing has been streamed yet, so the error can still be a
+		// plain JSON reply.
+		c.Header("Content-Type", "application/json")
+		c.JSON(errcom.HTTPStatus(err), errorBody(err))
+	}
+}
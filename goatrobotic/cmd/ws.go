@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+	"chatbox/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// newUpgrader returns the WebSocket upgrader for cfg. With cfg.WSCompression
+// it accepts permessage-deflate from clients that offer it; other clients,
+// and every client when it is off, get uncompressed frames.
+func newUpgrader(cfg service.Config) *websocket.Upgrader {
+	return &websocket.Upgrader{EnableCompression: cfg.WSCompression}
+}
+
+// serveWS upgrades the request to a WebSocket for the user in the path. The
+// socket joins like POST /join, in the room named by ?room=, and receives the
+// user's messages as JSON frames; every inbound text frame is sent as if
+// posted to /send. Closing the socket leaves.
+func serveWS(c *gin.Context, cs service.ChatService, upgrader *websocket.Upgrader) {
+	id := c.Param("id")
+	join := model.JoinRequest{ID: id, Room: c.Query("room"), IP: c.ClientIP()}
+	joined, err := cs.Join(c.Request.Context(), join)
+	if err != nil {
+		c.JSON(errcom.HTTPStatus(err), errorBody(err))
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade has already replied with an HTTP error.
+		cs.Leave(context.Background(), model.LeaveRequest{ID: id, Token: joined.Token})
+		return
+	}
+	// Only takes effect when the client negotiated permessage-deflate.
+	conn.EnableWriteCompression(upgrader.EnableCompression)
+
+	// Keep the request's values, such as the caller's identity, but not its
+	// cancellation, which no longer tracks the hijacked connection.
+	ctx, cancel := context.WithCancel(service.WithToken(context.WithoutCancel(c.Request.Context()), joined.Token))
+	defer func() {
+		cancel()
+		cs.Leave(context.Background(), model.LeaveRequest{ID: id, Token: joined.Token})
+		conn.Close()
+	}()
+
+	// gorilla/websocket allows a single concurrent writer.
+	var writeMu sync.Mutex
+	write := func(v any) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(v)
+	}
+
+	if c.Query("heartbeat") == "1" {
+		cs.StartHeartbeat(ctx, id, joined.Token)
+	}
+
+	go func() {
+		defer cancel()
+		for {
+			kind, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if kind != websocket.TextMessage {
+				continue
+			}
+			if _, err := cs.SendMessage(ctx, model.SendMessageRequest{From: id, Message: string(data)}); err != nil {
+				if write(errorBody(err)) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	cs.Stream(ctx, id, func(msg model.MessageResponse) error {
+		return write(msg)
+	})
+}
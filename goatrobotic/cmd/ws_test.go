@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatbox/model"
+	"chatbox/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// wsServer serves /ws/:id from a fresh service built from cfg.
+func wsServer(t *testing.T, cfg service.Config) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cs := service.NewChatServiceWithConfig(cfg)
+	t.Cleanup(func() { cs.Close() })
+	r := gin.New()
+	upgrader := newUpgrader(cfg)
+	r.GET("/ws/:id", func(c *gin.Context) {
+		serveWS(c, cs, upgrader)
+	})
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWSCompressionNegotiation(t *testing.T) {
+	tests := []struct {
+		name           string
+		server, client bool
+		want           bool
+	}{
+		{name: "both enabled", server: true, client: true, want: true},
+		{name: "client does not offer", server: true, client: false},
+		{name: "server disabled", server: false, client: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := service.DefaultConfig()
+			cfg.WSCompression = tt.server
+			srv := wsServer(t, cfg)
+			dialer := websocket.Dialer{EnableCompression: tt.client}
+			url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/"
+
+			alice, resp, err := dialer.Dial(url+"alice?room=lobby", nil)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer alice.Close()
+			ext := resp.Header.Get("Sec-WebSocket-Extensions")
+			if got := strings.Contains(ext, "permessage-deflate"); got != tt.want {
+				t.Fatalf("Sec-WebSocket-Extensions = %q, negotiated %v, want %v", ext, got, tt.want)
+			}
+
+			// Frames still flow both ways with whatever was negotiated.
+			bob, _, err := dialer.Dial(url+"bob?room=lobby", nil)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer bob.Close()
+			bob.EnableWriteCompression(tt.client)
+			alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+			var msg model.MessageResponse
+			for msg.Type != model.MessageTypePresence {
+				if err := alice.ReadJSON(&msg); err != nil {
+					t.Fatalf("reading bob's join: %v", err)
+				}
+			}
+			if err := bob.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			for msg.Type != model.MessageTypeChat {
+				if err := alice.ReadJSON(&msg); err != nil {
+					t.Fatalf("reading bob's message: %v", err)
+				}
+			}
+			if msg.Text != "hello" {
+				t.Fatalf("alice received %q, want hello", msg.Text)
+			}
+		})
+	}
+}
@@ -1,19 +1,76 @@
-package errcom
-
-import "fmt"
-
-type CustomError struct {
-	Code string
-	Err  error
-}
-
-func (e *CustomError) Error() string {
-	return fmt.Sprintf("[%s] %s", e.Code, e.Err.Error())
-}
-
-func NewCustomError(code string, err error) error {
-	return &CustomError{
-		Code: code,
-		Err:  err,
-	}
-}
+package errcom
+
+import (
+	"errors"
+	"fmt"
+)
+
+type CustomError struct {
+	Code string
+	Err  error
+	// Details carries optional machine-readable context for clients.
+	Details map[string]any
+}
+
+func (e *CustomError) Error() string {
+	if e.Err == nil {
+		return "[" + e.Code + "]"
+	}
+	return fmt.Sprintf("[%s] %s", e.Code, e.Err.Error())
+}
+
+// Unwrap returns the underlying cause.
+func (e *CustomError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is a CustomError with the same code, so that
+// errors.Is matches an error against the sentinel for its code.
+func (e *CustomError) Is(target error) bool {
+	t, ok := target.(*CustomError)
+	return ok && t.Code == e.Code
+}
+
+func NewCustomError(code string, err error) error {
+	return &CustomError{
+		Code: code,
+		Err:  err,
+	}
+}
+
+func NewCustomErrorWithDetails(code string, err error, details map[string]any) error {
+	return &CustomError{
+		Code:    code,
+		Err:     err,
+		Details: details,
+	}
+}
+
+// Code returns the code of the first CustomError in err's chain, or "" if
+// there is none.
+func Code(err error) string {
+	var ce *CustomError
+	if errors.As(err, &ce) {
+		return ce.Code
+	}
+	return ""
+}
+
+// Details returns the details of the first CustomError in err's chain.
+func Details(err error) map[string]any {
+	var ce *CustomError
+	if errors.As(err, &ce) {
+		return ce.Details
+	}
+	return nil
+}
+
+// Message returns the cause of the first CustomError in err's chain without
+// its code, or "" if there is none.
+func Message(err error) string {
+	var ce *CustomError
+	if errors.As(err, &ce) && ce.Err != nil {
+		return ce.Err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package errcom
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+func TestUnwrap(t *testing.T) {
+	err := NewCustomError("ERR_INTERNAL", fmt.Errorf("reading body: %w", io.EOF))
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("errors.Is(%v, io.EOF) = false, want the cause reached", err)
+	}
+
+	cause := &fs.PathError{Op: "open", Path: "rooms.json", Err: fs.ErrNotExist}
+	err = fmt.Errorf("loading: %w", NewCustomError("ERR_INVALID_CONFIG", cause))
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "rooms.json" {
+		t.Errorf("errors.As(%v, *fs.PathError) = %v, want the wrapped cause", err, pathErr)
+	}
+	var ce *CustomError
+	if !errors.As(err, &ce) || ce.Code != "ERR_INVALID_CONFIG" {
+		t.Errorf("errors.As(%v, *CustomError) = %v, want the CustomError", err, ce)
+	}
+	if errors.Unwrap(NewCustomError("ERR_NO_MESSAGES", nil)) != nil {
+		t.Error("a CustomError without a cause unwraps to non-nil")
+	}
+}
+
+func TestIsMatchesByCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		target error
+		want   bool
+	}{
+		{name: "same code", err: NewCustomError("ERR_RATE_LIMIT", errors.New("too many messages")), target: ErrRateLimit, want: true},
+		{name: "with details", err: NewCustomErrorWithDetails("ERR_RATE_LIMIT", nil, map[string]any{"retry_after_ms": 100}), target: ErrRateLimit, want: true},
+		{name: "wrapped", err: fmt.Errorf("send: %w", NewCustomError("ERR_USER_NOT_FOUND", nil)), target: ErrUserNotFound, want: true},
+		{name: "other code", err: NewCustomError("ERR_RATE_LIMIT", nil), target: ErrRoomRateLimit},
+		{name: "plain error", err: errors.New("ERR_RATE_LIMIT"), target: ErrRateLimit},
+		{name: "sentinel against cause", err: NewCustomError("ERR_INTERNAL", io.EOF), target: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(tt.err, tt.target); got != tt.want {
+				t.Errorf("errors.Is(%v, %v) = %v, want %v", tt.err, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHelpers(t *testing.T) {
+	details := map[string]any{"not_found": []string{"bob"}}
+	err := fmt.Errorf("send: %w", NewCustomErrorWithDetails("ERR_NO_RECEIVERS", errors.New("no clients received the message"), details))
+	if got := Code(err); got != "ERR_NO_RECEIVERS" {
+		t.Errorf("Code = %q, want ERR_NO_RECEIVERS", got)
+	}
+	if got := Message(err); got != "no clients received the message" {
+		t.Errorf("Message = %q", got)
+	}
+	if got := Details(err); got["not_found"] == nil {
+		t.Errorf("Details = %v, want the attached details", got)
+	}
+	if got := err.Error(); got != "send: [ERR_NO_RECEIVERS] no clients received the message" {
+		t.Errorf("Error = %q", got)
+	}
+	plain := errors.New("boom")
+	if Code(plain) != "" || Message(plain) != "" || Details(plain) != nil {
+		t.Error("helpers report a CustomError for a plain error")
+	}
+}
@@ -0,0 +1,65 @@
+package errcom
+
+// Sentinels for each error code, for use with errors.Is. They carry no
+// cause and only match by code.
+var (
+	ErrAlreadyJoined       = &CustomError{Code: "ERR_ALREADY_JOINED"}
+	ErrAlreadyReceiving    = &CustomError{Code: "ERR_ALREADY_RECEIVING"}
+	ErrAttachmentTooLarge  = &CustomError{Code: "ERR_ATTACHMENT_TOO_LARGE"}
+	ErrAttributesTooLarge  = &CustomError{Code: "ERR_ATTRIBUTES_TOO_LARGE"}
+	ErrBanned              = &CustomError{Code: "ERR_BANNED"}
+	ErrBatchTooLarge       = &CustomError{Code: "ERR_BATCH_TOO_LARGE"}
+	ErrBroadcastDisabled   = &CustomError{Code: "ERR_BROADCAST_DISABLED"}
+	ErrDraining            = &CustomError{Code: "ERR_DRAINING"}
+	ErrFieldTooLong        = &CustomError{Code: "ERR_FIELD_TOO_LONG"}
+	ErrIdentityMismatch    = &CustomError{Code: "ERR_IDENTITY_MISMATCH"}
+	ErrInvalidAttachment   = &CustomError{Code: "ERR_INVALID_ATTACHMENT"}
+	ErrInvalidConfig       = &CustomError{Code: "ERR_INVALID_CONFIG"}
+	ErrInvalidCursor       = &CustomError{Code: "ERR_INVALID_CURSOR"}
+	ErrInvalidFormat       = &CustomError{Code: "ERR_INVALID_FORMAT"}
+	ErrInvalidLimit        = &CustomError{Code: "ERR_INVALID_LIMIT"}
+	ErrInvalidMessage      = &CustomError{Code: "ERR_INVALID_MESSAGE"}
+	ErrInvalidName         = &CustomError{Code: "ERR_INVALID_NAME"}
+	ErrInvalidOffset       = &CustomError{Code: "ERR_INVALID_OFFSET"}
+	ErrInvalidOrder        = &CustomError{Code: "ERR_INVALID_ORDER"}
+	ErrInvalidRecipients   = &CustomError{Code: "ERR_INVALID_RECIPIENTS"}
+	ErrInvalidRequest      = &CustomError{Code: "ERR_INVALID_REQUEST"}
+	ErrInvalidStatus       = &CustomError{Code: "ERR_INVALID_STATUS"}
+	ErrInvalidTarget       = &CustomError{Code: "ERR_INVALID_TARGET"}
+	ErrInvalidRoom         = &CustomError{Code: "ERR_INVALID_ROOM"}
+	ErrInvalidUserID       = &CustomError{Code: "ERR_INVALID_USER_ID"}
+	ErrInvalidWait         = &CustomError{Code: "ERR_INVALID_WAIT"}
+	ErrMemoryPressure      = &CustomError{Code: "ERR_MEMORY_PRESSURE"}
+	ErrMessageNotFound     = &CustomError{Code: "ERR_MESSAGE_NOT_FOUND"}
+	ErrMessageRejected     = &CustomError{Code: "ERR_MESSAGE_REJECTED"}
+	ErrMessageTooLong      = &CustomError{Code: "ERR_MESSAGE_TOO_LONG"}
+	ErrMissingField        = &CustomError{Code: "ERR_MISSING_FIELD"}
+	ErrMissingRoom         = &CustomError{Code: "ERR_MISSING_ROOM"}
+	ErrMissingUserID       = &CustomError{Code: "ERR_MISSING_USER_ID"}
+	ErrModerationFailed    = &CustomError{Code: "ERR_MODERATION_FAILED"}
+	ErrNameNotAllowed      = &CustomError{Code: "ERR_NAME_NOT_ALLOWED"}
+	ErrNoMessages          = &CustomError{Code: "ERR_NO_MESSAGES"}
+	ErrNoReceivers         = &CustomError{Code: "ERR_NO_RECEIVERS"}
+	ErrObserverReadonly    = &CustomError{Code: "ERR_OBSERVER_READONLY"}
+	ErrQuarantineFull      = &CustomError{Code: "ERR_QUARANTINE_FULL"}
+	ErrQuarantineNotFound  = &CustomError{Code: "ERR_QUARANTINE_NOT_FOUND"}
+	ErrQuotaExceeded       = &CustomError{Code: "ERR_QUOTA_EXCEEDED"}
+	ErrRateLimit           = &CustomError{Code: "ERR_RATE_LIMIT"}
+	ErrRecipientNotFound   = &CustomError{Code: "ERR_RECIPIENT_NOT_FOUND"}
+	ErrRequestTooLarge     = &CustomError{Code: "ERR_REQUEST_TOO_LARGE"}
+	ErrRoomFull            = &CustomError{Code: "ERR_ROOM_FULL"}
+	ErrRoomNotFound        = &CustomError{Code: "ERR_ROOM_NOT_FOUND"}
+	ErrRoomRateLimit       = &CustomError{Code: "ERR_ROOM_RATE_LIMIT"}
+	ErrSenderNotFound      = &CustomError{Code: "ERR_SENDER_NOT_FOUND"}
+	ErrServerFull          = &CustomError{Code: "ERR_SERVER_FULL"}
+	ErrServerShuttingDown  = &CustomError{Code: "ERR_SERVER_SHUTTING_DOWN"}
+	ErrServiceUnavailable  = &CustomError{Code: "ERR_SERVICE_UNAVAILABLE"}
+	ErrTooManyRooms        = &CustomError{Code: "ERR_TOO_MANY_ROOMS"}
+	ErrTooManySessions     = &CustomError{Code: "ERR_TOO_MANY_SESSIONS"}
+	ErrUnauthorized        = &CustomError{Code: "ERR_UNAUTHORIZED"}
+	ErrUnknownCommand      = &CustomError{Code: "ERR_UNKNOWN_COMMAND"}
+	ErrUnsupportedProtocol = &CustomError{Code: "ERR_UNSUPPORTED_PROTOCOL"}
+	ErrUserDisconnected    = &CustomError{Code: "ERR_USER_DISCONNECTED"}
+	ErrUserNotFound        = &CustomError{Code: "ERR_USER_NOT_FOUND"}
+	ErrValidation          = &CustomError{Code: "ERR_VALIDATION"}
+)
@@ -0,0 +1,58 @@
+package errcom
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// statuses maps error codes to the HTTP status handlers reply with. Codes
+// ending in _NOT_FOUND that are not listed map to 404.
+var statuses = map[string]int{
+	"ERR_UNAUTHORIZED":         http.StatusUnauthorized,
+	"ERR_IDENTITY_MISMATCH":    http.StatusForbidden,
+	"ERR_BANNED":               http.StatusForbidden,
+	"ERR_OBSERVER_READONLY":    http.StatusForbidden,
+	"ERR_BROADCAST_DISABLED":   http.StatusForbidden,
+	"ERR_NO_MESSAGES":          http.StatusRequestTimeout,
+	"ERR_ALREADY_JOINED":       http.StatusConflict,
+	"ERR_ALREADY_RECEIVING":    http.StatusConflict,
+	"ERR_TOO_MANY_SESSIONS":    http.StatusConflict,
+	"ERR_USER_DISCONNECTED":    http.StatusGone,
+	"ERR_MESSAGE_TOO_LONG":     http.StatusRequestEntityTooLarge,
+	"ERR_ATTRIBUTES_TOO_LARGE": http.StatusRequestEntityTooLarge,
+	"ERR_ATTACHMENT_TOO_LARGE": http.StatusRequestEntityTooLarge,
+	"ERR_REQUEST_TOO_LARGE":    http.StatusRequestEntityTooLarge,
+	"ERR_MESSAGE_REJECTED":     http.StatusUnprocessableEntity,
+	"ERR_NAME_NOT_ALLOWED":     http.StatusUnprocessableEntity,
+	"ERR_RATE_LIMIT":           http.StatusTooManyRequests,
+	"ERR_QUOTA_EXCEEDED":       http.StatusTooManyRequests,
+	"ERR_ROOM_RATE_LIMIT":      http.StatusTooManyRequests,
+	"ERR_QUARANTINE_FULL":      http.StatusServiceUnavailable,
+	"ERR_MEMORY_PRESSURE":      http.StatusServiceUnavailable,
+	"ERR_MODERATION_FAILED":    http.StatusServiceUnavailable,
+	"ERR_SERVER_FULL":          http.StatusServiceUnavailable,
+	"ERR_ROOM_FULL":            http.StatusConflict,
+	"ERR_TOO_MANY_ROOMS":       http.StatusServiceUnavailable,
+	"ERR_DRAINING":             http.StatusServiceUnavailable,
+	"ERR_SERVICE_UNAVAILABLE":  http.StatusServiceUnavailable,
+	"ERR_SERVER_SHUTTING_DOWN": http.StatusServiceUnavailable,
+	"ERR_INTERNAL":             http.StatusInternalServerError,
+}
+
+// HTTPStatus returns the HTTP status for err based on its code. Unlisted
+// codes are treated as bad requests, and errors that are not CustomErrors as
+// internal errors.
+func HTTPStatus(err error) int {
+	var ce *CustomError
+	if !errors.As(err, &ce) {
+		return http.StatusInternalServerError
+	}
+	if status, ok := statuses[ce.Code]; ok {
+		return status
+	}
+	if strings.HasSuffix(ce.Code, "_NOT_FOUND") {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
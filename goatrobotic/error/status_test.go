@@ -0,0 +1,116 @@
+package errcom
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// wantStatus is the HTTP status of every error code in the module.
+var wantStatus = map[string]int{
+	"ERR_ALREADY_JOINED":       http.StatusConflict,
+	"ERR_ALREADY_RECEIVING":    http.StatusConflict,
+	"ERR_ATTACHMENT_TOO_LARGE": http.StatusRequestEntityTooLarge,
+	"ERR_ATTRIBUTES_TOO_LARGE": http.StatusRequestEntityTooLarge,
+	"ERR_BANNED":               http.StatusForbidden,
+	"ERR_BATCH_TOO_LARGE":      http.StatusBadRequest,
+	"ERR_BROADCAST_DISABLED":   http.StatusForbidden,
+	"ERR_DRAINING":             http.StatusServiceUnavailable,
+	"ERR_FIELD_TOO_LONG":       http.StatusBadRequest,
+	"ERR_IDENTITY_MISMATCH":    http.StatusForbidden,
+	"ERR_INTERNAL":             http.StatusInternalServerError,
+	"ERR_INVALID_ATTACHMENT":   http.StatusBadRequest,
+	"ERR_INVALID_CONFIG":       http.StatusBadRequest,
+	"ERR_INVALID_CURSOR":       http.StatusBadRequest,
+	"ERR_INVALID_FORMAT":       http.StatusBadRequest,
+	"ERR_INVALID_LIMIT":        http.StatusBadRequest,
+	"ERR_INVALID_MESSAGE":      http.StatusBadRequest,
+	"ERR_INVALID_NAME":         http.StatusBadRequest,
+	"ERR_INVALID_OFFSET":       http.StatusBadRequest,
+	"ERR_INVALID_ORDER":        http.StatusBadRequest,
+	"ERR_INVALID_RECIPIENTS":   http.StatusBadRequest,
+	"ERR_INVALID_REQUEST":      http.StatusBadRequest,
+	"ERR_INVALID_ROOM":         http.StatusBadRequest,
+	"ERR_INVALID_STATUS":       http.StatusBadRequest,
+	"ERR_INVALID_TARGET":       http.StatusBadRequest,
+	"ERR_INVALID_USER_ID":      http.StatusBadRequest,
+	"ERR_INVALID_WAIT":         http.StatusBadRequest,
+	"ERR_MEMORY_PRESSURE":      http.StatusServiceUnavailable,
+	"ERR_MESSAGE_NOT_FOUND":    http.StatusNotFound,
+	"ERR_MESSAGE_REJECTED":     http.StatusUnprocessableEntity,
+	"ERR_MESSAGE_TOO_LONG":     http.StatusRequestEntityTooLarge,
+	"ERR_MISSING_FIELD":        http.StatusBadRequest,
+	"ERR_MISSING_ROOM":         http.StatusBadRequest,
+	"ERR_MISSING_USER_ID":      http.StatusBadRequest,
+	"ERR_MODERATION_FAILED":    http.StatusServiceUnavailable,
+	"ERR_NAME_NOT_ALLOWED":     http.StatusUnprocessableEntity,
+	"ERR_NO_MESSAGES":          http.StatusRequestTimeout,
+	"ERR_NO_RECEIVERS":         http.StatusBadRequest,
+	"ERR_OBSERVER_READONLY":    http.StatusForbidden,
+	"ERR_QUARANTINE_FULL":      http.StatusServiceUnavailable,
+	"ERR_QUARANTINE_NOT_FOUND": http.StatusNotFound,
+	"ERR_QUOTA_EXCEEDED":       http.StatusTooManyRequests,
+	"ERR_RATE_LIMIT":           http.StatusTooManyRequests,
+	"ERR_RECIPIENT_NOT_FOUND":  http.StatusNotFound,
+	"ERR_REQUEST_TOO_LARGE":    http.StatusRequestEntityTooLarge,
+	"ERR_ROOM_FULL":            http.StatusConflict,
+	"ERR_ROOM_NOT_FOUND":       http.StatusNotFound,
+	"ERR_ROOM_RATE_LIMIT":      http.StatusTooManyRequests,
+	"ERR_SENDER_NOT_FOUND":     http.StatusNotFound,
+	"ERR_SERVER_FULL":          http.StatusServiceUnavailable,
+	"ERR_SERVER_SHUTTING_DOWN": http.StatusServiceUnavailable,
+	"ERR_SERVICE_UNAVAILABLE":  http.StatusServiceUnavailable,
+	"ERR_TOO_MANY_ROOMS":       http.StatusServiceUnavailable,
+	"ERR_TOO_MANY_SESSIONS":    http.StatusConflict,
+	"ERR_UNAUTHORIZED":         http.StatusUnauthorized,
+	"ERR_UNKNOWN_COMMAND":      http.StatusBadRequest,
+	"ERR_UNSUPPORTED_PROTOCOL": http.StatusBadRequest,
+	"ERR_USER_DISCONNECTED":    http.StatusGone,
+	"ERR_USER_NOT_FOUND":       http.StatusNotFound,
+	"ERR_VALIDATION":           http.StatusBadRequest,
+}
+
+func TestHTTPStatus(t *testing.T) {
+	for code, want := range wantStatus {
+		if got := HTTPStatus(NewCustomError(code, errors.New("cause"))); got != want {
+			t.Errorf("HTTPStatus(%s) = %d, want %d", code, got, want)
+		}
+	}
+	wrapped := fmt.Errorf("joining: %w", ErrRateLimit)
+	if got := HTTPStatus(wrapped); got != http.StatusTooManyRequests {
+		t.Errorf("HTTPStatus(wrapped) = %d, want %d", got, http.StatusTooManyRequests)
+	}
+	if got := HTTPStatus(errors.New("boom")); got != http.StatusInternalServerError {
+		t.Errorf("HTTPStatus(plain error) = %d, want %d", got, http.StatusInternalServerError)
+	}
+}
+
+// TestHTTPStatusCoversEveryCode fails when a code used anywhere in the
+// module is missing from wantStatus, so new codes get a deliberate status.
+func TestHTTPStatusCoversEveryCode(t *testing.T) {
+	literal := regexp.MustCompile(`"(ERR_[A-Z_]+)"`)
+	err := filepath.WalkDir("..", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, m := range literal.FindAllStringSubmatch(string(src), -1) {
+			if _, ok := wantStatus[m[1]]; !ok {
+				t.Errorf("%s uses %s, which has no expected status", path, m[1])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,37 +1,742 @@
-package model
-
-type JoinRequest struct {
-	ID string `json:"id"`
-}
-
-type SendMessageRequest struct {
-	From    string `json:"from"`
-	Message string `json:"message"`
-}
-
-type LeaveRequest struct {
-	ID string `json:"id"`
-}
-
-type MessageRequest struct {
-	ID string `json:"id"`
-}
-
-type JoinResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-}
-
-type SendMessageResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-}
-
-type LeaveResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-}
-
-type MessageResponse struct {
-	Message string `json:"message"`
-}
+package model
+
+import "time"
+
+// Range of protocol versions this server speaks. Bump ProtocolVersion when
+// message formats change and raise MinProtocolVersion when an old one is
+// dropped.
+const (
+	MinProtocolVersion = 1
+	ProtocolVersion    = 1
+)
+
+type JoinRequest struct {
+	ID string `json:"id" binding:"required"`
+	// Name is shown in place of ID in messages; empty means ID.
+	Name string `json:"name,omitempty"`
+	// Room is the room to join; empty means the default room.
+	Room string `json:"room,omitempty"`
+	// Force replaces an existing session under the same ID if it has gone
+	// stale, so a client that lost its connection can rejoin at once.
+	Force bool `json:"force,omitempty"`
+	// Observer joins read-only: the client receives the room's broadcasts
+	// but cannot send, is not announced, and is not counted as a member in
+	// presence counts or as a recipient in send results.
+	Observer bool `json:"observer,omitempty"`
+	// Protocol is the version the client speaks; zero means the current one.
+	Protocol int `json:"protocol,omitempty"`
+	// Attributes is small client metadata such as an avatar URL, shared
+	// with other users in presence events.
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Token, the token of an existing session under ID, makes the join
+	// resume that session instead of failing with ERR_ALREADY_JOINED.
+	Token string `json:"token,omitempty"`
+	// AddSession, with Token set to one of the user's sessions, opens a
+	// further session for the user instead of resuming that one; see
+	// Config.MaxSessions.
+	AddSession bool `json:"add_session,omitempty"`
+	// IP is the client address, filled in by the server for ban checks.
+	IP string `json:"-"`
+}
+
+// AttributesRequest replaces a connected user's attributes.
+type AttributesRequest struct {
+	ID         string            `json:"id" binding:"required"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+type AttributesResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+type SendMessageRequest struct {
+	From    string `json:"from" binding:"required"`
+	Message string `json:"message"`
+	// IdempotencyKey, when set, lets a client safely retry a send: a repeat
+	// of a recently seen key returns the original result without
+	// broadcasting again.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// ClientMsgID is a client's own ID for the message. It dedupes sends
+	// like IdempotencyKey, in the same per-sender window, and takes its
+	// place when both are set.
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+	// To, when set, sends a private message to that user only. ToList
+	// sends it to each of the listed users instead; the two are exclusive.
+	To     string   `json:"to,omitempty"`
+	ToList []string `json:"to_list,omitempty"`
+	// Token is the session token returned by Join. It may instead be sent
+	// as an Authorization bearer token.
+	Token string `json:"token,omitempty"`
+	// Attachment is an optional file sent with the message, which may then
+	// have no text.
+	Attachment *Attachment `json:"attachment,omitempty"`
+	// Echo also delivers the message to the sender's own stream.
+	Echo bool `json:"echo,omitempty"`
+}
+
+// Attachment is a small file carried inline in a message. Data is base64
+// encoded and Size is its decoded length.
+type Attachment struct {
+	MIMEType string `json:"mime_type"`
+	Data     string `json:"data"`
+	Size     int    `json:"size"`
+}
+
+// LeaveRequest and MessageRequest may name the room the user is expected to
+// be in; empty means any room.
+type LeaveRequest struct {
+	ID    string `json:"id" binding:"required"`
+	Room  string `json:"room,omitempty"`
+	Token string `json:"token,omitempty"`
+	// Drain returns the messages still buffered for the user instead of
+	// discarding them.
+	Drain bool `json:"drain,omitempty"`
+}
+
+type MessageRequest struct {
+	ID    string `json:"id"`
+	Room  string `json:"room,omitempty"`
+	Token string `json:"token,omitempty"`
+	// Since, a message ID or RFC 3339 timestamp, resumes from the stored
+	// room history after that point before waiting for new messages.
+	Since string `json:"since,omitempty"`
+	// Wait, a duration such as "30s", overrides how long to wait for a
+	// message. It is clamped to the server's maximum.
+	Wait string `json:"wait,omitempty"`
+}
+
+// MessagesRequest asks for up to Max buffered messages. Order is either
+// OrderOldest (the default) or OrderNewest.
+type MessagesRequest struct {
+	ID    string `json:"id"`
+	Max   int    `json:"max"`
+	Order string `json:"order"`
+}
+
+// Batch receive orderings.
+const (
+	OrderOldest = "oldest"
+	OrderNewest = "newest"
+)
+
+// ListRoomsRequest pages through rooms sorted by name. Only rooms whose name
+// starts with Prefix are included.
+type ListRoomsRequest struct {
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+	Prefix string `json:"prefix"`
+}
+
+type JoinResponse struct {
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+	Protocol int    `json:"protocol"`
+	Room     string `json:"room"`
+	// Token authenticates later requests made as this user.
+	Token string `json:"token"`
+	// Resumed reports that the join matched an existing session, which
+	// was kept as it was.
+	Resumed bool `json:"resumed,omitempty"`
+}
+
+type SendMessageResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	// Delivered counts recipients whose buffer accepted the message and
+	// Dropped those whose buffer was full. TimedOut reports that the
+	// fan-out budget expired before every recipient was reached, and
+	// Canceled that the request was canceled first; the counts then cover
+	// only the recipients reached so far.
+	Delivered int  `json:"delivered"`
+	Dropped   int  `json:"dropped"`
+	TimedOut  bool `json:"timed_out,omitempty"`
+	Canceled  bool `json:"canceled,omitempty"`
+	// Overloaded reports that more of the recipients than the server's
+	// overload threshold had no room for the message, a sign to slow down.
+	Overloaded bool `json:"overloaded,omitempty"`
+	// Shed reports that the server was over its memory budget, so the
+	// message was kept in history but not queued for anyone.
+	Shed bool `json:"shed,omitempty"`
+	// QuarantineID is set when the message was held for moderator review
+	// instead of being broadcast.
+	QuarantineID string `json:"quarantine_id,omitempty"`
+	// Command names the slash command that handled the message, in which
+	// case Message holds its result and nothing was broadcast.
+	Command string `json:"command,omitempty"`
+	// MessageID is the ID the delivered message carries.
+	MessageID string `json:"message_id,omitempty"`
+	// NotFound lists the ToList users who were not connected.
+	NotFound []string `json:"not_found,omitempty"`
+}
+
+// SendBatchRequest sends several messages from From in order.
+type SendBatchRequest struct {
+	From     string   `json:"from" binding:"required"`
+	Messages []string `json:"messages" binding:"required"`
+	Token    string   `json:"token,omitempty"`
+}
+
+// SendBatchResponse reports the outcome of each message in a batch, in the
+// order they were sent.
+type SendBatchResponse struct {
+	Success  bool          `json:"success"`
+	Sent     int           `json:"sent"`
+	Rejected int           `json:"rejected"`
+	Results  []BatchResult `json:"results"`
+}
+
+// BatchResult is the outcome of the batch message at Index. A rejected
+// message carries the error Code and reason it failed with.
+type BatchResult struct {
+	Index     int    `json:"index"`
+	Success   bool   `json:"success"`
+	MessageID string `json:"message_id,omitempty"`
+	Delivered int    `json:"delivered"`
+	Dropped   int    `json:"dropped"`
+	Code      string `json:"code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type LeaveResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	// Messages holds the unread messages when the request asked to drain.
+	Messages []MessageResponse `json:"messages,omitempty"`
+}
+
+// MessageResponse is a delivered message. Message is the formatted
+// rendering kept for older clients; From and Text carry the parts of a chat
+// message for clients that render their own.
+type MessageResponse struct {
+	Message   string         `json:"message"`
+	Type      string         `json:"type"`
+	System    bool           `json:"system,omitempty"`
+	ID        string         `json:"id,omitempty"`
+	From      string         `json:"from,omitempty"`
+	Text      string         `json:"text,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+	Event     *PresenceEvent `json:"event,omitempty"`
+	// Mentioned is set on the copy delivered to a user named with @ in the
+	// message.
+	Mentioned  bool        `json:"mentioned,omitempty"`
+	Attachment *Attachment `json:"attachment,omitempty"`
+	// Seq numbers the messages queued for this recipient from 1, so a gap
+	// means messages were dropped and history should be fetched. Priority
+	// notices can arrive ahead of their number. It is zero on messages
+	// replayed from history.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// HistoryRequest asks for the newest Limit messages broadcast in Room, or
+// with Cursor set the Limit messages before the page it came from.
+type HistoryRequest struct {
+	Room   string `json:"room"`
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// SearchRequest finds stored messages in Room containing Query, ignoring
+// case, optionally only those sent by From.
+type SearchRequest struct {
+	Room  string `json:"room"`
+	Query string `json:"q"`
+	From  string `json:"from,omitempty"`
+	Limit int    `json:"limit"`
+}
+
+// HistoryResponse holds messages oldest first. NextCursor, when set, fetches
+// the page of older messages.
+type HistoryResponse struct {
+	Room       string            `json:"room"`
+	Messages   []MessageResponse `json:"messages"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// KickRequest disconnects ID on behalf of a moderator. Reason is shown to
+// the kicked user.
+type KickRequest struct {
+	ID     string `json:"id" binding:"required"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type KickResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// AnnounceRequest sends Message to every connected user, whatever their
+// room.
+type AnnounceRequest struct {
+	Message string `json:"message" binding:"required"`
+}
+
+// AnnounceResponse counts the users the announcement was queued for.
+type AnnounceResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	Delivered int    `json:"delivered"`
+	MessageID string `json:"message_id"`
+}
+
+// DisconnectAllRequest disconnects every user, or every user in Room.
+// Reason is shown to them.
+type DisconnectAllRequest struct {
+	Room   string `json:"room,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type DisconnectAllResponse struct {
+	Success      bool   `json:"success"`
+	Message      string `json:"message"`
+	Disconnected int    `json:"disconnected"`
+}
+
+// AckRequest reports that ID consumed the message with MessageID.
+type AckRequest struct {
+	ID        string `json:"id" binding:"required"`
+	MessageID string `json:"message_id" binding:"required"`
+	Token     string `json:"token,omitempty"`
+}
+
+type AckResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// ReceiptsRequest asks, as the sender ID, who acknowledged MessageID.
+type ReceiptsRequest struct {
+	ID        string `json:"id"`
+	MessageID string `json:"message_id"`
+	Token     string `json:"token,omitempty"`
+}
+
+type ReceiptsResponse struct {
+	MessageID string   `json:"message_id"`
+	AckedBy   []string `json:"acked_by"`
+}
+
+// RenameRequest changes the display name of ID.
+type RenameRequest struct {
+	ID    string `json:"id" binding:"required"`
+	Name  string `json:"name"`
+	Token string `json:"token,omitempty"`
+}
+
+type RenameResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// Presence statuses. A user who is not connected is offline.
+const (
+	StatusOnline = "online"
+	StatusAway   = "away"
+)
+
+// StatusRequest sets ID's presence status to StatusOnline or StatusAway.
+type StatusRequest struct {
+	ID     string `json:"id" binding:"required"`
+	Status string `json:"status" binding:"required"`
+	Token  string `json:"token,omitempty"`
+}
+
+type StatusResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// PingRequest marks ID as active without receiving any messages.
+type PingRequest struct {
+	ID    string `json:"id" binding:"required"`
+	Token string `json:"token,omitempty"`
+}
+
+type PingResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// TypingRequest tells the rest of ID's room that ID is typing.
+type TypingRequest struct {
+	ID    string `json:"id" binding:"required"`
+	Token string `json:"token,omitempty"`
+}
+
+// TypingResponse reports whether an event was sent; calls made while an
+// earlier event is still fresh are absorbed.
+type TypingResponse struct {
+	Success bool `json:"success"`
+	Sent    bool `json:"sent"`
+}
+
+// BanRequest bans, or lifts the ban on, a user ID or a client IP. At least
+// one must be set.
+type BanRequest struct {
+	ID string `json:"id,omitempty"`
+	IP string `json:"ip,omitempty"`
+}
+
+type BanResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// BlockRequest makes ID stop, or resume, receiving messages sent by Target.
+type BlockRequest struct {
+	ID     string `json:"id" binding:"required"`
+	Target string `json:"target" binding:"required"`
+	Token  string `json:"token,omitempty"`
+}
+
+type BlockResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// UsersRequest lists connected users, only those in Room when it is set.
+type UsersRequest struct {
+	Room string `json:"room,omitempty"`
+	// Stats adds each user's delivery stats, to spot slow clients.
+	Stats bool `json:"stats,omitempty"`
+	// Limit and Cursor page through the users in ID order; without
+	// either, every user is listed.
+	Limit  int    `json:"limit,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type UserInfo struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Room     string    `json:"room"`
+	LastSeen time.Time `json:"last_seen"`
+	Status   string    `json:"status"`
+	Observer bool      `json:"observer,omitempty"`
+	// Sessions counts the user's connections; see Config.MaxSessions.
+	Sessions int          `json:"sessions"`
+	Stats    *ClientStats `json:"stats,omitempty"`
+}
+
+// ClientStats describes how well a client keeps up with its messages:
+// how many were dropped because its buffer was full, and how many of
+// Capacity are waiting now.
+type ClientStats struct {
+	Dropped  uint64 `json:"dropped"`
+	Buffered int    `json:"buffered"`
+	Capacity int    `json:"capacity"`
+}
+
+type UsersResponse struct {
+	Users      []UserInfo `json:"users"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+type UserRoomsResponse struct {
+	ID    string   `json:"id"`
+	Rooms []string `json:"rooms"`
+}
+
+type RoomInfo struct {
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+}
+
+// ListRoomsResponse holds one page of rooms. Total counts every room matching
+// the prefix; NextOffset is zero when there are no further pages.
+type ListRoomsResponse struct {
+	Rooms      []RoomInfo `json:"rooms"`
+	Total      int        `json:"total"`
+	NextOffset int        `json:"next_offset,omitempty"`
+}
+
+type MessagesResponse struct {
+	Messages []MessageResponse `json:"messages"`
+}
+
+// Message types carried on a client's stream.
+const (
+	MessageTypeChat      = "chat"
+	MessageTypePresence  = "presence"
+	MessageTypeHeartbeat = "heartbeat"
+	MessageTypeTyping    = "typing"
+	MessageTypeReport    = "report"
+	// MessageTypeAnnouncement is a server-wide notice from an operator.
+	MessageTypeAnnouncement = "announcement"
+)
+
+// Presence actions reported in a PresenceEvent.
+const (
+	PresenceJoin   = "join"
+	PresenceLeave  = "leave"
+	PresenceRename = "rename"
+	PresenceUpdate = "update"
+	PresenceKick   = "kick"
+	PresenceStatus = "status"
+)
+
+// PresenceEvent describes a change in room membership.
+type PresenceEvent struct {
+	Type   string `json:"type"`
+	Action string `json:"action"`
+	ID     string `json:"id"`
+	Count  int    `json:"count"`
+	// Attributes is the user's metadata at the time of the event.
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Status is the user's presence status at the time of the event.
+	Status string `json:"status,omitempty"`
+}
+
+// Message is the payload delivered to a client's stream. Text always holds a
+// human-readable rendering so legacy clients can keep displaying it as is.
+//
+// System marks messages generated by the service rather than sent by a user.
+// ID, unique within the service, lets clients deduplicate chat messages. From
+// is the sender's ID and Body the text as sent. All three are empty for
+// messages the service generates itself.
+type Message struct {
+	Type      string
+	Text      string
+	System    bool
+	ID        string
+	From      string
+	Body      string
+	Timestamp time.Time
+	Event     *PresenceEvent
+	// Queued is when the message was put on a client's stream, and Seq
+	// its place in that stream.
+	Queued time.Time
+	Seq    uint64
+	// Mentions holds the keys of the users named with @ in Body, and
+	// Mentioned is set on a mentioned recipient's copy.
+	Mentions   []string
+	Mentioned  bool
+	Attachment *Attachment
+}
+
+// StatsSnapshot holds service counters, either since start or since the last
+// reset.
+type StatsSnapshot struct {
+	Joins        uint64 `json:"joins"`
+	Leaves       uint64 `json:"leaves"`
+	MessagesSent uint64 `json:"messages_sent"`
+	Dropped      uint64 `json:"dropped"`
+	RateLimited  uint64 `json:"rate_limited"`
+	Redactions   uint64 `json:"redactions"`
+	// HistoryBytes is the memory held by each room's history. It is a
+	// gauge and is not affected by a reset.
+	HistoryBytes map[string]int `json:"history_bytes,omitempty"`
+	// Connected is the number of clients currently joined and Rooms the
+	// number of rooms they are in. Like HistoryBytes they are gauges.
+	Connected int `json:"connected"`
+	Rooms     int `json:"rooms"`
+	// BufferedBytes is the size of the messages waiting in every client's
+	// buffer, a gauge, and MaxBufferedBytes the budget it is held to.
+	BufferedBytes    int64 `json:"buffered_bytes"`
+	MaxBufferedBytes int64 `json:"max_buffered_bytes,omitempty"`
+	// UptimeSeconds is how long the service has been running.
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	// ReceiveWait is how long GetMessage calls waited for a message.
+	ReceiveWait HistogramSnapshot `json:"receive_wait"`
+}
+
+// ServiceStats is the summary of a service's activity served publicly by
+// GET /stats. Counts are since start, or the last reset of SnapshotStats.
+type ServiceStats struct {
+	Clients       int     `json:"clients"`
+	Rooms         int     `json:"rooms"`
+	MessagesSent  uint64  `json:"messages_sent"`
+	Dropped       uint64  `json:"dropped"`
+	RateLimited   uint64  `json:"rate_limited"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+// HistogramSnapshot is a histogram of observations in seconds. Bucket counts
+// are cumulative, as in Prometheus.
+type HistogramSnapshot struct {
+	Count   uint64   `json:"count"`
+	Sum     float64  `json:"sum"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// Reasons a delivery failed, reported in a DeadLetter.
+const (
+	DeadLetterBufferFull = "buffer_full"
+	DeadLetterClientGone = "client_gone"
+)
+
+// Chat event types delivered to ChatService subscribers.
+const (
+	EventJoin  = "join"
+	EventLeave = "leave"
+	EventSend  = "send"
+	EventDrop  = "drop"
+)
+
+// ChatEvent reports something that happened to User in Room. A send carries
+// the MessageID and Text of the message; a drop the MessageID and, as
+// Reason, why it could not reach User; a leave, as Reason, one of "left",
+// "kicked", "disconnected", "idle" or "evicted".
+type ChatEvent struct {
+	Type      string    `json:"type"`
+	User      string    `json:"user"`
+	Room      string    `json:"room,omitempty"`
+	MessageID string    `json:"message_id,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// DeadLetter records that Message, with ID MessageID, could not be delivered
+// to RecipientID.
+type DeadLetter struct {
+	MessageID   string    `json:"message_id"`
+	RecipientID string    `json:"recipient_id"`
+	Reason      string    `json:"reason"`
+	At          time.Time `json:"at"`
+	Message     Message   `json:"message"`
+}
+
+// QuarantinedMessage is a flagged message awaiting moderator review.
+type QuarantinedMessage struct {
+	ID         string      `json:"id"`
+	From       string      `json:"from"`
+	Name       string      `json:"name"`
+	Room       string      `json:"room"`
+	To         string      `json:"to,omitempty"`
+	ToList     []string    `json:"to_list,omitempty"`
+	Text       string      `json:"text"`
+	Attachment *Attachment `json:"attachment,omitempty"`
+	HeldAt     time.Time   `json:"held_at"`
+}
+
+// ReportRequest flags the message MessageID in From's room to the room's
+// moderators.
+type ReportRequest struct {
+	From      string `json:"from" binding:"required"`
+	MessageID string `json:"message_id" binding:"required"`
+	Reason    string `json:"reason"`
+	Token     string `json:"token,omitempty"`
+}
+
+// ReportResponse is set Queued when no moderator was online to take the
+// report.
+type ReportResponse struct {
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+	ReportID string `json:"report_id"`
+	Queued   bool   `json:"queued,omitempty"`
+}
+
+// Report is an entry in the audit log of reported messages. Sender and Text
+// are those of the reported message.
+type Report struct {
+	ID         string    `json:"id"`
+	Room       string    `json:"room"`
+	From       string    `json:"from"`
+	MessageID  string    `json:"message_id"`
+	Sender     string    `json:"sender"`
+	Text       string    `json:"text"`
+	Reason     string    `json:"reason"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+// ModeratorRequest marks or unmarks ID as a moderator of its room.
+type ModeratorRequest struct {
+	ID        string `json:"id" binding:"required"`
+	Moderator bool   `json:"moderator"`
+}
+
+// ModeratorResponse counts the queued reports delivered to a new moderator.
+type ModeratorResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Reports int    `json:"reports"`
+}
+
+// ModerateRequest approves (broadcasts) or discards a quarantined message.
+type ModerateRequest struct {
+	ID      string `json:"id" binding:"required"`
+	Approve bool   `json:"approve"`
+}
+
+type ModerateResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	Delivered int    `json:"delivered"`
+	Dropped   int    `json:"dropped"`
+}
+
+// RoomLimitsRequest overrides limits for one room. A zero limit restores the
+// global default.
+type RoomLimitsRequest struct {
+	Room          string `json:"room" binding:"required"`
+	MaxMessageLen int    `json:"max_message_len"`
+	MaxMembers    int    `json:"max_members"`
+	// MaxRate caps the room's broadcasts per second, summed over senders.
+	MaxRate float64 `json:"max_rate"`
+}
+
+// RoomLimitsResponse holds the limits in effect; zero MaxMembers or MaxRate
+// means unlimited.
+type RoomLimitsResponse struct {
+	Room          string  `json:"room"`
+	MaxMessageLen int     `json:"max_message_len"`
+	MaxMembers    int     `json:"max_members"`
+	MaxRate       float64 `json:"max_rate"`
+}
+
+// UnreadResponse counts the messages waiting on ID's stream.
+type UnreadResponse struct {
+	ID     string `json:"id"`
+	Unread int    `json:"unread"`
+}
+
+// MultiReceiveRequest collects the messages buffered for each of IDs, for
+// relays that poll on behalf of many users. Tokens holds each user's session
+// token, keyed by ID, for callers that cannot present a single bearer token.
+// With Wait set, a request that finds nothing buffered for any of them waits
+// up to that long, as a duration such as 2s, for the first message.
+type MultiReceiveRequest struct {
+	IDs    []string          `json:"ids" binding:"required"`
+	Tokens map[string]string `json:"tokens,omitempty"`
+	Wait   string            `json:"wait,omitempty"`
+}
+
+// MultiReceiveResponse holds the messages taken for each ID, keyed as
+// requested; IDs with none are left out. NotFound lists the IDs that were
+// not connected and Busy those with another receive already in flight.
+type MultiReceiveResponse struct {
+	Messages map[string][]MessageResponse `json:"messages"`
+	NotFound []string                     `json:"not_found"`
+	Busy     []string                     `json:"busy,omitempty"`
+}
+
+// PeekRequest asks for up to Max of the messages buffered for ID, left in
+// place.
+type PeekRequest struct {
+	ID  string `json:"id"`
+	Max int    `json:"max"`
+}
+
+// PeekResponse lists buffered messages in delivery order. Queued counts
+// every message buffered, including any beyond those listed.
+type PeekResponse struct {
+	ID       string            `json:"id"`
+	Queued   int               `json:"queued"`
+	Messages []MessageResponse `json:"messages"`
+}
+
+// RateLimitResponse describes a client's send limiter: Tokens sends are
+// available now, refilling at Rate per second up to Burst.
+type RateLimitResponse struct {
+	ID     string  `json:"id"`
+	Tokens float64 `json:"tokens"`
+	Burst  int     `json:"burst"`
+	Rate   float64 `json:"rate"`
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// checkAttributes bounds client metadata by number of keys and by the total
+// size of keys and values.
+func (s *chatService) checkAttributes(attrs map[string]string) error {
+	if len(attrs) > s.cfg.MaxAttributes {
+		return errcom.NewCustomError("ERR_ATTRIBUTES_TOO_LARGE", fmt.Errorf("at most %d attributes are allowed", s.cfg.MaxAttributes))
+	}
+	size := 0
+	for k, v := range attrs {
+		size += len(k) + len(v)
+	}
+	if size > s.cfg.MaxAttributesBytes {
+		return errcom.NewCustomError("ERR_ATTRIBUTES_TOO_LARGE", fmt.Errorf("attributes must total at most %d bytes", s.cfg.MaxAttributesBytes))
+	}
+	return nil
+}
+
+// SetAttributes replaces a client's metadata and announces the change to the
+// other clients as a presence update.
+func (s *chatService) SetAttributes(ctx context.Context, req model.AttributesRequest) (*model.AttributesResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, ""); err != nil {
+		return nil, err
+	}
+	if err := s.checkAttributes(req.Attributes); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, exists := s.streams[s.key(req.ID)]
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	// The map is replaced rather than mutated because presence events
+	// already queued may still reference the old one.
+	client.Attributes = maps.Clone(req.Attributes)
+	notice := s.presenceMessage(model.PresenceUpdate, client, s.participants(client.Room))
+	notice.Text = "* " + client.Name + " updated their profile"
+	s.announce(notice, client)
+
+	return &model.AttributesResponse{
+		Success: true,
+		Message: "Attributes updated",
+	}, nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+
+	"chatbox/model"
+)
+
+// Backend shares user registration and room fan-out between service
+// instances, so several processes can serve one chat. Local delivery always
+// happens in-process; the backend only carries what other instances need.
+type Backend interface {
+	// Register claims id across instances, failing with ERR_ALREADY_JOINED
+	// if another instance holds it.
+	Register(ctx context.Context, id string) error
+	// Unregister releases a claim made by Register.
+	Unregister(ctx context.Context, id string) error
+	// Publish sends msg, broadcast in room, to every other instance.
+	Publish(ctx context.Context, room string, msg model.Message) error
+	// Subscribe passes messages published by other instances to fn until
+	// ctx is done.
+	Subscribe(ctx context.Context, fn func(room string, msg model.Message)) error
+	Close() error
+}
+
+// memoryBackend is the single-instance Backend: the service's own streams
+// map already enforces unique IDs and there is no one else to fan out to.
+type memoryBackend struct{}
+
+// NewMemoryBackend returns the default Backend for a service that runs as a
+// single instance.
+func NewMemoryBackend() Backend {
+	return memoryBackend{}
+}
+
+func (memoryBackend) Register(ctx context.Context, id string) error   { return nil }
+func (memoryBackend) Unregister(ctx context.Context, id string) error { return nil }
+
+func (memoryBackend) Publish(ctx context.Context, room string, msg model.Message) error {
+	return nil
+}
+
+func (memoryBackend) Subscribe(ctx context.Context, fn func(room string, msg model.Message)) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (memoryBackend) Close() error { return nil }
+
+// startBackend relays messages from other instances to local clients until
+// the service is closed.
+func (s *chatService) startBackend() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		<-s.done
+		cancel()
+	}()
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		s.cfg.Backend.Subscribe(ctx, s.relay)
+	}()
+}
+
+// relay delivers a message broadcast in room on another instance.
+func (s *chatService) relay(room string, msg model.Message) {
+	s.observeMessageID(msg.ID)
+	s.mu.RLock()
+	recipients := s.audience(room, "")
+	s.mu.RUnlock()
+	s.deliverTo(context.Background(), msg, room, false, recipients)
+	if msg.Type == model.MessageTypeChat {
+		s.cfg.Store.Append(room, msg)
+	}
+}
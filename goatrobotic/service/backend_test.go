@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// hub is an in-process stand-in for Redis, shared by the backends of
+// several services in one test.
+type hub struct {
+	mu      sync.Mutex
+	claims  map[string]*hubBackend
+	members []*hubBackend
+}
+
+type hubBackend struct {
+	hub *hub
+	in  chan relayed
+}
+
+type relayed struct {
+	room string
+	msg  model.Message
+}
+
+func (h *hub) backend() *hubBackend {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b := &hubBackend{hub: h, in: make(chan relayed, 256)}
+	h.members = append(h.members, b)
+	return b
+}
+
+func (b *hubBackend) Register(ctx context.Context, id string) error {
+	b.hub.mu.Lock()
+	defer b.hub.mu.Unlock()
+	if owner, ok := b.hub.claims[id]; ok && owner != b {
+		return errcom.NewCustomError("ERR_ALREADY_JOINED", errors.New("user already joined"))
+	}
+	if b.hub.claims == nil {
+		b.hub.claims = make(map[string]*hubBackend)
+	}
+	b.hub.claims[id] = b
+	return nil
+}
+
+func (b *hubBackend) Unregister(ctx context.Context, id string) error {
+	b.hub.mu.Lock()
+	defer b.hub.mu.Unlock()
+	delete(b.hub.claims, id)
+	return nil
+}
+
+func (b *hubBackend) Publish(ctx context.Context, room string, msg model.Message) error {
+	b.hub.mu.Lock()
+	defer b.hub.mu.Unlock()
+	for _, m := range b.hub.members {
+		if m != b {
+			m.in <- relayed{room: room, msg: msg}
+		}
+	}
+	return nil
+}
+
+func (b *hubBackend) Subscribe(ctx context.Context, fn func(room string, msg model.Message)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case r := <-b.in:
+			fn(r.room, r.msg)
+		}
+	}
+}
+
+func (b *hubBackend) Close() error { return nil }
+
+// TestResumeAcrossInstances sends from two instances sharing one chat, then
+// walks one instance's history with since cursors, which must visit each
+// message once whichever instance assigned its ID.
+func TestResumeAcrossInstances(t *testing.T) {
+	var h hub
+	a := newTestService(t, WithBackend(h.backend()))
+	b := newTestService(t, WithBackend(h.backend()))
+	ctx := context.Background()
+	alice := join(t, a, "alice", "lobby")
+	bob := join(t, b, "bob", "lobby")
+	if _, err := b.Join(ctx, model.JoinRequest{ID: "alice", Room: "lobby"}); !errors.Is(err, errcom.ErrAlreadyJoined) {
+		t.Fatalf("joining alice on a second instance = %v, want ERR_ALREADY_JOINED", err)
+	}
+
+	for _, send := range []struct {
+		s           *chatService
+		from, token string
+	}{{a, "alice", alice}, {b, "bob", bob}, {a, "alice", alice}, {b, "bob", bob}} {
+		if _, err := send.s.SendMessage(ctx, model.SendMessageRequest{From: send.from, Token: send.token, Message: "hi"}); err != nil {
+			t.Fatalf("SendMessage from %s: %v", send.from, err)
+		}
+	}
+	var stored []model.Message
+	deadline := time.Now().Add(time.Second)
+	for len(stored) < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("b stored %d of 4 messages", len(stored))
+		}
+		time.Sleep(time.Millisecond)
+		stored = b.cfg.Store.Recent("lobby", math.MaxInt)
+	}
+
+	seen := make(map[string]bool)
+	for _, msg := range stored {
+		if seen[msg.ID] {
+			t.Fatalf("ID %s was assigned twice in %+v", msg.ID, stored)
+		}
+		seen[msg.ID] = true
+	}
+	for i := range len(stored) - 1 {
+		msg, err := b.GetMessage(ctx, model.MessageRequest{ID: "bob", Token: bob, Since: stored[i].ID, Wait: "10ms"})
+		if err != nil || msg.ID != stored[i+1].ID {
+			t.Fatalf("resuming after %s received %+v, %v; want %s", stored[i].ID, msg, err, stored[i+1].ID)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// banList holds the user keys and client IPs refused at join time.
+type banList struct {
+	mu  sync.RWMutex
+	ids map[string]struct{}
+	ips map[string]struct{}
+}
+
+func (b *banList) set(id, ip string, banned bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ids == nil {
+		b.ids = make(map[string]struct{})
+		b.ips = make(map[string]struct{})
+	}
+	if id != "" {
+		if banned {
+			b.ids[id] = struct{}{}
+		} else {
+			delete(b.ids, id)
+		}
+	}
+	if ip != "" {
+		if banned {
+			b.ips[ip] = struct{}{}
+		} else {
+			delete(b.ips, ip)
+		}
+	}
+}
+
+func (b *banList) has(id, ip string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if _, ok := b.ids[id]; ok {
+		return true
+	}
+	_, ok := b.ips[ip]
+	return ok && ip != ""
+}
+
+// checkBanned rejects a join from a banned user ID or client IP.
+func (s *chatService) checkBanned(id, ip string) error {
+	if s.bans.has(s.key(id), ip) {
+		return errcom.NewCustomError("ERR_BANNED", errors.New("user is banned"))
+	}
+	return nil
+}
+
+// Ban refuses future joins from req.ID or req.IP. A banned ID that is
+// connected is kicked.
+func (s *chatService) Ban(ctx context.Context, req model.BanRequest) (*model.BanResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" && req.IP == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("id or ip is required"))
+	}
+	s.bans.set(s.key(req.ID), req.IP, true)
+
+	if req.ID != "" {
+		_, err := s.Kick(ctx, model.KickRequest{ID: req.ID, Reason: "banned"})
+		if err != nil && errcom.Code(err) != "ERR_USER_NOT_FOUND" {
+			return nil, err
+		}
+	}
+	return &model.BanResponse{Success: true, Message: "Ban added"}, nil
+}
+
+func (s *chatService) Unban(ctx context.Context, req model.BanRequest) (*model.BanResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" && req.IP == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("id or ip is required"))
+	}
+	s.bans.set(s.key(req.ID), req.IP, false)
+	return &model.BanResponse{Success: true, Message: "Ban lifted"}, nil
+}
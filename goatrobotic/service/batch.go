@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// rateChargedKey marks a context whose sends were already charged to the
+// sender's rate limiter.
+type rateChargedKey struct{}
+
+func rateCharged(ctx context.Context) bool {
+	charged, _ := ctx.Value(rateChargedKey{}).(bool)
+	return charged
+}
+
+// SendBatch sends req.Messages in order, as SendMessage would one at a time.
+// A message that is rejected does not stop the rest; its error is reported in
+// its result instead.
+func (s *chatService) SendBatch(ctx context.Context, req model.SendBatchRequest) (*model.SendBatchResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.From == "" || len(req.Messages) == 0 {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("from and messages are required"))
+	}
+	if len(req.Messages) > s.cfg.MaxBatchSize {
+		return nil, errcom.NewCustomError("ERR_BATCH_TOO_LARGE", fmt.Errorf("a batch may hold at most %d messages", s.cfg.MaxBatchSize))
+	}
+	if err := s.checkIdentity(ctx, req.From); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.From, req.Token); err != nil {
+		return nil, err
+	}
+
+	if s.cfg.BatchRateOnce {
+		s.mu.RLock()
+		sender, exists := s.streams[s.key(req.From)]
+		s.mu.RUnlock()
+		if !exists {
+			return nil, errcom.NewCustomError("ERR_SENDER_NOT_FOUND", errors.New("sender not connected"))
+		}
+		if now := s.cfg.Clock.Now(); !sender.RateLimiter.AllowN(now, 1) {
+			s.stats.rateLimited.Add(1)
+			return nil, errRateLimit(sender.RateLimiter, now, "too many messages")
+		}
+		ctx = context.WithValue(ctx, rateChargedKey{}, true)
+	}
+
+	res := &model.SendBatchResponse{Success: true, Results: make([]model.BatchResult, 0, len(req.Messages))}
+	for i, text := range req.Messages {
+		result := model.BatchResult{Index: i}
+		sent, err := s.SendMessage(ctx, model.SendMessageRequest{From: req.From, Message: text, Token: req.Token})
+		if err != nil {
+			result.Code = errcom.Code(err)
+			result.Error = errcom.Message(err)
+			res.Rejected++
+		} else {
+			result.Success = true
+			result.MessageID = sent.MessageID
+			result.Delivered = sent.Delivered
+			result.Dropped = sent.Dropped
+			res.Sent++
+		}
+		res.Results = append(res.Results, result)
+	}
+	return res, nil
+}
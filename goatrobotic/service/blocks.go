@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// blockSet holds the senders a client has blocked, by key. It is read during
+// fan-out while s.mu is only read-locked, so it has its own lock.
+type blockSet struct {
+	mu  sync.RWMutex
+	ids map[string]struct{}
+}
+
+func (b *blockSet) set(id string, blocked bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !blocked {
+		delete(b.ids, id)
+		return
+	}
+	if b.ids == nil {
+		b.ids = make(map[string]struct{})
+	}
+	b.ids[id] = struct{}{}
+}
+
+func (b *blockSet) has(id string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.ids[id]
+	return ok
+}
+
+// Block stops messages sent by req.Target from being delivered to req.ID.
+// The target need not be connected.
+func (s *chatService) Block(ctx context.Context, req model.BlockRequest) (*model.BlockResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	return s.setBlocked(ctx, req, true)
+}
+
+func (s *chatService) Unblock(ctx context.Context, req model.BlockRequest) (*model.BlockResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	return s.setBlocked(ctx, req, false)
+}
+
+func (s *chatService) setBlocked(ctx context.Context, req model.BlockRequest, blocked bool) (*model.BlockResponse, error) {
+	if req.ID == "" || req.Target == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("id and target are required"))
+	}
+	if s.key(req.ID) == s.key(req.Target) {
+		return nil, errcom.NewCustomError("ERR_INVALID_TARGET", errors.New("users cannot block themselves"))
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, req.Token); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(req.ID)]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	client.blocked.set(s.key(req.Target), blocked)
+
+	msg := "User unblocked"
+	if blocked {
+		msg = "User blocked"
+	}
+	return &model.BlockResponse{Success: true, Message: msg}, nil
+}
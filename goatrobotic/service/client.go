@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"chatbox/model"
+)
+
+// LastSeen returns when the client last polled or otherwise showed activity.
+func (c *Client) LastSeen() time.Time {
+	return time.Unix(0, c.lastSeen.Load())
+}
+
+// touch records activity now.
+func (c *Client) touch() {
+	c.lastSeen.Store(c.clock.Now().UnixNano())
+}
+
+// stale reports whether the client has no receive in flight on any session
+// and has been inactive for longer than after.
+func (c *Client) stale(after time.Duration) bool {
+	for _, sess := range c.sessionList() {
+		if sess.receiving.Load() {
+			return false
+		}
+	}
+	return c.clock.Now().Sub(c.LastSeen()) > after
+}
+
+// delivery is the outcome of offering a message to a client.
+type delivery int
+
+const (
+	delivered delivery = iota
+	// dropped means the client's buffer was full.
+	dropped
+	// streamClosed means the client has left or been evicted.
+	streamClosed
+	// senderBlocked means the client has blocked the sender.
+	senderBlocked
+	// canceled means the fan-out was canceled while waiting for room.
+	canceled
+)
+
+// PriorityBufferSize is how many priority messages each client can queue
+// ahead of its chat buffer.
+const PriorityBufferSize = 8
+
+// priority reports whether msg is queued ahead of chat messages: presence
+// notices and announcements, which must reach clients whose chat buffer is
+// full.
+func priority(msg model.Message) bool {
+	return msg.Type == model.MessageTypePresence || msg.Type == model.MessageTypeAnnouncement
+}
+
+// deliver offers msg to the mailbox of each of the client's sessions,
+// waiting up to wait for room in any that is full, or until ctx is done. It
+// reports delivered if any session queued msg, and otherwise the outcome of
+// the last session.
+func (c *Client) deliver(ctx context.Context, msg model.Message, wait time.Duration) delivery {
+	msg.Queued = c.clock.Now()
+	result := streamClosed
+	for _, sess := range c.sessionList() {
+		if d := sess.box.put(ctx, msg, priority(msg), wait); d == delivered || result != delivered {
+			result = d
+		}
+	}
+	return result
+}
+
+// force queues msg for every session of the client, displacing its oldest
+// chat messages if need be; see mailbox.force. It reports whether any
+// session queued msg and how many messages were displaced.
+func (c *Client) force(msg model.Message) (ok bool, displaced int) {
+	msg.Queued = c.clock.Now()
+	for _, sess := range c.sessionList() {
+		d, lost := sess.box.force(msg)
+		ok = ok || d == delivered
+		if lost {
+			displaced++
+		}
+	}
+	return ok, displaced
+}
+
+// closeStream closes the mailbox of each of the client's sessions, waking
+// any receive. It is safe to call more than once.
+func (c *Client) closeStream() {
+	for _, sess := range c.sessionList() {
+		sess.box.close()
+	}
+}
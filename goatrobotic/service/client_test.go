@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// TestReceiveTimeoutHint checks that a timed-out receive suggests when to
+// poll again, backing off as more receivers wait.
+func TestReceiveTimeoutHint(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+
+	hint := func(t *testing.T) map[string]any {
+		t.Helper()
+		_, err := s.GetMessage(ctx, model.MessageRequest{ID: "alice", Token: alice, Wait: "10ms"})
+		wantCode(t, err, "ERR_NO_MESSAGES")
+		return errcom.Details(err)
+	}
+	if got := hint(t); got["retry_after_ms"] != int64(100) || got["poll_timeout_ms"] != int64(10) {
+		t.Fatalf("details = %v, want retry_after_ms 100 and poll_timeout_ms 10", got)
+	}
+	s.waiting.Add(300)
+	defer s.waiting.Add(-300)
+	if got := hint(t); got["retry_after_ms"] != int64(400) {
+		t.Fatalf("with 300 receivers waiting, details = %v, want retry_after_ms 400", got)
+	}
+}
+
+// TestGetMessageDuringCleanup polls while the cleanup loop reads LastSeen.
+// Run it with -race. The polling client stays connected; the idle one is
+// evicted.
+func TestGetMessageDuringCleanup(t *testing.T) {
+	s := newTestService(t, WithCleanupInterval(time.Millisecond), WithIdleTimeout(30*time.Millisecond))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	join(t, s, "idle", "lobby")
+
+	deadline := time.Now().Add(150 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if _, err := s.GetMessage(ctx, model.MessageRequest{ID: "alice", Token: alice, Wait: "5ms"}); err != nil {
+			wantCode(t, err, "ERR_NO_MESSAGES")
+		}
+	}
+
+	s.mu.RLock()
+	_, polling := s.streams["alice"]
+	_, idle := s.streams["idle"]
+	s.mu.RUnlock()
+	if !polling {
+		t.Error("polling client was evicted")
+	}
+	if idle {
+		t.Error("idle client was not evicted")
+	}
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chatbox/model"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// waitForTimers waits until n After calls are pending on c, so that an
+// Advance reaches the goroutines that made them.
+func waitForTimers(t *testing.T, c *FakeClock, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		pending := len(c.waiters)
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers pending, want %d", pending, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// connected reports whether id is still connected, waiting briefly for the
+// cleanup loop to evict it.
+func connected(s *chatService, id string) bool {
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.RLock()
+		_, ok := s.streams[id]
+		s.mu.RUnlock()
+		if !ok || time.Now().After(deadline) {
+			return ok
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFakeClockIdleEviction(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	s := newTestService(t, WithClock(clock), WithCleanupInterval(time.Minute), WithIdleTimeout(5*time.Minute))
+	join(t, s, "alice", "lobby")
+
+	waitForTimers(t, clock, 1)
+	clock.Advance(4 * time.Minute)
+	s.mu.RLock()
+	_, ok := s.streams["alice"]
+	s.mu.RUnlock()
+	if !ok {
+		t.Fatal("client evicted before its idle timeout")
+	}
+
+	waitForTimers(t, clock, 1)
+	clock.Advance(2 * time.Minute)
+	if connected(s, "alice") {
+		t.Fatal("client still connected after its idle timeout")
+	}
+	if got := s.presenceMessage(model.PresenceLeave, &Client{}, 0).Timestamp; !got.Equal(epoch.Add(6 * time.Minute)) {
+		t.Fatalf("notice timestamp = %v, want the fake clock's time", got)
+	}
+}
+
+func TestFakeClockReceiveTimeout(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	s := newTestService(t, WithClock(clock))
+	alice := join(t, s, "alice", "lobby")
+	waitForTimers(t, clock, 1) // the cleanup loop
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.GetMessage(context.Background(), model.MessageRequest{ID: "alice", Token: alice, Wait: "30s"})
+		done <- err
+	}()
+	waitForTimers(t, clock, 2)
+	select {
+	case err := <-done:
+		t.Fatalf("GetMessage returned %v before its wait", err)
+	default:
+	}
+	clock.Advance(30 * time.Second)
+	select {
+	case err := <-done:
+		wantCode(t, err, "ERR_NO_MESSAGES")
+	case <-time.After(time.Second):
+		t.Fatal("GetMessage did not time out when the clock passed its wait")
+	}
+}
+
+func TestFakeClockMessageTTL(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	s := newTestService(t, WithClock(clock), WithMessageTTL(time.Minute))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	receive(s, "alice", alice) // bob's join notice, already queued
+
+	send := func(text string) {
+		t.Helper()
+		if _, err := s.SendMessage(ctx, model.SendMessageRequest{From: "bob", Token: bob, To: "alice", Message: text}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+	send("stale")
+	clock.Advance(2 * time.Minute)
+	send("fresh")
+
+	msg, err := s.GetMessage(ctx, model.MessageRequest{ID: "alice", Token: alice})
+	if err != nil || msg.Text != "fresh" {
+		t.Fatalf("GetMessage = %+v, %v; want only the fresh message", msg, err)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const receiveWaitHelp = "Time GetMessage spent waiting for a message."
+
+// newReceiveWaitHistogram returns the histogram a service records its
+// receive waits in for export, each with its trace as an exemplar.
+func newReceiveWaitHistogram() prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_receive_wait_seconds",
+		Help:    receiveWaitHelp,
+		Buckets: receiveWaitBuckets[:],
+	})
+}
+
+// collector exports a service's counters to Prometheus.
+type collector struct {
+	cs ChatService
+	// receiveWaitLive is the service's own receive wait histogram, which
+	// carries exemplars. It is nil for other ChatService implementations,
+	// whose histogram is built from their stats snapshot instead.
+	receiveWaitLive prometheus.Histogram
+
+	joins        *prometheus.Desc
+	leaves       *prometheus.Desc
+	messagesSent *prometheus.Desc
+	dropped      *prometheus.Desc
+	rateLimited  *prometheus.Desc
+	redactions   *prometheus.Desc
+	connected    *prometheus.Desc
+	receiveWait  *prometheus.Desc
+}
+
+// NewCollector returns a prometheus.Collector reporting the counters of cs.
+func NewCollector(cs ChatService) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("chat_"+name, help, nil, nil)
+	}
+	c := &collector{
+		cs:           cs,
+		joins:        desc("joins_total", "Clients that joined."),
+		leaves:       desc("leaves_total", "Clients that left."),
+		messagesSent: desc("messages_sent_total", "Messages broadcast by clients."),
+		dropped:      desc("messages_dropped_total", "Deliveries dropped because a client's buffer was full."),
+		rateLimited:  desc("rate_limited_total", "Sends rejected by the rate limiter."),
+		redactions:   desc("redactions_total", "Redactions made by the compliance filter."),
+		connected:    desc("connected_clients", "Clients currently joined."),
+		receiveWait:  desc("receive_wait_seconds", receiveWaitHelp),
+	}
+	if t, ok := cs.(*tracedService); ok {
+		cs = t.ChatService
+	}
+	if s, ok := cs.(*chatService); ok {
+		c.receiveWaitLive = s.stats.receiveWait.export
+	}
+	return c
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.joins
+	ch <- c.leaves
+	ch <- c.messagesSent
+	ch <- c.dropped
+	ch <- c.rateLimited
+	ch <- c.redactions
+	ch <- c.connected
+	if c.receiveWaitLive != nil {
+		c.receiveWaitLive.Describe(ch)
+		return
+	}
+	ch <- c.receiveWait
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.cs.SnapshotStats(false)
+	counter := func(d *prometheus.Desc, v uint64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v))
+	}
+	counter(c.joins, stats.Joins)
+	counter(c.leaves, stats.Leaves)
+	counter(c.messagesSent, stats.MessagesSent)
+	counter(c.dropped, stats.Dropped)
+	counter(c.rateLimited, stats.RateLimited)
+	counter(c.redactions, stats.Redactions)
+	ch <- prometheus.MustNewConstMetric(c.connected, prometheus.GaugeValue, float64(stats.Connected))
+
+	if c.receiveWaitLive != nil {
+		c.receiveWaitLive.Collect(ch)
+		return
+	}
+
+	buckets := make(map[float64]uint64, len(stats.ReceiveWait.Buckets))
+	for _, b := range stats.ReceiveWait.Buckets {
+		buckets[b.UpperBound] = b.Count
+	}
+	ch <- prometheus.MustNewConstHistogram(c.receiveWait, stats.ReceiveWait.Count, stats.ReceiveWait.Sum, buckets)
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// CommandFunc handles a slash command sent by the user from. args is the
+// text following the command name, with surrounding whitespace removed. The
+// returned string is sent back to the caller instead of being broadcast.
+type CommandFunc func(ctx context.Context, from, args string) (string, error)
+
+// RegisterCommand adds or replaces a slash command. Commands are only
+// dispatched when Config.EnableCommands is set.
+func (s *chatService) RegisterCommand(name string, fn CommandFunc) {
+	s.cmdMu.Lock()
+	defer s.cmdMu.Unlock()
+	s.commands[name] = fn
+}
+
+func (s *chatService) registerBuiltinCommands() {
+	s.RegisterCommand("users", s.usersCommand)
+	s.RegisterCommand("leave", s.leaveCommand)
+	s.RegisterCommand("nick", s.nickCommand)
+}
+
+// isCommand reports whether text should be dispatched as a command.
+func (s *chatService) isCommand(text string) bool {
+	return s.cfg.EnableCommands && strings.HasPrefix(text, s.cfg.CommandPrefix)
+}
+
+// runCommand dispatches a message starting with the command prefix.
+func (s *chatService) runCommand(ctx context.Context, from, text string) (*model.SendMessageResponse, error) {
+	name, args, _ := strings.Cut(strings.TrimPrefix(text, s.cfg.CommandPrefix), " ")
+
+	s.cmdMu.RLock()
+	fn, ok := s.commands[name]
+	s.cmdMu.RUnlock()
+
+	if !ok {
+		return nil, errcom.NewCustomError("ERR_UNKNOWN_COMMAND", fmt.Errorf("unknown command %q", name))
+	}
+	result, err := fn(ctx, from, strings.TrimSpace(args))
+	if err != nil {
+		return nil, err
+	}
+	return &model.SendMessageResponse{
+		Success: true,
+		Message: result,
+		Command: name,
+	}, nil
+}
+
+// usersCommand lists the users in the caller's room.
+func (s *chatService) usersCommand(ctx context.Context, from, args string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sender, exists := s.streams[s.key(from)]
+	if !exists {
+		return "", errcom.NewCustomError("ERR_SENDER_NOT_FOUND", errors.New("sender not connected"))
+	}
+	names := []string{}
+	for _, client := range s.streams {
+		if client.Room == sender.Room && !client.Observer {
+			names = append(names, client.Name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", "), nil
+}
+
+func (s *chatService) leaveCommand(ctx context.Context, from, args string) (string, error) {
+	res, err := s.Leave(ctx, model.LeaveRequest{ID: from})
+	if err != nil {
+		return "", err
+	}
+	return res.Message, nil
+}
+
+// nickCommand changes the caller's display name.
+func (s *chatService) nickCommand(ctx context.Context, from, args string) (string, error) {
+	if args == "" {
+		return "", errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("usage: nick <name>"))
+	}
+	if err := s.rename(from, args); err != nil {
+		return "", err
+	}
+	return "You are now known as " + args, nil
+}
+
+// checkDisplayName validates a name a client is to be shown under.
+func (s *chatService) checkDisplayName(name string) error {
+	if err := checkLength("name", name, s.cfg.MaxNameLen); err != nil {
+		return err
+	}
+	if err := checkRenderable("name", "ERR_INVALID_NAME", name); err != nil {
+		return err
+	}
+	if s.cfg.FilterNames {
+		return checkName("name", name, s.words)
+	}
+	return nil
+}
+
+// Rename changes req.ID's display name.
+func (s *chatService) Rename(ctx context.Context, req model.RenameRequest) (*model.RenameResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" || req.Name == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("id and name are required"))
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, req.Token); err != nil {
+		return nil, err
+	}
+	if err := s.rename(req.ID, req.Name); err != nil {
+		return nil, err
+	}
+	return &model.RenameResponse{Success: true, Message: "You are now known as " + req.Name}, nil
+}
+
+// rename changes a client's display name and announces it to the others.
+func (s *chatService) rename(id, name string) error {
+	if err := s.checkDisplayName(name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, exists := s.streams[s.key(id)]
+	if !exists {
+		return errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	old := client.Name
+	client.Name = name
+	notice := s.presenceMessage(model.PresenceRename, client, s.participants(client.Room))
+	notice.Text = "* " + old + " is now known as " + name
+	s.announce(notice, client)
+	return nil
+}
@@ -0,0 +1,36 @@
+package service
+
+import "regexp"
+
+// ComplianceFilter rewrites a message before it leaves the sender and reports
+// how many redactions it made. It is called concurrently and must not block.
+type ComplianceFilter func(text string) (string, int)
+
+// NoopComplianceFilter passes every message through unchanged.
+func NoopComplianceFilter(text string) (string, int) {
+	return text, 0
+}
+
+// Sample PII patterns for use with NewRegexRedactor.
+var (
+	CreditCardPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	EmailPattern      = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	SSNPattern        = regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)
+)
+
+const redactedText = "[REDACTED]"
+
+// NewRegexRedactor returns a ComplianceFilter that replaces every match of
+// the given patterns with "[REDACTED]". Patterns are applied in order.
+func NewRegexRedactor(patterns ...*regexp.Regexp) ComplianceFilter {
+	return func(text string) (string, int) {
+		count := 0
+		for _, p := range patterns {
+			text = p.ReplaceAllStringFunc(text, func(string) string {
+				count++
+				return redactedText
+			})
+		}
+		return text, count
+	}
+}
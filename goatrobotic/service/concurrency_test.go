@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"chatbox/model"
+)
+
+// TestConcurrentJoinSendReceiveLeave churns hundreds of clients through a
+// room while the cleanup loop evicts idle ones. Run it with -race; errors
+// from evicted or rate-limited clients are expected and ignored.
+func TestConcurrentJoinSendReceiveLeave(t *testing.T) {
+	s := newTestService(t, WithCleanupInterval(time.Millisecond), WithIdleTimeout(5*time.Millisecond), WithBufferSize(4))
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := range 200 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("user%d", i)
+			res, err := s.Join(ctx, model.JoinRequest{ID: id, Room: "lobby"})
+			if err != nil {
+				return
+			}
+			for range 5 {
+				s.SendMessage(ctx, model.SendMessageRequest{From: id, Token: res.Token, Message: "hello"})
+				s.GetMessage(ctx, model.MessageRequest{ID: id, Token: res.Token, Wait: "1ms"})
+			}
+			s.Leave(ctx, model.LeaveRequest{ID: id, Token: res.Token})
+		}()
+	}
+	wg.Wait()
+}
+
+// TestSendRacingLeave sends to a recipient while it leaves, which must not
+// write to its closed mailbox.
+func TestSendRacingLeave(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+
+	for i := range 50 {
+		id := fmt.Sprintf("bob%d", i)
+		bob := join(t, s, id, "lobby")
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, To: id, Message: "hi"})
+		}()
+		go func() {
+			defer wg.Done()
+			s.Leave(ctx, model.LeaveRequest{ID: id, Token: bob})
+		}()
+		wg.Wait()
+	}
+}
+
+// TestConcurrentSendIDs fires 1000 sends at once from several users and
+// checks that each gets its own ID and reaches the recipient exactly once.
+// Run it with -race.
+func TestConcurrentSendIDs(t *testing.T) {
+	const senders, perSender = 10, 100
+	s := newTestService(t, WithRateLimit(rate.Inf, 1), WithRoomRateLimit(rate.Inf, 1), WithBufferSize(2*senders*perSender))
+	ctx := context.Background()
+	bob := join(t, s, "bob", "lobby")
+	tokens := make([]string, senders)
+	for i := range senders {
+		tokens[i] = join(t, s, fmt.Sprintf("user%d", i), "lobby")
+	}
+
+	ids := make(chan string, senders*perSender)
+	var wg sync.WaitGroup
+	for i := range senders {
+		for range perSender {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := s.SendMessage(ctx, model.SendMessageRequest{From: fmt.Sprintf("user%d", i), Token: tokens[i], To: "bob", Message: "hi"})
+				if err != nil {
+					t.Errorf("SendMessage: %v", err)
+					return
+				}
+				ids <- res.MessageID
+			}()
+		}
+	}
+	wg.Wait()
+	close(ids)
+
+	sent := make(map[string]bool)
+	for id := range ids {
+		if id == "" || sent[id] {
+			t.Fatalf("send returned ID %q twice or empty", id)
+		}
+		sent[id] = true
+	}
+	received := make(map[string]bool)
+	for len(received) < len(sent) {
+		msg, err := receive(s, "bob", bob)
+		if err != nil {
+			t.Fatalf("bob received %d of %d messages: %v", len(received), len(sent), err)
+		}
+		if msg.Type != model.MessageTypeChat {
+			continue
+		}
+		if !sent[msg.ID] || received[msg.ID] {
+			t.Fatalf("bob received ID %q, which was not sent or arrived twice", msg.ID)
+		}
+		received[msg.ID] = true
+	}
+	if len(sent) != senders*perSender {
+		t.Fatalf("%d sends succeeded, want %d", len(sent), senders*perSender)
+	}
+}
+
+// TestSendRacingRename renames a user while they send, which must read the
+// display name under the lock. Run it with -race.
+func TestSendRacingRename(t *testing.T) {
+	s := newTestService(t, WithRateLimit(rate.Inf, 1))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	join(t, s, "bob", "lobby")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			if _, err := s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, Message: "hi"}); err != nil {
+				t.Errorf("SendMessage: %v", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := range 100 {
+			if _, err := s.Rename(ctx, model.RenameRequest{ID: "alice", Token: alice, Name: fmt.Sprintf("Alice%d", i)}); err != nil {
+				t.Errorf("Rename: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestDeliverAfterClose(t *testing.T) {
+	s := newTestService(t)
+	join(t, s, "alice", "lobby")
+	client := s.streams["alice"]
+	client.closeStream()
+	if d := client.deliver(context.Background(), model.Message{Body: "late"}, time.Second); d != streamClosed {
+		t.Fatalf("deliver after close = %v, want streamClosed", d)
+	}
+}
+
+func TestSendAfterClose(t *testing.T) {
+	s := newTestService(t)
+	alice := join(t, s, "alice", "lobby")
+	join(t, s, "bob", "lobby")
+	s.Close()
+	_, err := s.SendMessage(context.Background(), model.SendMessageRequest{From: "alice", Token: alice, Message: "late"})
+	wantCode(t, err, "ERR_SERVICE_UNAVAILABLE")
+}
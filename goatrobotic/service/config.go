@@ -0,0 +1,419 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"time"
+
+	errcom "chatbox/error"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/time/rate"
+)
+
+// Config holds the tunable behaviour of a chat service. Fields tagged
+// `redact:"true"` are secrets and are masked by RedactedConfig.
+type Config struct {
+	// IdleTimeout is how long a client may go without polling before the
+	// cleanup loop evicts it.
+	IdleTimeout time.Duration `json:"idle_timeout"`
+	// AwayAfter is how long a client may be inactive before its status
+	// becomes away; zero disables automatic away.
+	AwayAfter time.Duration `json:"away_after"`
+	// StaleAfter is how long a client must be inactive before a forced
+	// join may replace it.
+	StaleAfter time.Duration `json:"stale_after"`
+	// CleanupInterval is how often the cleanup loop runs.
+	CleanupInterval time.Duration `json:"cleanup_interval"`
+	// ReceiveTimeout bounds how long GetMessage waits for a message unless
+	// the request asks for another wait, which may be at most
+	// MaxReceiveWait.
+	ReceiveTimeout time.Duration `json:"receive_timeout"`
+	MaxReceiveWait time.Duration `json:"max_receive_wait"`
+	// HeartbeatInterval is how often StartHeartbeat injects a heartbeat
+	// message into a client's stream.
+	HeartbeatInterval time.Duration `json:"heartbeat_interval"`
+	// MaxFanoutDuration bounds how long a single broadcast may spend
+	// delivering. Recipients not reached in time are skipped. Zero means
+	// no limit.
+	MaxFanoutDuration time.Duration `json:"max_fanout_duration"`
+	// FairDelivery hands message fan-out to a pool of workers, one per
+	// CPU, that take turns between rooms, so a room flooded with messages
+	// cannot starve delivery in quiet ones. Each sender still waits for its
+	// own message to be delivered. Off by default, when every sender
+	// delivers its own message at once.
+	FairDelivery bool `json:"fair_delivery"`
+	// DeadLetterSize is how many dropped messages are kept per client for
+	// later recovery; zero disables dead-lettering. Entries older than
+	// DeadLetterTTL are discarded.
+	DeadLetterSize int           `json:"dead_letter_size"`
+	DeadLetterTTL  time.Duration `json:"dead_letter_ttl"`
+	// HistoryMaxBytes caps the total size of the messages kept in each
+	// room's history; the oldest are evicted first.
+	HistoryMaxBytes int `json:"history_max_bytes"`
+	// MaxBufferedBytes caps the total size of the messages waiting in
+	// every client's buffer; zero means unlimited. Once it is exceeded,
+	// PressurePolicy decides what happens to new room broadcasts until
+	// clients catch up. Direct messages and presence notices are let
+	// through.
+	MaxBufferedBytes int64          `json:"max_buffered_bytes"`
+	PressurePolicy   PressurePolicy `json:"pressure_policy"`
+	// HistoryLimit caps the number of messages kept in each room's
+	// history and HistoryMaxAge their age; zero means no such limit.
+	// Whichever limit is reached first evicts the oldest messages.
+	HistoryLimit  int           `json:"history_limit"`
+	HistoryMaxAge time.Duration `json:"history_max_age"`
+	// MaxClients caps the number of connected clients and MaxRoomMembers
+	// the members of each room; zero means unlimited. SetRoomLimits can
+	// override MaxRoomMembers per room.
+	MaxClients     int `json:"max_clients"`
+	MaxRoomMembers int `json:"max_room_members"`
+	// MaxSessions caps the connections one user ID may hold at once, such
+	// as one per device. A further session is opened by a join into the
+	// user's room that proves it is the user's, either with AddSession and
+	// the token of an existing session or with an authenticated identity;
+	// any other join fails with ERR_ALREADY_JOINED. Each session receives
+	// every message sent to the user, and the user leaves the room when its
+	// last session does. Defaults to 1.
+	MaxSessions int `json:"max_sessions"`
+	// MaxRooms caps how many rooms may have members at once; zero means
+	// unlimited. EmptyRoomTTL, when set, is how long a room may stay empty
+	// before its history is deleted.
+	MaxRooms     int           `json:"max_rooms"`
+	EmptyRoomTTL time.Duration `json:"empty_room_ttl"`
+	// BufferSize is the capacity of each client's message channel: how
+	// many messages a client may fall behind before DeliveryPolicy decides
+	// what happens to the next one.
+	BufferSize int `json:"buffer_size"`
+	// DeliveryPolicy decides what happens to a message for a client whose
+	// buffer is full. DeliveryTimeout is how long DeliveryBlock waits.
+	DeliveryPolicy  DeliveryPolicy `json:"delivery_policy"`
+	DeliveryTimeout time.Duration  `json:"delivery_timeout"`
+	// OverloadThreshold is the fraction of recipients, between 0 and 1,
+	// that may drop a send before its response is flagged overloaded;
+	// zero disables the flag.
+	OverloadThreshold float64 `json:"overload_threshold"`
+	// MessageTTL is how long a message may wait on a client's stream before
+	// it is discarded unread; zero keeps messages until they are read.
+	MessageTTL time.Duration `json:"message_ttl"`
+	// RequireReceivers makes a room message that reaches no one fail with
+	// ERR_NO_RECEIVERS instead of succeeding with nothing delivered. A
+	// message that fails this way is neither recorded nor published.
+	// Direct and group messages always require a connected recipient.
+	RequireReceivers bool `json:"require_receivers"`
+	// DirectOnly disables room broadcasts: every message must name its
+	// recipients with To or ToList, and others fail with
+	// ERR_BROADCAST_DISABLED. Commands and presence notices are unaffected.
+	DirectOnly bool `json:"direct_only"`
+	// ReceiptTTL is how long the acknowledgements of a sent message are
+	// kept for its sender to query.
+	ReceiptTTL time.Duration `json:"receipt_ttl"`
+	// MaxMessageLen is the longest message, in characters, SendMessage
+	// accepts.
+	MaxMessageLen int `json:"max_message_len"`
+	// MaxAttachmentBytes is the largest decoded attachment SendMessage
+	// accepts. It does not count towards MaxMessageLen.
+	MaxAttachmentBytes int `json:"max_attachment_bytes"`
+	// MaxIDLen, MaxRoomNameLen and MaxNameLen cap the length of user IDs,
+	// room names and display names.
+	MaxIDLen       int `json:"max_id_len"`
+	MaxRoomNameLen int `json:"max_room_name_len"`
+	MaxNameLen     int `json:"max_name_len"`
+	// UserIDPattern, when set, is a regular expression the whole of each
+	// user ID must match, e.g. ^[a-z0-9_-]+$.
+	UserIDPattern string `json:"user_id_pattern"`
+	// MessageFormat, when set, is a text/template rendering each chat
+	// message's delivered text from MessageFields, e.g.
+	// "{{.From}} [{{.Time.Format \"15:04\"}}]: {{.Text}}". The structured
+	// fields are delivered unchanged. Empty means "{{.From}}: {{.Text}}".
+	MessageFormat string `json:"message_format"`
+	// NormalizeIDs keys clients by the NFKC, case-folded form of their ID
+	// while keeping the ID as given for display.
+	NormalizeIDs bool `json:"normalize_ids"`
+	// MaxAttributes and MaxAttributesBytes bound the metadata a client may
+	// attach: the number of keys and the total size of keys and values.
+	MaxAttributes      int `json:"max_attributes"`
+	MaxAttributesBytes int `json:"max_attributes_bytes"`
+	// RateLimit and RateBurst configure each client's send limiter.
+	RateLimit rate.Limit `json:"rate_limit"`
+	RateBurst int        `json:"rate_burst"`
+	// MessageQuota caps how many messages each client may send within any
+	// QuotaWindow, regardless of RateLimit; zero means no quota.
+	MessageQuota int           `json:"message_quota"`
+	QuotaWindow  time.Duration `json:"quota_window"`
+	// JoinRateLimit and JoinRateBurst limit joins from each client IP;
+	// a zero JoinRateLimit disables the limit.
+	JoinRateLimit rate.Limit `json:"join_rate_limit"`
+	JoinRateBurst int        `json:"join_rate_burst"`
+	// RoomRateLimit and RoomRateBurst cap the combined rate of broadcasts
+	// in each room on this instance; a zero RoomRateLimit disables the cap
+	// unless SetRoomLimits sets one for the room.
+	RoomRateLimit rate.Limit `json:"room_rate_limit"`
+	RoomRateBurst int        `json:"room_rate_burst"`
+
+	// MaxBatchSize caps the messages in one SendBatch. BatchRateOnce
+	// charges a batch a single token of the sender's rate limit instead of
+	// one per message.
+	MaxBatchSize  int  `json:"max_batch_size"`
+	BatchRateOnce bool `json:"batch_rate_once"`
+
+	// IdempotencyTTL is how long a send's idempotency key or client message
+	// ID is remembered and IdempotencyKeys caps how many are kept per
+	// sender, evicting the oldest first.
+	IdempotencyTTL  time.Duration `json:"idempotency_ttl"`
+	IdempotencyKeys int           `json:"idempotency_keys"`
+
+	// BannedWords is the word list used for content filtering. FilterNames
+	// applies it to user and room names at join time.
+	BannedWords []string `json:"-"`
+	FilterNames bool     `json:"filter_names"`
+	// ControlChars decides what happens to C0 control characters other
+	// than newline and tab in messages.
+	ControlChars ControlCharPolicy `json:"control_chars"`
+
+	// ModerationAction decides what happens to a message containing a
+	// banned word. QuarantineSize caps how many messages may await review.
+	ModerationAction ModerationAction `json:"moderation_action"`
+	QuarantineSize   int              `json:"quarantine_size"`
+	// ReportLogSize caps the audit log of reported messages and the
+	// reports queued in each room for a moderator.
+	ReportLogSize int `json:"report_log_size"`
+
+	// EnableCommands makes messages starting with CommandPrefix run as
+	// server-side commands instead of being broadcast.
+	EnableCommands bool   `json:"enable_commands"`
+	CommandPrefix  string `json:"command_prefix"`
+
+	// WSCompression enables permessage-deflate on WebSocket connections
+	// whose client negotiates it, trading CPU for bandwidth.
+	WSCompression bool `json:"ws_compression"`
+
+	// AdminSecret guards the admin API. Empty disables it.
+	AdminSecret string `json:"admin_secret" redact:"true"`
+
+	// ComplianceFilter is applied once to every message before it is fanned
+	// out, e.g. to redact PII. It runs on the hot path of each send and must
+	// be cheap. Defaults to NoopComplianceFilter.
+	ComplianceFilter ComplianceFilter `json:"-"`
+	// EventBufferSize is how many events each Subscribe channel holds
+	// before further ones are dropped.
+	EventBufferSize int `json:"event_buffer_size"`
+	// DeadLetterSink is told about every failed delivery. Defaults to
+	// NoopDeadLetterSink.
+	DeadLetterSink DeadLetterSink `json:"-"`
+	// Moderator screens every chat message before delivery. Defaults to
+	// NoopModerator.
+	Moderator Moderator `json:"-"`
+	// Clock is the source of time for idle tracking, cleanup, receive
+	// timeouts and message expiry. Defaults to SystemClock.
+	Clock Clock `json:"-"`
+	// Tracer records a span for each user-facing call. Defaults to a no-op
+	// tracer.
+	Tracer trace.Tracer `json:"-"`
+	// SnapshotPath, when set and Store is a Snapshotter, is where room
+	// history is saved on Shutdown and restored from on startup.
+	SnapshotPath string `json:"snapshot_path"`
+	// Store records room history. Defaults to a memory store bounded by
+	// HistoryMaxBytes, HistoryLimit and HistoryMaxAge.
+	Store Store `json:"-"`
+	// Backend shares users and broadcasts with other instances. Defaults
+	// to NewMemoryBackend for a single instance.
+	Backend Backend `json:"-"`
+}
+
+// ModerationAction is applied to messages flagged by the banned-word list.
+type ModerationAction string
+
+const (
+	// ModerationOff delivers messages without screening them.
+	ModerationOff ModerationAction = ""
+	// ModerationReject refuses flagged messages with ERR_MESSAGE_REJECTED.
+	ModerationReject ModerationAction = "reject"
+	// ModerationMask delivers flagged messages with banned words masked.
+	ModerationMask ModerationAction = "mask"
+	// ModerationQuarantine holds flagged messages until an admin approves
+	// or discards them.
+	ModerationQuarantine ModerationAction = "quarantine"
+)
+
+// PressurePolicy is applied to room broadcasts once MaxBufferedBytes is
+// exceeded.
+type PressurePolicy string
+
+const (
+	// PressureReject refuses broadcasts with ERR_MEMORY_PRESSURE.
+	PressureReject PressurePolicy = ""
+	// PressureShed records broadcasts in history without queueing them
+	// for anyone, reporting them as shed.
+	PressureShed PressurePolicy = "shed"
+)
+
+// DeliveryPolicy is applied when a recipient's buffer is full.
+type DeliveryPolicy string
+
+const (
+	// DeliveryDropNewest drops the new message, keeping it as a dead
+	// letter when dead-lettering is enabled.
+	DeliveryDropNewest DeliveryPolicy = ""
+	// DeliveryBlock waits up to DeliveryTimeout for room, then drops. The
+	// wait holds up the rest of the fan-out, so keep it short or bound the
+	// fan-out with MaxFanoutDuration.
+	DeliveryBlock DeliveryPolicy = "block"
+	// DeliveryDisconnect closes the client's stream and evicts it, so a
+	// slow client finds out instead of silently missing messages.
+	DeliveryDisconnect DeliveryPolicy = "disconnect"
+)
+
+// ControlCharPolicy is applied to control characters in messages.
+type ControlCharPolicy string
+
+const (
+	// ControlCharsAllow delivers control characters as sent.
+	ControlCharsAllow ControlCharPolicy = ""
+	// ControlCharsStrip removes them before delivery.
+	ControlCharsStrip ControlCharPolicy = "strip"
+	// ControlCharsReject refuses messages containing them with
+	// ERR_INVALID_MESSAGE.
+	ControlCharsReject ControlCharPolicy = "reject"
+)
+
+// DefaultConfig returns the configuration used by NewChatService.
+func DefaultConfig() Config {
+	return Config{
+		IdleTimeout:        5 * time.Minute,
+		CleanupInterval:    1 * time.Minute,
+		ReceiveTimeout:     10 * time.Second,
+		MaxReceiveWait:     time.Minute,
+		StaleAfter:         30 * time.Second,
+		AwayAfter:          2 * time.Minute,
+		HeartbeatInterval:  30 * time.Second,
+		BufferSize:         10,
+		DeliveryTimeout:    100 * time.Millisecond,
+		MaxMessageLen:      500,
+		MaxAttachmentBytes: 256 << 10,
+		MaxIDLen:           64,
+		MaxRoomNameLen:     64,
+		MaxNameLen:         64,
+		RateLimit:          1,
+		RateBurst:          5,
+		MaxBatchSize:       20,
+		MaxSessions:        1,
+		JoinRateLimit:      1,
+		QuotaWindow:        time.Hour,
+		JoinRateBurst:      10,
+		RoomRateBurst:      20,
+		IdempotencyTTL:     2 * time.Minute,
+		IdempotencyKeys:    100,
+		QuarantineSize:     100,
+		ReportLogSize:      100,
+		DeadLetterTTL:      5 * time.Minute,
+		EventBufferSize:    64,
+		ReceiptTTL:         10 * time.Minute,
+		CommandPrefix:      "/",
+		HistoryMaxBytes:    1 << 20,
+		MaxAttributes:      16,
+		MaxAttributesBytes: 1024,
+		ComplianceFilter:   NoopComplianceFilter,
+		Tracer:             noop.NewTracerProvider().Tracer(""),
+	}
+}
+
+// Validate reports the first problem with cfg: a negative size, rate, limit
+// or duration, a zero buffer size, send rate or burst, or an unknown policy.
+// Other zero values are valid, as they stand for the default.
+func (cfg Config) Validate() error {
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("json")
+		if name == "" || name == "-" {
+			continue
+		}
+		value := v.Field(i)
+		negative := false
+		switch value.Kind() {
+		case reflect.Int, reflect.Int64:
+			negative = value.Int() < 0
+		case reflect.Float64:
+			negative = value.Float() < 0
+		}
+		if negative {
+			return errcom.NewCustomError("ERR_INVALID_CONFIG", fmt.Errorf("%s must not be negative", name))
+		}
+	}
+	// A service with no buffer or no send rate could never deliver.
+	if cfg.BufferSize == 0 {
+		return errcom.NewCustomError("ERR_INVALID_CONFIG", errors.New("buffer_size must be positive"))
+	}
+	if cfg.RateLimit == 0 {
+		return errcom.NewCustomError("ERR_INVALID_CONFIG", errors.New("rate_limit must be positive"))
+	}
+	if cfg.RateBurst == 0 {
+		return errcom.NewCustomError("ERR_INVALID_CONFIG", errors.New("rate_burst must be positive"))
+	}
+	if cfg.OverloadThreshold > 1 {
+		return errcom.NewCustomError("ERR_INVALID_CONFIG", errors.New("overload_threshold must be at most 1"))
+	}
+	if _, err := regexp.Compile(cfg.UserIDPattern); err != nil {
+		return errcom.NewCustomError("ERR_INVALID_CONFIG", fmt.Errorf("user_id_pattern: %v", err))
+	}
+	if cfg.MessageFormat != "" {
+		if _, err := parseMessageFormat(cfg.MessageFormat); err != nil {
+			return errcom.NewCustomError("ERR_INVALID_CONFIG", fmt.Errorf("message_format: %v", err))
+		}
+	}
+	switch cfg.PressurePolicy {
+	case PressureReject, PressureShed:
+	default:
+		return errcom.NewCustomError("ERR_INVALID_CONFIG", fmt.Errorf("unknown pressure_policy %q", cfg.PressurePolicy))
+	}
+	switch cfg.DeliveryPolicy {
+	case DeliveryDropNewest, DeliveryBlock, DeliveryDisconnect:
+	default:
+		return errcom.NewCustomError("ERR_INVALID_CONFIG", fmt.Errorf("unknown delivery_policy %q", cfg.DeliveryPolicy))
+	}
+	switch cfg.ModerationAction {
+	case ModerationOff, ModerationReject, ModerationMask, ModerationQuarantine:
+	default:
+		return errcom.NewCustomError("ERR_INVALID_CONFIG", fmt.Errorf("unknown moderation_action %q", cfg.ModerationAction))
+	}
+	switch cfg.ControlChars {
+	case ControlCharsAllow, ControlCharsStrip, ControlCharsReject:
+	default:
+		return errcom.NewCustomError("ERR_INVALID_CONFIG", fmt.Errorf("unknown control_chars %q", cfg.ControlChars))
+	}
+	return nil
+}
+
+// RedactedConfig renders cfg as a JSON-friendly map keyed by each field's
+// json tag. Secret fields are masked, durations are rendered as strings and
+// fields tagged `json:"-"` are omitted.
+func RedactedConfig(cfg Config) map[string]any {
+	out := make(map[string]any)
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("json")
+		if name == "" || name == "-" {
+			continue
+		}
+		value := v.Field(i)
+		switch {
+		case field.Tag.Get("redact") == "true":
+			if value.IsZero() {
+				out[name] = ""
+			} else {
+				out[name] = redactedText
+			}
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			out[name] = time.Duration(value.Int()).String()
+		default:
+			out[name] = value.Interface()
+		}
+	}
+	return out
+}
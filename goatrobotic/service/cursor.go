@@ -0,0 +1,38 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	errcom "chatbox/error"
+)
+
+// Kinds of page cursor, so that a cursor from one listing is not accepted by
+// another.
+const (
+	historyCursor = "history"
+	usersCursor   = "users"
+)
+
+// encodeCursor returns an opaque cursor for position pos in a kind listing.
+func encodeCursor(kind, pos string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(kind + ":" + pos))
+}
+
+// decodeCursor returns the position encoded in a kind cursor.
+func decodeCursor(kind, cursor string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", errInvalidCursor()
+	}
+	got, pos, ok := strings.Cut(string(raw), ":")
+	if !ok || got != kind || pos == "" {
+		return "", errInvalidCursor()
+	}
+	return pos, nil
+}
+
+func errInvalidCursor() error {
+	return errcom.NewCustomError("ERR_INVALID_CURSOR", errors.New("cursor is malformed"))
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// DeadLetterSink is told about every message that could not be delivered.
+// It is called during fan-out with the service lock held, so it must not
+// block.
+type DeadLetterSink interface {
+	Record(dl model.DeadLetter)
+}
+
+// noopDeadLetterSink discards every record.
+type noopDeadLetterSink struct{}
+
+func (noopDeadLetterSink) Record(model.DeadLetter) {}
+
+// NoopDeadLetterSink is the default DeadLetterSink.
+var NoopDeadLetterSink DeadLetterSink = noopDeadLetterSink{}
+
+// channelDeadLetterSink sends records to a channel, dropping them when it is
+// full.
+type channelDeadLetterSink chan<- model.DeadLetter
+
+// NewChannelDeadLetterSink returns a DeadLetterSink that sends each record
+// to ch without blocking; records that do not fit are lost.
+func NewChannelDeadLetterSink(ch chan<- model.DeadLetter) DeadLetterSink {
+	return channelDeadLetterSink(ch)
+}
+
+func (ch channelDeadLetterSink) Record(dl model.DeadLetter) {
+	select {
+	case ch <- dl:
+	default:
+	}
+}
+
+// deadLetters is a bounded ring of messages dropped because a client's
+// buffer was full.
+type deadLetters struct {
+	mu      sync.Mutex
+	entries []deadLetter
+}
+
+type deadLetter struct {
+	msg     model.Message
+	dropped time.Time
+}
+
+// push records msg, dropped at now, discarding the oldest entry once max are
+// held.
+func (d *deadLetters) push(msg model.Message, max int, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.entries) >= max {
+		d.entries = d.entries[1:]
+	}
+	d.entries = append(d.entries, deadLetter{msg: msg, dropped: now})
+}
+
+// take removes every entry and returns those younger than ttl at now.
+func (d *deadLetters) take(ttl time.Duration, now time.Time) []model.Message {
+	d.mu.Lock()
+	entries := d.entries
+	d.entries = nil
+	d.mu.Unlock()
+
+	msgs := make([]model.Message, 0, len(entries))
+	for _, e := range entries {
+		if now.Sub(e.dropped) <= ttl {
+			msgs = append(msgs, e.msg)
+		}
+	}
+	return msgs
+}
+
+// GetDeadLetters returns, oldest first, the messages dropped for a client
+// because its buffer was full, and clears them.
+func (s *chatService) GetDeadLetters(ctx context.Context, id string) (*model.MessagesResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+	if err := s.checkIdentity(ctx, id); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, id, ""); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(id)]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+
+	msgs := client.dead.take(s.cfg.DeadLetterTTL, s.cfg.Clock.Now())
+	res := &model.MessagesResponse{Messages: make([]model.MessageResponse, 0, len(msgs))}
+	for _, msg := range msgs {
+		res.Messages = append(res.Messages, toMessageResponse(msg))
+	}
+	return res, nil
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"chatbox/model"
+)
+
+func TestBlockedDeliveryDoesNotHoldLock(t *testing.T) {
+	s := newTestService(t, WithDeliveryPolicy(DeliveryBlock, 2*time.Second), WithBufferSize(1))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	receive(s, "alice", alice) // bob's join notice
+
+	dm := model.SendMessageRequest{From: "alice", Token: alice, To: "bob", Message: "one"}
+	if _, err := s.SendMessage(ctx, dm); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	// bob's buffer is full, so this send waits for him to receive.
+	sent := make(chan error, 1)
+	go func() {
+		dm.Message = "two"
+		_, err := s.SendMessage(ctx, dm)
+		sent <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	joined := make(chan error, 1)
+	go func() {
+		_, err := s.Join(ctx, model.JoinRequest{ID: "carol", Room: "lobby"})
+		joined <- err
+	}()
+	select {
+	case err := <-joined:
+		if err != nil {
+			t.Fatalf("Join: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Join blocked behind a waiting delivery")
+	}
+
+	for _, want := range []string{"one", "two"} {
+		msg, err := s.GetMessage(ctx, model.MessageRequest{ID: "bob", Token: bob, Wait: "1s"})
+		for err == nil && msg.Type != model.MessageTypeChat {
+			msg, err = s.GetMessage(ctx, model.MessageRequest{ID: "bob", Token: bob, Wait: "1s"})
+		}
+		if err != nil || msg.Text != want {
+			t.Fatalf("bob received %+v, %v; want %q", msg, err, want)
+		}
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("blocked SendMessage: %v", err)
+	}
+}
+
+// TestNoReceiversLeavesNoTrace checks that a send failing with
+// ERR_NO_RECEIVERS is not recorded, counted or reported, so that retrying
+// it cannot leave duplicates behind.
+func TestNoReceiversLeavesNoTrace(t *testing.T) {
+	s := newTestService(t, WithRequireReceivers(true))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	events, stop := s.Subscribe()
+	defer stop()
+
+	for range 2 {
+		_, err := s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, Message: "anyone?"})
+		wantCode(t, err, "ERR_NO_RECEIVERS")
+	}
+	if res, err := s.GetHistory(ctx, model.HistoryRequest{Room: "lobby"}); err != nil || len(res.Messages) != 0 {
+		t.Fatalf("GetHistory = %+v, %v; want no messages", res, err)
+	}
+	if sent := s.Stats(ctx).MessagesSent; sent != 0 {
+		t.Fatalf("MessagesSent = %d, want 0", sent)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("got event %+v for a message nobody received", e)
+	default:
+	}
+}
+
+// TestDeliveryOrderPerSender has several users send rapid bursts at once
+// and checks that the recipient sees each sender's messages in the order
+// they were sent, with and without fair delivery.
+func TestDeliveryOrderPerSender(t *testing.T) {
+	const senders, perSender = 4, 200
+	for _, fair := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fair=%v", fair), func(t *testing.T) {
+			s := newTestService(t, WithFairDelivery(fair), WithRateLimit(rate.Inf, 1), WithRoomRateLimit(rate.Inf, 1), WithBufferSize(2*senders*perSender))
+			ctx := context.Background()
+			tokens := make([]string, senders)
+			for i := range senders {
+				tokens[i] = join(t, s, fmt.Sprint("sender", i), "lobby")
+			}
+			bob := join(t, s, "bob", "lobby")
+
+			var wg sync.WaitGroup
+			for i := range senders {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for n := range perSender {
+						if _, err := s.SendMessage(ctx, model.SendMessageRequest{From: fmt.Sprint("sender", i), Token: tokens[i], Message: fmt.Sprint(n)}); err != nil {
+							t.Errorf("SendMessage: %v", err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			next := make(map[string]int)
+			for received := 0; received < senders*perSender; {
+				msg, err := receive(s, "bob", bob)
+				if err != nil {
+					t.Fatalf("bob received %d of %d messages: %v", received, senders*perSender, err)
+				}
+				if msg.Type != model.MessageTypeChat {
+					continue
+				}
+				if want := fmt.Sprint(next[msg.From]); msg.Text != want {
+					t.Fatalf("bob received %q from %s, want %q", msg.Text, msg.From, want)
+				}
+				next[msg.From]++
+				received++
+			}
+		})
+	}
+}
+
+// TestBroadcastGoroutinesBounded floods a room of 200 with broadcasts from
+// several senders and checks that fan-out starts no goroutines of its own,
+// however many messages and recipients there are.
+func TestBroadcastGoroutinesBounded(t *testing.T) {
+	const listeners, senders, perSender = 200, 8, 50
+	for _, fair := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fair=%v", fair), func(t *testing.T) {
+			s := newTestService(t, WithFairDelivery(fair), WithRateLimit(rate.Inf, 1), WithRoomRateLimit(rate.Inf, 1), WithMaxClients(1000))
+			ctx := context.Background()
+			for i := range listeners {
+				join(t, s, fmt.Sprint("listener", i), "lobby")
+			}
+			tokens := make([]string, senders)
+			for i := range senders {
+				tokens[i] = join(t, s, fmt.Sprint("sender", i), "lobby")
+			}
+
+			before := runtime.NumGoroutine()
+			stop := make(chan struct{})
+			peak := make(chan int)
+			go func() {
+				most := 0
+				for {
+					select {
+					case <-stop:
+						peak <- most
+						return
+					default:
+					}
+					most = max(most, runtime.NumGoroutine())
+					time.Sleep(100 * time.Microsecond)
+				}
+			}()
+			var wg sync.WaitGroup
+			for i := range senders {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range perSender {
+						s.SendMessage(ctx, model.SendMessageRequest{From: fmt.Sprint("sender", i), Token: tokens[i], Message: "flood"})
+					}
+				}()
+			}
+			wg.Wait()
+			close(stop)
+
+			// The senders and the sampler are the only goroutines added.
+			if most, limit := <-peak, before+senders+1; most > limit {
+				t.Fatalf("%d goroutines during the flood, want at most %d", most, limit)
+			}
+		})
+	}
+}
+
+// BenchmarkBroadcast times one broadcast to a room of 500 listeners whose
+// buffers are already full.
+func BenchmarkBroadcast(b *testing.B) {
+	for _, fair := range []bool{false, true} {
+		b.Run(fmt.Sprintf("fair=%v", fair), func(b *testing.B) {
+			s := newTestService(b, WithFairDelivery(fair), WithRateLimit(rate.Inf, 1), WithRoomRateLimit(rate.Inf, 1), WithMaxClients(1000))
+			ctx := context.Background()
+			for i := range 500 {
+				join(b, s, fmt.Sprint("listener", i), "lobby")
+			}
+			alice := join(b, s, "alice", "lobby")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				if _, err := s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, Message: "hi"}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"chatbox/model"
+)
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("CHAT_IDLE_TIMEOUT", "90s")
+	t.Setenv("CHAT_BUFFER_SIZE", "32")
+	t.Setenv("CHAT_RATE_LIMIT", "2.5")
+	t.Setenv("CHAT_RATE_BURST", "7")
+	t.Setenv("CHAT_MAX_CLIENTS", "100")
+	t.Setenv("CHAT_MAX_MESSAGE_LEN", "5")
+	t.Setenv("CHAT_FAIR_DELIVERY", "true")
+	t.Setenv("CHAT_DELIVERY_POLICY", string(DeliveryBlock))
+	t.Setenv("CHAT_ADMIN_SECRET", "s3cret")
+
+	cfg := DefaultConfig()
+	if err := LoadEnv(&cfg, os.Getenv); err != nil {
+		t.Fatalf("LoadEnv: %v", err)
+	}
+	want := DefaultConfig()
+	want.IdleTimeout = 90 * time.Second
+	want.BufferSize = 32
+	want.RateLimit = rate.Limit(2.5)
+	want.RateBurst = 7
+	want.MaxClients = 100
+	want.MaxMessageLen = 5
+	want.FairDelivery = true
+	want.DeliveryPolicy = DeliveryBlock
+	want.AdminSecret = "s3cret"
+	got, wantMap := RedactedConfig(cfg), RedactedConfig(want)
+	for name, w := range wantMap {
+		if got[name] != w {
+			t.Errorf("%s = %v, want %v", name, got[name], w)
+		}
+	}
+	if cfg.AdminSecret != "s3cret" {
+		t.Errorf("AdminSecret = %q, want s3cret", cfg.AdminSecret)
+	}
+
+	// The loaded limits take effect in the service.
+	s := newTestService(t, func(c *Config) { *c = cfg })
+	token := join(t, s, "alice", "lobby")
+	_, err := s.SendMessage(context.Background(), model.SendMessageRequest{From: "alice", Token: token, Message: "too long"})
+	wantCode(t, err, "ERR_MESSAGE_TOO_LONG")
+}
+
+func TestLoadEnvUnsetLeavesDefaults(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := LoadEnv(&cfg, func(string) string { return "" }); err != nil {
+		t.Fatalf("LoadEnv: %v", err)
+	}
+	if cfg.IdleTimeout != DefaultConfig().IdleTimeout || cfg.BufferSize != DefaultConfig().BufferSize {
+		t.Fatalf("LoadEnv with nothing set changed the defaults: %+v", cfg)
+	}
+}
+
+func TestLoadEnvBadValues(t *testing.T) {
+	tests := map[string]string{
+		"CHAT_IDLE_TIMEOUT":  "ninety",
+		"CHAT_BUFFER_SIZE":   "lots",
+		"CHAT_RATE_LIMIT":    "fast",
+		"CHAT_FAIR_DELIVERY": "maybe",
+	}
+	for key, raw := range tests {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, raw)
+			cfg := DefaultConfig()
+			err := LoadEnv(&cfg, os.Getenv)
+			if err == nil {
+				t.Fatalf("LoadEnv accepted %s=%q", key, raw)
+			}
+			if !strings.HasPrefix(err.Error(), key+":") {
+				t.Errorf("error %q does not name %s", err, key)
+			}
+		})
+	}
+
+	// Values that parse but are out of range are left to Validate.
+	t.Setenv("CHAT_BUFFER_SIZE", "-1")
+	cfg := DefaultConfig()
+	if err := LoadEnv(&cfg, os.Getenv); err != nil {
+		t.Fatalf("LoadEnv: %v", err)
+	}
+	wantCode(t, cfg.Validate(), "ERR_INVALID_CONFIG")
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"sync"
+
+	"chatbox/model"
+)
+
+// eventBus fans chat events out to in-process subscribers. Publishing never
+// blocks: a subscriber whose buffer is full misses the event.
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[chan model.ChatEvent]struct{}
+	closed bool
+}
+
+// subscribe registers a subscriber with a buffer of size events.
+func (b *eventBus) subscribe(size int) (<-chan model.ChatEvent, func()) {
+	ch := make(chan model.ChatEvent, size)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subs == nil {
+		b.subs = make(map[chan model.ChatEvent]struct{})
+	}
+	b.subs[ch] = struct{}{}
+	return ch, func() { b.unsubscribe(ch) }
+}
+
+// unsubscribe removes ch and closes it. It is safe to call more than once.
+func (b *eventBus) unsubscribe(ch chan model.ChatEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+func (b *eventBus) publish(e model.ChatEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// close closes every subscriber's channel and turns later subscribers
+// away.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Subscribe returns a channel of the service's chat events and a function
+// that ends the subscription and closes the channel. The channel is also
+// closed when the service is. Events that arrive while the subscriber's
+// buffer of cfg.EventBufferSize is full are dropped, so a slow subscriber
+// misses events rather than holding up the service.
+func (s *chatService) Subscribe() (<-chan model.ChatEvent, func()) {
+	return s.events.subscribe(s.cfg.EventBufferSize)
+}
+
+// emit publishes an event of type typ about user, stamped with the time.
+func (s *chatService) emit(typ string, user, room string, e model.ChatEvent) {
+	e.Type = typ
+	e.User = user
+	e.Room = room
+	e.At = s.cfg.Clock.Now()
+	s.events.publish(e)
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"chatbox/model"
+)
+
+// fairQuantum is how many recipients a fair delivery worker serves from one
+// room's fan-out before moving on to the next room.
+const fairQuantum = 32
+
+// fairJob is one fan-out waiting for fair delivery. Its submitter waits on
+// done and then reads out.
+type fairJob struct {
+	ctx, fctx  context.Context
+	msg        model.Message
+	private    bool
+	recipients []*Client
+	out        fanout
+	done       chan struct{}
+}
+
+// fairQueue holds the fan-outs waiting in each room, oldest first, and the
+// rooms waiting for a worker in round-robin order. A room is in turns only
+// while it has jobs and no worker is serving it, so each room's messages are
+// delivered in order while rooms share the workers evenly.
+type fairQueue struct {
+	mu     sync.Mutex
+	ready  sync.Cond
+	jobs   map[string][]*fairJob
+	turns  []string
+	closed bool
+}
+
+func newFairQueue() *fairQueue {
+	q := &fairQueue{jobs: make(map[string][]*fairJob)}
+	q.ready.L = &q.mu
+	return q
+}
+
+// submit queues job behind the earlier fan-outs in room, reporting false
+// once the queue is closed.
+func (q *fairQueue) submit(room string, job *fairJob) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	if len(q.jobs[room]) == 0 {
+		q.turns = append(q.turns, room)
+		q.ready.Signal()
+	}
+	q.jobs[room] = append(q.jobs[room], job)
+	return true
+}
+
+// next waits for the room whose turn is next and returns its oldest job. It
+// returns false once the queue is closed and empty.
+func (q *fairQueue) next() (room string, job *fairJob, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.turns) == 0 {
+		if q.closed {
+			return "", nil, false
+		}
+		q.ready.Wait()
+	}
+	room, q.turns = q.turns[0], q.turns[1:]
+	return room, q.jobs[room][0], true
+}
+
+// yield ends a worker's turn at room, completing its oldest job if finished,
+// and puts the room back at the end of turns if it has more work.
+func (q *fairQueue) yield(room string, finished bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if finished {
+		close(q.jobs[room][0].done)
+		q.jobs[room] = q.jobs[room][1:]
+	}
+	if len(q.jobs[room]) == 0 {
+		delete(q.jobs, room)
+		return
+	}
+	q.turns = append(q.turns, room)
+	q.ready.Signal()
+}
+
+// close stops the queue accepting jobs. Workers finish what is queued and
+// then exit.
+func (q *fairQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.ready.Broadcast()
+}
+
+// startFairDelivery starts the workers that serve s.fair, one per CPU.
+func (s *chatService) startFairDelivery() {
+	s.fair = newFairQueue()
+	for range runtime.GOMAXPROCS(0) {
+		s.loops.Add(1)
+		go func() {
+			defer s.loops.Done()
+			for {
+				room, job, ok := s.fair.next()
+				if !ok {
+					return
+				}
+				s.fair.yield(room, s.serveFair(job))
+			}
+		}()
+	}
+}
+
+// serveFair delivers job to up to fairQuantum more recipients, reporting
+// whether the job is finished.
+func (s *chatService) serveFair(job *fairJob) bool {
+	n := min(fairQuantum, len(job.recipients))
+	if s.deliverEach(job.ctx, job.fctx, job.msg, job.private, job.recipients[:n], &job.out) {
+		return true
+	}
+	job.recipients = job.recipients[n:]
+	if len(job.recipients) == 0 {
+		job.out.stop(job.ctx, job.fctx)
+		return true
+	}
+	return false
+}
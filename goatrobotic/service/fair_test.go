@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"golang.org/x/time/rate"
+
+	"chatbox/model"
+)
+
+func TestFairQueueTakesTurns(t *testing.T) {
+	q := newFairQueue()
+	job := func() *fairJob { return &fairJob{done: make(chan struct{})} }
+	busy1, busy2, quiet := job(), job(), job()
+	q.submit("busy", busy1)
+	q.submit("busy", busy2)
+	q.submit("quiet", quiet)
+
+	next := func(wantRoom string, wantJob *fairJob) {
+		t.Helper()
+		room, j, ok := q.next()
+		if !ok || room != wantRoom || j != wantJob {
+			t.Fatalf("next = %q, %p, %v; want %q, %p", room, j, ok, wantRoom, wantJob)
+		}
+	}
+	// busy's first job is served in part, then quiet gets its turn before
+	// busy continues.
+	next("busy", busy1)
+	q.yield("busy", false)
+	next("quiet", quiet)
+	q.yield("quiet", true)
+	<-quiet.done
+	next("busy", busy1)
+	q.yield("busy", true)
+	next("busy", busy2)
+	q.yield("busy", true)
+	<-busy2.done
+
+	q.close()
+	if _, _, ok := q.next(); ok {
+		t.Fatal("next on a closed, empty queue should report false")
+	}
+	if q.submit("busy", job()) {
+		t.Fatal("submit to a closed queue should report false")
+	}
+}
+
+func TestFairDelivery(t *testing.T) {
+	s := newTestService(t, WithFairDelivery(true))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	join(t, s, "carol", "lobby")
+	receive(s, "bob", bob) // carol's join notice
+
+	for i := range 3 {
+		res, err := s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, Message: fmt.Sprint(i)})
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		if res.Delivered != 2 {
+			t.Fatalf("Delivered = %d, want 2", res.Delivered)
+		}
+	}
+	for i := range 3 {
+		msg, err := receive(s, "bob", bob)
+		if err != nil || msg.Text != fmt.Sprint(i) {
+			t.Fatalf("bob received %+v, %v; want %d", msg, err, i)
+		}
+	}
+}
+
+// BenchmarkQuietRoomLatency times a send in a two-user room while other
+// senders flood a room of 500.
+func BenchmarkQuietRoomLatency(b *testing.B) {
+	for _, fair := range []bool{false, true} {
+		b.Run(fmt.Sprintf("fair=%v", fair), func(b *testing.B) {
+			s := newTestService(b, WithFairDelivery(fair), WithRateLimit(rate.Inf, 1), WithMaxClients(1000))
+			ctx := context.Background()
+			for i := range 500 {
+				join(b, s, fmt.Sprint("busy", i), "busy")
+			}
+			quiet := join(b, s, "quiet", "quiet")
+			join(b, s, "listener", "quiet")
+
+			stop := make(chan struct{})
+			var flood sync.WaitGroup
+			for i := range 4 {
+				flood.Add(1)
+				go func() {
+					defer flood.Done()
+					id := fmt.Sprint("flooder", i)
+					token := join(b, s, id, "busy")
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						s.SendMessage(ctx, model.SendMessageRequest{From: id, Token: token, Message: "flood"})
+					}
+				}()
+			}
+
+			b.ResetTimer()
+			for range b.N {
+				if _, err := s.SendMessage(ctx, model.SendMessageRequest{From: "quiet", Token: quiet, Message: "hi"}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			close(stop)
+			flood.Wait()
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// MessageFields is what a Config.MessageFormat template is executed with:
+// the message ID, the sender's display name, the message text and when it
+// was sent.
+type MessageFields struct {
+	ID   string
+	From string
+	Text string
+	Time time.Time
+}
+
+// parseMessageFormat compiles format and executes it once, so that a
+// reference to an unknown field fails here rather than on the first send.
+func parseMessageFormat(format string) (*template.Template, error) {
+	tmpl, err := template.New("message").Parse(format)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, MessageFields{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// render builds the delivered text of a chat message, using cfg.MessageFormat
+// when set and "name: body" otherwise.
+func (s *chatService) render(id, name, body string, at time.Time) string {
+	if s.format == nil {
+		return name + senderSeparator + body
+	}
+	var b strings.Builder
+	if err := s.format.Execute(&b, MessageFields{ID: id, From: name, Text: body, Time: at}); err != nil {
+		return name + senderSeparator + body
+	}
+	return b.String()
+}
@@ -0,0 +1,369 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// Store keeps the messages broadcast in each room for scrollback. It is
+// called after fan-out, outside the service lock.
+type Store interface {
+	// Append records msg as the newest message in room.
+	Append(room string, msg model.Message)
+	// Recent returns up to limit of the newest messages in room, oldest
+	// first.
+	Recent(room string, limit int) []model.Message
+	// Usage returns the bytes of history held per room.
+	Usage() map[string]int
+}
+
+// Expirer is implemented by Stores that age out history, which the cleanup
+// loop asks them to do every interval.
+type Expirer interface {
+	Expire()
+}
+
+// memoryStore keeps the most recent messages broadcast in each room. Rooms
+// are bounded by the total size of their messages, so a room of long
+// messages cannot use more memory than one of short ones, and optionally by
+// their number and age.
+type memoryStore struct {
+	mu     sync.Mutex
+	limits HistoryLimits
+	rooms  map[string]*roomHistory
+}
+
+// HistoryLimits bound each room's history in a memory store. The oldest
+// messages are evicted once any limit is exceeded. A zero MaxCount or
+// MaxAge means no such limit; age is measured from each message's
+// timestamp by Clock, which defaults to SystemClock.
+type HistoryLimits struct {
+	MaxBytes int
+	MaxCount int
+	MaxAge   time.Duration
+	Clock    Clock
+}
+
+type roomHistory struct {
+	msgs  []model.Message
+	bytes int
+}
+
+// NewMemoryStore returns an in-process Store holding at most maxBytes of
+// messages per room; the oldest are evicted first.
+func NewMemoryStore(maxBytes int) Store {
+	return NewBoundedMemoryStore(HistoryLimits{MaxBytes: maxBytes})
+}
+
+// NewBoundedMemoryStore returns an in-process Store bounded by limits.
+func NewBoundedMemoryStore(limits HistoryLimits) Store {
+	if limits.Clock == nil {
+		limits.Clock = SystemClock
+	}
+	return &memoryStore{
+		limits: limits,
+		rooms:  make(map[string]*roomHistory),
+	}
+}
+
+// messageSize is the number of bytes msg is accounted for in history.
+func messageSize(msg model.Message) int {
+	n := len(msg.Text) + len(msg.Body)
+	if msg.Attachment != nil {
+		n += len(msg.Attachment.Data)
+	}
+	return n
+}
+
+// Append evicts the oldest messages until the room is back within its
+// limits.
+func (h *memoryStore) Append(room string, msg model.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rh, ok := h.rooms[room]
+	if !ok {
+		rh = &roomHistory{}
+		h.rooms[room] = rh
+	}
+	rh.msgs = append(rh.msgs, msg)
+	rh.bytes += messageSize(msg)
+	h.trim(rh, h.limits.Clock.Now())
+}
+
+// trim evicts rh's oldest messages while it exceeds any limit at now. The
+// caller must hold h.mu.
+func (h *memoryStore) trim(rh *roomHistory, now time.Time) {
+	l := h.limits
+	for len(rh.msgs) > 0 && (rh.bytes > l.MaxBytes ||
+		(l.MaxCount > 0 && len(rh.msgs) > l.MaxCount) ||
+		(l.MaxAge > 0 && now.Sub(rh.msgs[0].Timestamp) > l.MaxAge)) {
+		rh.bytes -= messageSize(rh.msgs[0])
+		rh.msgs[0] = model.Message{}
+		rh.msgs = rh.msgs[1:]
+	}
+}
+
+// Expire evicts messages older than MaxAge, dropping rooms left without
+// history.
+func (h *memoryStore) Expire() {
+	if h.limits.MaxAge <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.limits.Clock.Now()
+	for room, rh := range h.rooms {
+		h.trim(rh, now)
+		if len(rh.msgs) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+func (h *memoryStore) Recent(room string, limit int) []model.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rh, ok := h.rooms[room]
+	if !ok {
+		return nil
+	}
+	msgs := rh.msgs
+	if len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	return append([]model.Message{}, msgs...)
+}
+
+func (h *memoryStore) Usage() map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := make(map[string]int, len(h.rooms))
+	for room, rh := range h.rooms {
+		out[room] = rh.bytes
+	}
+	return out
+}
+
+func (h *memoryStore) DeleteRoom(room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.rooms, room)
+}
+
+func (h *memoryStore) Snapshot() map[string][]model.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := make(map[string][]model.Message, len(h.rooms))
+	for room, rh := range h.rooms {
+		out[room] = append([]model.Message{}, rh.msgs...)
+	}
+	return out
+}
+
+// Restore replaces the history with rooms, evicting as Append would any
+// room over its limits.
+func (h *memoryStore) Restore(rooms map[string][]model.Message) {
+	h.mu.Lock()
+	h.rooms = make(map[string]*roomHistory, len(rooms))
+	h.mu.Unlock()
+
+	for room, msgs := range rooms {
+		for _, msg := range msgs {
+			h.Append(room, msg)
+		}
+	}
+}
+
+// replay returns the first message stored in room after since, which is a
+// message ID or an RFC 3339 timestamp. A cursor older than the retained
+// history resumes from the oldest message kept.
+func (s *chatService) replay(room, since string) (model.Message, bool, error) {
+	after, err := parseCursor(since)
+	if err != nil {
+		return model.Message{}, false, err
+	}
+	msgs := s.cfg.Store.Recent(room, math.MaxInt)
+	// Messages relayed from other instances are stored as they arrive, not
+	// in ID order, so an ID that is still stored resumes from its place.
+	if i := indexOfID(msgs, since); i >= 0 {
+		if i+1 < len(msgs) {
+			return msgs[i+1], true, nil
+		}
+		return model.Message{}, false, nil
+	}
+	for _, msg := range msgs {
+		if after(msg) {
+			return msg, true, nil
+		}
+	}
+	return model.Message{}, false, nil
+}
+
+// indexOfID returns the index of the message with ID id in msgs, or -1.
+func indexOfID(msgs []model.Message, id string) int {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseCursor returns a predicate matching messages newer than cursor.
+// Messages without an ID, such as presence notices, are never matched by an
+// ID cursor.
+func parseCursor(cursor string) (func(model.Message) bool, error) {
+	if id, ok := parseMessageID(cursor); ok {
+		return func(msg model.Message) bool {
+			n, ok := parseMessageID(msg.ID)
+			return ok && id.before(n)
+		}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, cursor); err == nil {
+		return func(msg model.Message) bool {
+			return msg.Timestamp.After(t)
+		}, nil
+	}
+	return nil, errcom.NewCustomError("ERR_INVALID_CURSOR", errors.New("since must be a message ID or an RFC 3339 timestamp"))
+}
+
+// Page sizes for GetHistory.
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// GetHistory returns the newest req.Limit messages broadcast in req.Room,
+// oldest first. Direct messages are never recorded.
+func (s *chatService) GetHistory(ctx context.Context, req model.HistoryRequest) (*model.HistoryResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.Room == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_ROOM", errors.New("room is required"))
+	}
+	if req.Limit < 0 {
+		return nil, errcom.NewCustomError("ERR_INVALID_LIMIT", errors.New("limit must not be negative"))
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultHistoryLimit
+	}
+	if req.Limit > maxHistoryLimit {
+		req.Limit = maxHistoryLimit
+	}
+
+	if req.Cursor == "" {
+		msgs := s.cfg.Store.Recent(req.Room, req.Limit+1)
+		return historyPage(req.Room, msgs, req.Limit), nil
+	}
+	pos, err := decodeCursor(historyCursor, req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	before, ok := parseMessageID(pos)
+	if !ok {
+		return nil, errInvalidCursor()
+	}
+	// Messages are only appended, so those stored ahead of the cursor's are
+	// a stable prefix of the history however many arrive meanwhile. Once
+	// the cursor's message has been evicted, the page holds the messages
+	// with earlier IDs.
+	msgs := s.cfg.Store.Recent(req.Room, math.MaxInt)
+	if end := indexOfID(msgs, pos); end >= 0 {
+		return historyPage(req.Room, msgs[:end], req.Limit), nil
+	}
+	var older []model.Message
+	for _, msg := range msgs {
+		if id, ok := parseMessageID(msg.ID); ok && id.before(before) {
+			older = append(older, msg)
+		}
+	}
+	return historyPage(req.Room, older, req.Limit), nil
+}
+
+// historyPage returns the newest limit of msgs, with a cursor for the rest
+// when there are more.
+func historyPage(room string, msgs []model.Message, limit int) *model.HistoryResponse {
+	res := &model.HistoryResponse{Room: room}
+	if len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+		res.NextCursor = encodeCursor(historyCursor, msgs[0].ID)
+	}
+	res.Messages = make([]model.MessageResponse, 0, len(msgs))
+	for _, msg := range msgs {
+		res.Messages = append(res.Messages, toMessageResponse(msg))
+	}
+	return res
+}
+
+// ExportRoom returns every message retained in room's history, oldest first,
+// for audit. Rooms with no stored history are reported as not found.
+func (s *chatService) ExportRoom(ctx context.Context, room string) (*model.HistoryResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if room == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_ROOM", errors.New("room is required"))
+	}
+	if _, ok := s.cfg.Store.Usage()[room]; !ok {
+		return nil, errcom.NewCustomError("ERR_ROOM_NOT_FOUND", errors.New("room has no history"))
+	}
+	msgs := s.cfg.Store.Recent(room, math.MaxInt)
+	res := &model.HistoryResponse{Room: room, Messages: make([]model.MessageResponse, 0, len(msgs))}
+	for _, msg := range msgs {
+		res.Messages = append(res.Messages, toMessageResponse(msg))
+	}
+	return res, nil
+}
+
+// Search returns the newest req.Limit messages in req.Room's history that
+// match req, oldest first. It scans the whole history, which is fine for the
+// bounded memory store but would need an index for a large one.
+func (s *chatService) Search(ctx context.Context, req model.SearchRequest) (*model.HistoryResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.Room == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_ROOM", errors.New("room is required"))
+	}
+	if req.Query == "" && req.From == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("q or from is required"))
+	}
+	if req.Limit < 0 {
+		return nil, errcom.NewCustomError("ERR_INVALID_LIMIT", errors.New("limit must not be negative"))
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultHistoryLimit
+	}
+	if req.Limit > maxHistoryLimit {
+		req.Limit = maxHistoryLimit
+	}
+
+	query := strings.ToLower(req.Query)
+	msgs := s.cfg.Store.Recent(req.Room, math.MaxInt)
+	res := &model.HistoryResponse{Room: req.Room, Messages: []model.MessageResponse{}}
+	for i := len(msgs) - 1; i >= 0 && len(res.Messages) < req.Limit; i-- {
+		msg := msgs[i]
+		if req.From != "" && msg.From != s.key(req.From) {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(msg.Body), query) {
+			continue
+		}
+		res.Messages = append(res.Messages, toMessageResponse(msg))
+	}
+	slices.Reverse(res.Messages)
+	return res, nil
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"chatbox/model"
+)
+
+// dedupeKey returns the key req is deduplicated under, if any: its
+// ClientMsgID, or else its IdempotencyKey.
+func dedupeKey(req model.SendMessageRequest) string {
+	if req.ClientMsgID != "" {
+		return req.ClientMsgID
+	}
+	return req.IdempotencyKey
+}
+
+// idempotencyCache remembers the result of recent sends per idempotency key
+// so that a retried send is answered without being broadcast again. Sends
+// in flight are tracked too, so a retry racing the original waits for its
+// result instead of sending a second copy.
+type idempotencyCache struct {
+	mu       sync.Mutex
+	entries  map[string]idempotencyEntry
+	inflight map[string]chan struct{}
+}
+
+type idempotencyEntry struct {
+	res  model.SendMessageResponse
+	seen time.Time
+}
+
+// claim returns the stored result for key if it is younger than ttl at now.
+// Otherwise, if a send with key is in flight, it returns a channel closed
+// when that send finishes or is released. Failing both, it marks key in
+// flight for the caller, who must then call finish or release.
+func (c *idempotencyCache) claim(key string, ttl time.Duration, now time.Time) (*model.SendMessageResponse, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok && now.Sub(entry.seen) <= ttl {
+		res := entry.res
+		return &res, nil
+	}
+	if wait, ok := c.inflight[key]; ok {
+		return nil, wait
+	}
+	if c.inflight == nil {
+		c.inflight = make(map[string]chan struct{})
+	}
+	c.inflight[key] = make(chan struct{})
+	return nil, nil
+}
+
+// finish records res for the send claimed under key and wakes any retries
+// waiting on it.
+func (c *idempotencyCache) finish(key string, res model.SendMessageResponse, max int, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(key, res, max, now)
+	c.wake(key)
+}
+
+// release gives up a claim on key without recording a result, so that a
+// waiting retry sends the message itself. It is a no-op once the claim
+// has finished.
+func (c *idempotencyCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wake(key)
+}
+
+// wake ends the claim on key. The caller must hold c.mu.
+func (c *idempotencyCache) wake(key string) {
+	if wait, ok := c.inflight[key]; ok {
+		close(wait)
+		delete(c.inflight, key)
+	}
+}
+
+// store records res under key as seen at now, evicting the oldest entry once
+// max keys are held. The caller must hold c.mu.
+func (c *idempotencyCache) store(key string, res model.SendMessageResponse, max int, now time.Time) {
+	if c.entries == nil {
+		c.entries = make(map[string]idempotencyEntry)
+	}
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= max {
+		var oldestKey string
+		var oldest time.Time
+		for k, e := range c.entries {
+			if oldestKey == "" || e.seen.Before(oldest) {
+				oldestKey, oldest = k, e.seen
+			}
+		}
+		delete(c.entries, oldestKey)
+	}
+	c.entries[key] = idempotencyEntry{res: res, seen: now}
+}
+
+// sweep drops entries older than ttl at now.
+func (c *idempotencyCache) sweep(ttl time.Duration, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if now.Sub(e.seen) > ttl {
+			delete(c.entries, k)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chatbox/model"
+)
+
+func TestClientMsgIDDedupe(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	s := newTestService(t, WithClock(clock))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	receive(s, "alice", alice) // bob's join notice
+
+	send := model.SendMessageRequest{From: "alice", Token: alice, Message: "hi", ClientMsgID: "m1"}
+	first, err := s.SendMessage(ctx, send)
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	retry, err := s.SendMessage(ctx, send)
+	if err != nil {
+		t.Fatalf("retried SendMessage: %v", err)
+	}
+	if !retry.Duplicate || retry.MessageID != first.MessageID {
+		t.Fatalf("retry = %+v, want the original result marked duplicate", retry)
+	}
+	if msg, err := receive(s, "bob", bob); err != nil || msg.Text != "hi" {
+		t.Fatalf("bob received %+v, %v; want the message", msg, err)
+	}
+	if n := s.streams["bob"].buffered(); n != 0 {
+		t.Fatalf("bob has %d more messages queued, want no second copy", n)
+	}
+
+	// Past the window the ID may be reused.
+	clock.Advance(DefaultConfig().IdempotencyTTL + time.Second)
+	again, err := s.SendMessage(ctx, send)
+	if err != nil || again.Duplicate {
+		t.Fatalf("send after the window = %+v, %v; want a new message", again, err)
+	}
+}
+
+// TestKeyedSendDoesNotHoldLock checks that a keyed send waiting on a full
+// buffer neither stalls the service nor the sender's other keyed sends.
+func TestKeyedSendDoesNotHoldLock(t *testing.T) {
+	s := newTestService(t, WithDeliveryPolicy(DeliveryBlock, 2*time.Second), WithBufferSize(1))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	join(t, s, "carol", "lobby")
+
+	dm := model.SendMessageRequest{From: "alice", Token: alice, To: "bob", Message: "one"}
+	if _, err := s.SendMessage(ctx, dm); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	// bob's buffer is full, so this send waits for him to receive.
+	sent := make(chan error, 1)
+	go func() {
+		dm := dm
+		dm.Message, dm.ClientMsgID = "two", "m1"
+		_, err := s.SendMessage(ctx, dm)
+		sent <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	other := make(chan error, 1)
+	go func() {
+		_, err := s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, To: "carol", Message: "hey", ClientMsgID: "m2"})
+		other <- err
+	}()
+	select {
+	case err := <-other:
+		if err != nil {
+			t.Fatalf("second keyed SendMessage: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("keyed send blocked behind the sender's waiting delivery")
+	}
+
+	// The cleanup pass sweeps every sender's keys under the service lock.
+	swept := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		for _, client := range s.streams {
+			client.sent.sweep(time.Hour, s.cfg.Clock.Now())
+		}
+		s.mu.Unlock()
+		close(swept)
+	}()
+	select {
+	case <-swept:
+	case <-time.After(time.Second):
+		t.Fatal("sweeping keys blocked behind a waiting delivery")
+	}
+
+	for range 3 {
+		if _, err := s.GetMessage(ctx, model.MessageRequest{ID: "bob", Token: bob, Wait: "1s"}); err != nil {
+			t.Fatalf("bob: %v", err)
+		}
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("blocked SendMessage: %v", err)
+	}
+}
+
+// TestConcurrentDuplicateSend races two sends with one key: the message is
+// delivered once and both callers get its ID.
+func TestConcurrentDuplicateSend(t *testing.T) {
+	s := newTestService(t, WithDeliveryPolicy(DeliveryBlock, 2*time.Second), WithBufferSize(1))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	receive(s, "alice", alice) // bob's join notice
+
+	dm := model.SendMessageRequest{From: "alice", Token: alice, To: "bob", Message: "one"}
+	if _, err := s.SendMessage(ctx, dm); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	// bob's buffer is full, so the first keyed send waits while the retry
+	// arrives.
+	dm.Message, dm.ClientMsgID = "two", "m1"
+	type result struct {
+		res *model.SendMessageResponse
+		err error
+	}
+	results := make(chan result, 2)
+	for range 2 {
+		go func() {
+			res, err := s.SendMessage(ctx, dm)
+			results <- result{res, err}
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var got []string
+	for range 2 {
+		msg, err := s.GetMessage(ctx, model.MessageRequest{ID: "bob", Token: bob, Wait: "1s"})
+		if err != nil {
+			t.Fatalf("bob: %v", err)
+		}
+		got = append(got, msg.Text)
+	}
+	if got[0] != "one" || got[1] != "two" {
+		t.Fatalf("bob received %q, want [one two]", got)
+	}
+
+	var ids []string
+	duplicates := 0
+	for range 2 {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("SendMessage: %v", r.err)
+		}
+		ids = append(ids, r.res.MessageID)
+		if r.res.Duplicate {
+			duplicates++
+		}
+	}
+	if ids[0] == "" || ids[0] != ids[1] || duplicates != 1 {
+		t.Fatalf("results have IDs %q with %d duplicates, want one original ID and one duplicate", ids, duplicates)
+	}
+	if n := s.streams["bob"].buffered(); n != 0 {
+		t.Fatalf("bob has %d more messages queued, want no second copy", n)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+
+	errcom "chatbox/error"
+)
+
+type (
+	identityKey struct{}
+	tokenKey    struct{}
+)
+
+// WithIdentity returns a copy of ctx carrying the authenticated user ID.
+// Auth middleware calls this so the service can verify that callers only act
+// as themselves.
+func WithIdentity(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the authenticated user ID carried by ctx.
+func IdentityFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(identityKey{}).(string)
+	return id, ok
+}
+
+// checkIdentity rejects acting as id when ctx carries a different
+// authenticated principal. Unauthenticated contexts are let through.
+func (s *chatService) checkIdentity(ctx context.Context, id string) error {
+	principal, ok := IdentityFromContext(ctx)
+	if !ok || s.key(principal) == s.key(id) {
+		return nil
+	}
+	return errcom.NewCustomError("ERR_IDENTITY_MISMATCH", errors.New("request does not match the authenticated user"))
+}
+
+// Authenticate scans the connected users for the one holding token,
+// failing with ERR_UNAUTHORIZED when no session has it.
+func (s *chatService) Authenticate(ctx context.Context, token string) (string, error) {
+	if err := s.checkOpen(); err != nil {
+		return "", err
+	}
+	if token != "" {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		for _, client := range s.streams {
+			if client.hasToken(token) {
+				return client.ID, nil
+			}
+		}
+	}
+	return "", errcom.NewCustomError("ERR_UNAUTHORIZED", errors.New("missing or invalid session token"))
+}
+
+// WithToken returns a copy of ctx carrying the session token presented by
+// the caller, for requests that do not carry it themselves.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// TokenFromContext returns the session token carried by ctx.
+func TokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenKey{}).(string)
+	return token, ok
+}
+
+// newToken returns an unguessable session token.
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// hasToken reports whether token was issued to one of c's sessions.
+func (c *Client) hasToken(token string) bool {
+	return c.sessionByToken(token) != nil
+}
+
+// checkToken rejects acting as id unless token, or the token carried by ctx
+// when token is empty, is the one issued when id joined. Unknown IDs are let
+// through so that callers report their own not-found error.
+func (s *chatService) checkToken(ctx context.Context, id, token string) error {
+	if token == "" {
+		token, _ = TokenFromContext(ctx)
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(id)]
+	s.mu.RUnlock()
+
+	if exists && !client.hasToken(token) {
+		return errcom.NewCustomError("ERR_UNAUTHORIZED", errors.New("missing or invalid session token"))
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"chatbox/model"
+)
+
+func TestCheckIdentity(t *testing.T) {
+	s := newTestService(t)
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	ctx := WithIdentity(context.Background(), "alice")
+
+	if _, err := s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, Message: "hi"}); err != nil {
+		t.Fatalf("SendMessage as the authenticated user: %v", err)
+	}
+	// bob's own token does not help a caller authenticated as alice.
+	_, err := s.SendMessage(ctx, model.SendMessageRequest{From: "bob", Token: bob, Message: "hi"})
+	wantCode(t, err, "ERR_IDENTITY_MISMATCH")
+	_, err = s.Leave(ctx, model.LeaveRequest{ID: "bob", Token: bob})
+	wantCode(t, err, "ERR_IDENTITY_MISMATCH")
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newTestService(t)
+	alice := join(t, s, "alice", "lobby")
+	ctx := context.Background()
+
+	if id, err := s.Authenticate(ctx, alice); err != nil || id != "alice" {
+		t.Fatalf("Authenticate(alice's token) = %q, %v; want alice", id, err)
+	}
+	for _, token := range []string{"", "not-a-token"} {
+		_, err := s.Authenticate(ctx, token)
+		wantCode(t, err, "ERR_UNAUTHORIZED")
+	}
+	s.Leave(ctx, model.LeaveRequest{ID: "alice", Token: alice})
+	_, err := s.Authenticate(ctx, alice)
+	wantCode(t, err, "ERR_UNAUTHORIZED")
+}
+
+func TestCheckToken(t *testing.T) {
+	s := newTestService(t)
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		id    string
+		token string
+		code  string
+	}{
+		{name: "valid token", id: "alice", token: alice},
+		{name: "missing token", id: "alice", code: "ERR_UNAUTHORIZED"},
+		{name: "wrong token", id: "alice", token: "not-a-token", code: "ERR_UNAUTHORIZED"},
+		{name: "another user's token", id: "alice", token: bob, code: "ERR_UNAUTHORIZED"},
+		{name: "token from ctx", ctx: WithToken(context.Background(), alice), id: "alice"},
+		{name: "wrong token from ctx", ctx: WithToken(context.Background(), bob), id: "alice", code: "ERR_UNAUTHORIZED"},
+		{name: "request token wins over ctx", ctx: WithToken(context.Background(), bob), id: "alice", token: alice},
+		// An unknown ID has nothing to protect; callers report it as not
+		// found themselves.
+		{name: "unknown ID", id: "carol", token: alice},
+		{name: "unknown ID without token", id: "carol"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			wantCode(t, s.checkToken(ctx, tt.id, tt.token), tt.code)
+		})
+	}
+}
+
+// TestStartHeartbeatRequiresToken checks that knowing a user's ID is not
+// enough to keep their session alive with heartbeats.
+func TestStartHeartbeatRequiresToken(t *testing.T) {
+	s := newTestService(t)
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, token := range []string{"", "not-a-token", bob} {
+		wantCode(t, s.StartHeartbeat(ctx, "alice", token), "ERR_UNAUTHORIZED")
+	}
+	if err := s.StartHeartbeat(ctx, "alice", alice); err != nil {
+		t.Fatalf("StartHeartbeat with alice's token: %v", err)
+	}
+	if err := s.StartHeartbeat(WithToken(ctx, alice), "alice", ""); err != nil {
+		t.Fatalf("StartHeartbeat with alice's token in ctx: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// keyedLimiter rate limits by key, such as a client IP or a room. A bucket
+// that has refilled holds no state worth keeping, so sweep drops it.
+type keyedLimiter struct {
+	mu    sync.Mutex
+	byKey map[string]*rate.Limiter
+}
+
+// allow reports whether key may act at now under limit and burst. When it
+// may not, the returned limiter is the one that refused it.
+func (l *keyedLimiter) allow(key string, limit rate.Limit, burst int, now time.Time) (*rate.Limiter, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.byKey == nil {
+		l.byKey = make(map[string]*rate.Limiter)
+	}
+	limiter, ok := l.byKey[key]
+	if !ok {
+		limiter = rate.NewLimiter(limit, burst)
+		l.byKey[key] = limiter
+	} else if limiter.Limit() != limit {
+		// The limit for key has been changed since its bucket was made.
+		limiter.SetLimitAt(now, limit)
+	}
+	return limiter, limiter.AllowN(now, 1)
+}
+
+func (l *keyedLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, limiter := range l.byKey {
+		if limiter.TokensAt(now) >= float64(limiter.Burst()) {
+			delete(l.byKey, key)
+		}
+	}
+}
+
+// checkJoinRate rejects a join from ip once it exceeds cfg.JoinRateLimit.
+// Joins without an IP, such as those made in-process, are not limited.
+func (s *chatService) checkJoinRate(ip string) error {
+	if ip == "" || s.cfg.JoinRateLimit <= 0 {
+		return nil
+	}
+	now := s.cfg.Clock.Now()
+	if limiter, ok := s.joins.allow(ip, s.cfg.JoinRateLimit, s.cfg.JoinRateBurst, now); !ok {
+		s.stats.rateLimited.Add(1)
+		return errRateLimit(limiter, now, "too many joins")
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chatbox/model"
+)
+
+// ring is a fixed-capacity FIFO queue of messages.
+type ring struct {
+	buf  []model.Message
+	head int
+	n    int
+}
+
+func newRing(size int) ring {
+	return ring{buf: make([]model.Message, size)}
+}
+
+// push appends msg, reporting false if the ring is full.
+func (r *ring) push(msg model.Message) bool {
+	if r.n == len(r.buf) {
+		return false
+	}
+	r.buf[(r.head+r.n)%len(r.buf)] = msg
+	r.n++
+	return true
+}
+
+// pop removes the oldest message.
+func (r *ring) pop() (model.Message, bool) {
+	if r.n == 0 {
+		return model.Message{}, false
+	}
+	msg := r.buf[r.head]
+	r.buf[r.head] = model.Message{}
+	r.head = (r.head + 1) % len(r.buf)
+	r.n--
+	return msg, true
+}
+
+// appendTo appends the queued messages to msgs, oldest first, leaving them
+// queued.
+func (r *ring) appendTo(msgs []model.Message) []model.Message {
+	for i := 0; i < r.n; i++ {
+		msgs = append(msgs, r.buf[(r.head+i)%len(r.buf)])
+	}
+	return msgs
+}
+
+// signal wakes every goroutine waiting on it. The channel is made on the
+// first wait and closed by fire, so an unwatched signal costs nothing. The
+// owner's lock must be held for both.
+type signal struct {
+	ch chan struct{}
+}
+
+func (s *signal) wait() <-chan struct{} {
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+func (s *signal) fire() {
+	if s.ch != nil {
+		close(s.ch)
+		s.ch = nil
+	}
+}
+
+// mailbox holds a client's queued messages. Priority messages go in prio,
+// ahead of chat, so that presence notices are not lost when chat is full.
+// Once closed it accepts nothing more, but what is queued can still be
+// taken.
+type mailbox struct {
+	mu     sync.Mutex
+	prio   ring
+	chat   ring
+	closed bool
+	// seq is the sequence number of the last message queued or dropped.
+	seq uint64
+	// ready fires when a message is queued or the mailbox closes, and
+	// space when a message is taken or the mailbox closes.
+	ready signal
+	space signal
+	// bytes is the size of the queued messages, which is also counted in
+	// usage, shared by every mailbox of a service, until the mailbox
+	// closes.
+	bytes int
+	usage *atomic.Int64
+}
+
+func newMailbox(size, prioSize int, usage *atomic.Int64) *mailbox {
+	return &mailbox{prio: newRing(prioSize), chat: newRing(size), usage: usage}
+}
+
+// account adds delta to the size of the queued messages. The caller must
+// hold m.mu.
+func (m *mailbox) account(delta int) {
+	m.bytes += delta
+	if !m.closed && m.usage != nil {
+		m.usage.Add(int64(delta))
+	}
+}
+
+// put queues msg, in prio first when priority is set, waiting up to wait for
+// room when the mailbox is full or until ctx is done. A queued message is
+// given the next sequence number; a message that is not queued uses one up,
+// leaving a gap that tells the client it missed something.
+func (m *mailbox) put(ctx context.Context, msg model.Message, priority bool, wait time.Duration) delivery {
+	var timeout <-chan time.Time
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return streamClosed
+		}
+		msg.Seq = m.seq + 1
+		if (priority && m.prio.push(msg)) || m.chat.push(msg) {
+			m.seq++
+			m.account(messageSize(msg))
+			m.ready.fire()
+			m.mu.Unlock()
+			return delivered
+		}
+		if wait <= 0 {
+			m.seq++
+			m.mu.Unlock()
+			return dropped
+		}
+		space := m.space.wait()
+		m.mu.Unlock()
+
+		if timeout == nil {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-space:
+		case <-timeout:
+			m.skip()
+			return dropped
+		case <-ctx.Done():
+			m.skip()
+			return canceled
+		}
+	}
+}
+
+// force queues msg like a priority message without waiting. When the
+// mailbox is full the oldest chat message is discarded to make room, which
+// force reports as displaced.
+func (m *mailbox) force(msg model.Message) (d delivery, displaced bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return streamClosed, false
+	}
+	if m.prio.n == len(m.prio.buf) && m.chat.n == len(m.chat.buf) {
+		old, _ := m.chat.pop()
+		m.account(-messageSize(old))
+		displaced = true
+	}
+	msg.Seq = m.seq + 1
+	if !m.prio.push(msg) {
+		m.chat.push(msg)
+	}
+	m.seq++
+	m.account(messageSize(msg))
+	m.ready.fire()
+	return delivered, displaced
+}
+
+// skip uses up a sequence number for a message that was not queued.
+func (m *mailbox) skip() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+}
+
+// next removes the next message, priority ones first. When none is queued it
+// returns a channel that fires once one may be, or a nil channel once the
+// mailbox is closed.
+func (m *mailbox) next() (msg model.Message, ok bool, wake <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msg, ok = m.prio.pop(); !ok {
+		msg, ok = m.chat.pop()
+	}
+	if ok {
+		m.account(-messageSize(msg))
+		m.space.fire()
+		return msg, true, nil
+	}
+	if m.closed {
+		return msg, false, nil
+	}
+	return msg, false, m.ready.wait()
+}
+
+// arrival returns a channel that fires once a message is queued or the
+// mailbox closes. It has already fired if either is the case.
+func (m *mailbox) arrival() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || m.prio.n+m.chat.n > 0 {
+		return fired
+	}
+	return m.ready.wait()
+}
+
+// fired is a channel that has already been closed.
+var fired = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// take removes up to n queued messages, priority ones first, without
+// waiting.
+func (m *mailbox) take(n int) []model.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msgs := make([]model.Message, 0, min(n, m.prio.n+m.chat.n))
+	for len(msgs) < n {
+		msg, ok := m.prio.pop()
+		if !ok {
+			if msg, ok = m.chat.pop(); !ok {
+				break
+			}
+		}
+		m.account(-messageSize(msg))
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) > 0 {
+		m.space.fire()
+	}
+	return msgs
+}
+
+// peek returns the queued messages in the order next would take them,
+// leaving them queued; ok is false once the mailbox is closed.
+func (m *mailbox) peek() (msgs []model.Message, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, false
+	}
+	msgs = make([]model.Message, 0, m.prio.n+m.chat.n)
+	return m.chat.appendTo(m.prio.appendTo(msgs)), true
+}
+
+// len counts the queued messages.
+func (m *mailbox) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.prio.n + m.chat.n
+}
+
+// capacity is the most messages the mailbox can hold.
+func (m *mailbox) capacity() int {
+	return len(m.prio.buf) + len(m.chat.buf)
+}
+
+// close stops the mailbox accepting messages and wakes every waiter. What
+// is still queued no longer counts towards usage. It is safe to call more
+// than once.
+func (m *mailbox) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		if m.usage != nil {
+			m.usage.Add(-int64(m.bytes))
+		}
+		m.closed = true
+		m.ready.fire()
+		m.space.fire()
+	}
+}
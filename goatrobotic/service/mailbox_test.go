@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"chatbox/model"
+)
+
+func chat(body string) model.Message {
+	return model.Message{Type: model.MessageTypeChat, Body: body}
+}
+
+func TestMailboxOverflow(t *testing.T) {
+	m := newMailbox(2, 1, nil)
+	ctx := context.Background()
+	for _, body := range []string{"a", "b"} {
+		if d := m.put(ctx, chat(body), false, 0); d != delivered {
+			t.Fatalf("put %q = %v, want delivered", body, d)
+		}
+	}
+	if d := m.put(ctx, chat("c"), false, 0); d != dropped {
+		t.Fatalf("put into full mailbox = %v, want dropped", d)
+	}
+	// A priority message uses its own ring; once that is full too, a forced
+	// one displaces the oldest chat message, "a".
+	if d := m.put(ctx, model.Message{Type: model.MessageTypePresence, Body: "p"}, true, 0); d != delivered {
+		t.Fatalf("priority put = %v, want delivered", d)
+	}
+	if _, displaced := m.force(model.Message{Type: model.MessageTypeAnnouncement, Body: "x"}); !displaced {
+		t.Fatal("force into full mailbox did not displace")
+	}
+
+	var got []string
+	var seqs []uint64
+	for _, msg := range m.take(10) {
+		got = append(got, msg.Body)
+		seqs = append(seqs, msg.Seq)
+	}
+	if fmt.Sprint(got) != "[p b x]" {
+		t.Fatalf("queued = %v, want [p b x]", got)
+	}
+	// "c" was dropped, leaving a gap at 3.
+	if fmt.Sprint(seqs) != "[4 2 5]" {
+		t.Fatalf("sequence numbers = %v, want [4 2 5]", seqs)
+	}
+}
+
+func TestMailboxBlockingPut(t *testing.T) {
+	m := newMailbox(1, 1, nil)
+	ctx := context.Background()
+	m.put(ctx, chat("a"), false, 0)
+
+	done := make(chan delivery, 1)
+	go func() { done <- m.put(ctx, chat("b"), false, time.Second) }()
+	select {
+	case d := <-done:
+		t.Fatalf("put into full mailbox returned %v without waiting", d)
+	case <-time.After(20 * time.Millisecond):
+	}
+	m.take(1)
+	if d := <-done; d != delivered {
+		t.Fatalf("waiting put = %v, want delivered", d)
+	}
+
+	if d := m.put(ctx, chat("c"), false, 10*time.Millisecond); d != dropped {
+		t.Fatalf("put past its wait = %v, want dropped", d)
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if d := m.put(cctx, chat("d"), false, time.Second); d != canceled {
+		t.Fatalf("put with canceled ctx = %v, want canceled", d)
+	}
+}
+
+func TestMailboxBlockingNext(t *testing.T) {
+	m := newMailbox(1, 1, nil)
+	_, ok, wake := m.next()
+	if ok || wake == nil {
+		t.Fatal("next on an empty mailbox should return a wake channel")
+	}
+	go m.put(context.Background(), chat("a"), false, 0)
+	select {
+	case <-wake:
+	case <-time.After(time.Second):
+		t.Fatal("put did not wake the waiter")
+	}
+	if msg, ok, _ := m.next(); !ok || msg.Body != "a" {
+		t.Fatalf("next = %q, %v, want a", msg.Body, ok)
+	}
+
+	_, _, wake = m.next()
+	m.close()
+	<-wake
+	if _, ok, wake := m.next(); ok || wake != nil {
+		t.Fatal("next on a closed, empty mailbox should return a nil wake channel")
+	}
+	if d := m.put(context.Background(), chat("b"), false, 0); d != streamClosed {
+		t.Fatalf("put after close = %v, want streamClosed", d)
+	}
+}
+
+// TestMailboxConcurrent runs producers and consumers together; run it with
+// -race. Every message is taken exactly once and usage returns to zero.
+func TestMailboxConcurrent(t *testing.T) {
+	var usage atomic.Int64
+	m := newMailbox(8, 1, &usage)
+	const producers, each = 8, 200
+
+	var took atomic.Int64
+	var consumers sync.WaitGroup
+	stop := make(chan struct{})
+	for range 4 {
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			for {
+				_, ok, wake := m.next()
+				if ok {
+					took.Add(1)
+					continue
+				}
+				select {
+				case <-wake:
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for p := range producers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range each {
+				if d := m.put(context.Background(), chat(fmt.Sprint(p, i)), false, time.Second); d != delivered {
+					t.Errorf("put = %v, want delivered", d)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for m.len() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	consumers.Wait()
+
+	if n := took.Load(); n != producers*each {
+		t.Fatalf("took %d messages, want %d", n, producers*each)
+	}
+	if n := usage.Load(); n != 0 {
+		t.Fatalf("usage = %d after draining, want 0", n)
+	}
+}
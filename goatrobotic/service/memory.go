@@ -0,0 +1,20 @@
+package service
+
+import (
+	"errors"
+
+	errcom "chatbox/error"
+)
+
+// checkMemory applies cfg.PressurePolicy to a broadcast once the messages
+// buffered across all clients exceed cfg.MaxBufferedBytes, reporting whether
+// the broadcast should be shed.
+func (s *chatService) checkMemory() (shed bool, err error) {
+	if s.cfg.MaxBufferedBytes <= 0 || s.bufferedBytes.Load() < s.cfg.MaxBufferedBytes {
+		return false, nil
+	}
+	if s.cfg.PressurePolicy == PressureShed {
+		return true, nil
+	}
+	return false, errcom.NewCustomError("ERR_MEMORY_PRESSURE", errors.New("server is over its message buffer budget, try again shortly"))
+}
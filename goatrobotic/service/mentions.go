@@ -0,0 +1,33 @@
+package service
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// mentionPrefix starts a mention of a user in a message.
+const mentionPrefix = "@"
+
+// mentions returns the keys of the users mentioned in body, each once. A
+// mention runs from the prefix to the next space or punctuation other than
+// '_', '-' and '.', and a trailing '.' is not part of it.
+func (s *chatService) mentions(body string) []string {
+	var keys []string
+	for _, field := range strings.Split(body, mentionPrefix)[1:] {
+		end := strings.IndexFunc(field, func(r rune) bool {
+			return unicode.IsSpace(r) || (unicode.IsPunct(r) && r != '_' && r != '-' && r != '.')
+		})
+		if end >= 0 {
+			field = field[:end]
+		}
+		field = strings.TrimRight(field, ".")
+		if field == "" {
+			continue
+		}
+		if key := s.key(field); !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+
+	"chatbox/model"
+)
+
+// counters tracks service-wide totals. Fields are updated atomically so they
+// can be bumped without holding s.mu.
+type counters struct {
+	joins        atomic.Uint64
+	leaves       atomic.Uint64
+	messagesSent atomic.Uint64
+	dropped      atomic.Uint64
+	rateLimited  atomic.Uint64
+	redactions   atomic.Uint64
+
+	receiveWait histogram
+}
+
+// snapshot reads every counter, swapping each to zero when reset is set so
+// that increments racing with the read land in either this snapshot or the
+// next one, never in neither.
+func (c *counters) snapshot(reset bool) model.StatsSnapshot {
+	read := func(v *atomic.Uint64) uint64 {
+		if reset {
+			return v.Swap(0)
+		}
+		return v.Load()
+	}
+	return model.StatsSnapshot{
+		Joins:        read(&c.joins),
+		Leaves:       read(&c.leaves),
+		MessagesSent: read(&c.messagesSent),
+		Dropped:      read(&c.dropped),
+		RateLimited:  read(&c.rateLimited),
+		Redactions:   read(&c.redactions),
+		ReceiveWait:  c.receiveWait.snapshot(reset),
+	}
+}
+
+// receiveWaitBuckets are the upper bounds, in seconds, of the receive wait
+// histogram. The last covers the default ReceiveTimeout.
+var receiveWaitBuckets = [...]float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts durations into receiveWaitBuckets. Each observation is
+// also recorded in export, if set, for Prometheus; unlike the counts here,
+// export is never reset.
+type histogram struct {
+	mu     sync.Mutex
+	counts [len(receiveWaitBuckets)]uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+	export prometheus.Histogram
+}
+
+// observe records d, made in ctx, whose trace becomes its exemplar in
+// export.
+func (h *histogram) observe(ctx context.Context, d time.Duration) {
+	v := d.Seconds()
+	if h.export != nil {
+		observeWithTrace(ctx, h.export, v)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, le := range receiveWaitBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) snapshot(reset bool) model.HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snap := model.HistogramSnapshot{
+		Count:   h.count,
+		Sum:     h.sum,
+		Buckets: make([]model.Bucket, len(receiveWaitBuckets)),
+	}
+	var cumulative uint64
+	for i, le := range receiveWaitBuckets {
+		cumulative += h.counts[i]
+		snap.Buckets[i] = model.Bucket{UpperBound: le, Count: cumulative}
+	}
+	if reset {
+		h.counts, h.count, h.sum = [len(receiveWaitBuckets)]uint64{}, 0, 0
+	}
+	return snap
+}
+
+// observeWithTrace records v in h, with the trace ID of the span in ctx as
+// its exemplar if that span is sampled.
+func observeWithTrace(ctx context.Context, h prometheus.Histogram, v float64) {
+	sc := trace.SpanContextFromContext(ctx)
+	eo, ok := h.(prometheus.ExemplarObserver)
+	if !ok || !sc.IsSampled() {
+		h.Observe(v)
+		return
+	}
+	eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": sc.TraceID().String()})
+}
@@ -0,0 +1,40 @@
+package service
+
+// Moderator screens chat text before it is delivered. Check reports whether
+// text may be sent and the text to deliver in its place, which may differ
+// when the moderator filters rather than blocks.
+type Moderator interface {
+	Check(text string) (allowed bool, filtered string, err error)
+}
+
+// noopModerator allows every message unchanged.
+type noopModerator struct{}
+
+func (noopModerator) Check(text string) (bool, string, error) {
+	return true, text, nil
+}
+
+// NoopModerator is the default Moderator.
+var NoopModerator Moderator = noopModerator{}
+
+// wordListModerator blocks or masks messages containing banned words.
+type wordListModerator struct {
+	words *WordFilter
+	mask  bool
+}
+
+// NewWordListModerator returns a Moderator for the given banned words. With
+// mask set, matching words are masked; otherwise such messages are blocked.
+func NewWordListModerator(words []string, mask bool) Moderator {
+	return &wordListModerator{words: NewWordFilter(words...), mask: mask}
+}
+
+func (m *wordListModerator) Check(text string) (bool, string, error) {
+	if !m.words.Contains(text) {
+		return true, text, nil
+	}
+	if m.mask {
+		return true, m.words.Mask(text), nil
+	}
+	return false, "", nil
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Message IDs are a sequence number, qualified with the assigning
+// instance's name when several instances share one chat, as in "42" or
+// "42-9f86d081884c7d65". Each instance moves its sequence past every ID it
+// relays from the others, so IDs order messages as a Lamport clock does:
+// by sequence number, then by instance name.
+
+// messageID is a parsed message ID.
+type messageID struct {
+	seq      uint64
+	instance string
+}
+
+// newInstanceName returns a random name qualifying the message IDs this
+// instance assigns.
+func newInstanceName() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// nextMessageID assigns the next message ID.
+func (s *chatService) nextMessageID() string {
+	id := strconv.FormatUint(s.lastMessageID.Add(1), 10)
+	if s.instance != "" {
+		id += "-" + s.instance
+	}
+	return id
+}
+
+// observeMessageID moves the sequence past id, which was assigned elsewhere,
+// so that IDs assigned here afterwards sort after it.
+func (s *chatService) observeMessageID(id string) {
+	parsed, ok := parseMessageID(id)
+	if !ok {
+		return
+	}
+	for {
+		last := s.lastMessageID.Load()
+		if parsed.seq <= last || s.lastMessageID.CompareAndSwap(last, parsed.seq) {
+			return
+		}
+	}
+}
+
+// parseMessageID parses id, reporting false if it is not a message ID.
+func parseMessageID(id string) (messageID, bool) {
+	seq, instance, qualified := strings.Cut(id, "-")
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil || qualified && !isInstanceName(instance) {
+		return messageID{}, false
+	}
+	return messageID{seq: n, instance: instance}, true
+}
+
+// isInstanceName reports whether name could have come from newInstanceName,
+// which keeps timestamps such as 2024-05-01T12:00:00Z from passing as IDs.
+func isInstanceName(name string) bool {
+	if name == "" {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// before reports whether id was assigned before other.
+func (id messageID) before(other messageID) bool {
+	if id.seq != other.seq {
+		return id.seq < other.seq
+	}
+	return id.instance < other.instance
+}
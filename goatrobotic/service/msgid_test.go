@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"chatbox/model"
+)
+
+// sharedBackend stands in for a backend shared with other instances; their
+// messages are handed to the service's relay by the test.
+type sharedBackend struct{ memoryBackend }
+
+func TestParseMessageID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want messageID
+		ok   bool
+	}{
+		{id: "42", want: messageID{seq: 42}, ok: true},
+		{id: "42-9f86d081", want: messageID{seq: 42, instance: "9f86d081"}, ok: true},
+		{id: "42-"},
+		{id: "42-XYZ"},
+		{id: "2024-05-01T12:00:00Z"},
+		{id: ""},
+	}
+	for _, tt := range tests {
+		got, ok := parseMessageID(tt.id)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseMessageID(%q) = %+v, %v; want %+v, %v", tt.id, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestSharedMessageIDs relays messages from two other instances, one far
+// ahead in its sequence, and checks that the IDs assigned here are unique
+// and sort after everything already seen.
+func TestSharedMessageIDs(t *testing.T) {
+	s := newTestService(t, WithBackend(sharedBackend{}))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+
+	send := func() messageID {
+		t.Helper()
+		res, err := s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, Message: "hi"})
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		id, ok := parseMessageID(res.MessageID)
+		if !ok || id.instance != s.instance {
+			t.Fatalf("MessageID = %q, want one qualified with %q", res.MessageID, s.instance)
+		}
+		return id
+	}
+	first := send()
+	s.relay("lobby", model.Message{Type: model.MessageTypeChat, ID: "1-aa", Body: "from a", Timestamp: epoch})
+	s.relay("lobby", model.Message{Type: model.MessageTypeChat, ID: "40-bb", Body: "from b", Timestamp: epoch})
+	next := send()
+	if relayed, _ := parseMessageID("40-bb"); !relayed.before(next) || !first.before(next) {
+		t.Fatalf("ID %+v assigned after 40-bb does not sort after it and %+v", next, first)
+	}
+}
+
+// TestHistoryWithRelayedMessages stores a relayed message behind a newer
+// local one, as happens when a broadcast from another instance arrives
+// late, and checks that paging and resuming visit every message once.
+func TestHistoryWithRelayedMessages(t *testing.T) {
+	s := newTestService(t, WithBackend(sharedBackend{}))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	receive(s, "alice", alice) // bob's join notice
+
+	var ids []string
+	for range 3 {
+		res, err := s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, Message: "hi"})
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		ids = append(ids, res.MessageID)
+	}
+	s.relay("lobby", model.Message{Type: model.MessageTypeChat, ID: "2-aa", Body: "late", Timestamp: time.Now()})
+	ids = append(ids, "2-aa")
+
+	var paged []string
+	req := model.HistoryRequest{Room: "lobby", Limit: 1}
+	for {
+		res, err := s.GetHistory(ctx, req)
+		if err != nil {
+			t.Fatalf("GetHistory: %v", err)
+		}
+		for _, msg := range res.Messages {
+			paged = append([]string{msg.ID}, paged...)
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		req.Cursor = res.NextCursor
+	}
+	if strings.Join(paged, " ") != strings.Join(ids, " ") {
+		t.Fatalf("paged history = %v, want %v", paged, ids)
+	}
+
+	for i := range len(ids) - 1 {
+		msg, err := s.GetMessage(ctx, model.MessageRequest{ID: "bob", Token: bob, Since: ids[i], Wait: "10ms"})
+		if err != nil || msg.ID != ids[i+1] {
+			t.Fatalf("resuming after %s received %+v, %v; want %s", ids[i], msg, err, ids[i+1])
+		}
+	}
+}
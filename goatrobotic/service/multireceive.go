@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// ReceiveMulti takes every message buffered for each of req.IDs without
+// waiting, so a relay can serve many users with one poll. Unless req.Wait is
+// set, a request that finds nothing returns at once; otherwise it waits for
+// the first message to any of them and returns what has arrived by then.
+// Each ID's receive slot is held for the duration, as GetMessages would.
+func (s *chatService) ReceiveMulti(ctx context.Context, req model.MultiReceiveRequest) (*model.MultiReceiveResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if len(req.IDs) == 0 {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("ids are required"))
+	}
+	if len(req.IDs) > s.cfg.MaxBatchSize {
+		return nil, errcom.NewCustomError("ERR_BATCH_TOO_LARGE", fmt.Errorf("a receive may cover at most %d users", s.cfg.MaxBatchSize))
+	}
+	var wait time.Duration
+	if req.Wait != "" {
+		var err error
+		if wait, err = s.receiveWait(req.Wait); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &model.MultiReceiveResponse{
+		Messages: make(map[string][]model.MessageResponse),
+		NotFound: []string{},
+	}
+	receivers := make(map[string]*session)
+	seen := make(map[string]bool)
+	for _, id := range req.IDs {
+		if id == "" {
+			return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+		}
+		if seen[s.key(id)] {
+			continue
+		}
+		seen[s.key(id)] = true
+		if err := s.checkIdentity(ctx, id); err != nil {
+			return nil, err
+		}
+		if err := s.checkToken(ctx, id, req.Tokens[id]); err != nil {
+			return nil, err
+		}
+
+		s.mu.RLock()
+		client, exists := s.streams[s.key(id)]
+		s.mu.RUnlock()
+
+		if !exists {
+			res.NotFound = append(res.NotFound, id)
+			continue
+		}
+		sess, err := s.sessionFor(ctx, client, req.Tokens[id])
+		if err != nil {
+			return nil, err
+		}
+		if !sess.receiving.CompareAndSwap(false, true) {
+			res.Busy = append(res.Busy, id)
+			continue
+		}
+		defer sess.receiving.Store(false)
+		client.touch()
+		receivers[id] = sess
+	}
+
+	if s.takeBuffered(receivers, res) == 0 && wait > 0 && len(receivers) > 0 {
+		s.waiting.Add(1)
+		err := s.awaitArrival(ctx, receivers, wait)
+		s.waiting.Add(-1)
+		if err != nil {
+			return nil, err
+		}
+		s.takeBuffered(receivers, res)
+	}
+	return res, nil
+}
+
+// takeBuffered moves the unexpired messages buffered for each receiver into
+// res, returning how many it took.
+func (s *chatService) takeBuffered(receivers map[string]*session, res *model.MultiReceiveResponse) int {
+	taken := 0
+	for id, sess := range receivers {
+		for _, msg := range sess.box.take(sess.box.capacity()) {
+			if !s.expired(msg) {
+				res.Messages[id] = append(res.Messages[id], toMessageResponse(msg))
+				taken++
+			}
+		}
+	}
+	return taken
+}
+
+// awaitArrival waits up to wait for a message to be queued for any of
+// receivers, or for one of their streams to close.
+func (s *chatService) awaitArrival(ctx context.Context, receivers map[string]*session, wait time.Duration) error {
+	arrived := make(chan struct{}, 1)
+	done := make(chan struct{})
+	defer close(done)
+	for _, sess := range receivers {
+		go func(ch <-chan struct{}) {
+			select {
+			case <-ch:
+				select {
+				case arrived <- struct{}{}:
+				default:
+				}
+			case <-done:
+			}
+		}(sess.box.arrival())
+	}
+
+	select {
+	case <-arrived:
+		return nil
+	case <-s.cfg.Clock.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
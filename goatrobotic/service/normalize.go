@@ -0,0 +1,21 @@
+package service
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// key returns the map key for a user ID, without surrounding whitespace.
+// With cfg.NormalizeIDs set, IDs are also NFKC-normalized and case-folded so
+// visually identical IDs such as "Alice", "alice" and "ａｌｉｃｅ" resolve to
+// the same client.
+func (s *chatService) key(id string) string {
+	id = strings.TrimSpace(id)
+	if !s.cfg.NormalizeIDs {
+		return id
+	}
+	// A Caser is stateful, so each call gets its own.
+	return cases.Fold().String(norm.NFKC.String(id))
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+// Option adjusts the Config a service is built with by New or
+// NewChatService. The result is validated once all options are applied.
+type Option func(*Config)
+
+// WithRateLimit sets the per-client send rate and burst.
+func WithRateLimit(r rate.Limit, burst int) Option {
+	return func(cfg *Config) {
+		cfg.RateLimit = r
+		cfg.RateBurst = burst
+	}
+}
+
+// WithRoomRateLimit caps the combined broadcast rate and burst of each room.
+func WithRoomRateLimit(r rate.Limit, burst int) Option {
+	return func(cfg *Config) {
+		cfg.RoomRateLimit = r
+		cfg.RoomRateBurst = burst
+	}
+}
+
+// WithHistoryLimit keeps at most count messages, none older than maxAge, in
+// each room's history; zero leaves either unlimited.
+func WithHistoryLimit(count int, maxAge time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.HistoryLimit = count
+		cfg.HistoryMaxAge = maxAge
+	}
+}
+
+// WithIdleTimeout sets how long a client may go without activity before the
+// cleanup loop evicts it.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.IdleTimeout = d
+	}
+}
+
+// WithCleanupInterval sets how often the cleanup loop runs. The loop stops
+// when the service is closed.
+func WithCleanupInterval(d time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.CleanupInterval = d
+	}
+}
+
+// WithStore records room history in st instead of the default memory store.
+func WithStore(st Store) Option {
+	return func(cfg *Config) {
+		cfg.Store = st
+	}
+}
+
+// WithBackend shares users and broadcasts with other instances through b.
+func WithBackend(b Backend) Option {
+	return func(cfg *Config) {
+		cfg.Backend = b
+	}
+}
+
+// WithMaxMessageLen sets the longest message, in characters, a client may
+// send in rooms without their own limit.
+func WithMaxMessageLen(n int) Option {
+	return func(cfg *Config) {
+		cfg.MaxMessageLen = n
+	}
+}
+
+// WithDeliveryPolicy sets what happens to messages for clients whose buffer
+// is full. timeout only applies to DeliveryBlock.
+func WithDeliveryPolicy(p DeliveryPolicy, timeout time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.DeliveryPolicy = p
+		cfg.DeliveryTimeout = timeout
+	}
+}
+
+// WithMemoryBudget caps the total size of buffered messages at maxBytes,
+// applying policy to broadcasts beyond it.
+func WithMemoryBudget(maxBytes int64, policy PressurePolicy) Option {
+	return func(cfg *Config) {
+		cfg.MaxBufferedBytes = maxBytes
+		cfg.PressurePolicy = policy
+	}
+}
+
+// WithBufferSize sets the capacity of each client's message channel. Zero
+// keeps the default.
+func WithBufferSize(n int) Option {
+	return func(cfg *Config) {
+		cfg.BufferSize = n
+	}
+}
+
+// WithRequireReceivers makes room messages fail with ERR_NO_RECEIVERS when
+// no one else is in the room.
+func WithRequireReceivers(require bool) Option {
+	return func(cfg *Config) {
+		cfg.RequireReceivers = require
+	}
+}
+
+// WithDirectOnly rejects messages that do not name their recipients with
+// ERR_BROADCAST_DISABLED.
+func WithDirectOnly(directOnly bool) Option {
+	return func(cfg *Config) {
+		cfg.DirectOnly = directOnly
+	}
+}
+
+// WithFairDelivery shares message fan-out between rooms in turn; see
+// Config.FairDelivery.
+func WithFairDelivery(fair bool) Option {
+	return func(cfg *Config) {
+		cfg.FairDelivery = fair
+	}
+}
+
+// WithMaxClients caps the number of connected clients; zero means
+// unlimited.
+func WithMaxClients(n int) Option {
+	return func(cfg *Config) {
+		cfg.MaxClients = n
+	}
+}
+
+// WithMaxSessions lets each user ID hold up to n connections at once.
+func WithMaxSessions(n int) Option {
+	return func(cfg *Config) {
+		cfg.MaxSessions = n
+	}
+}
+
+// WithMaxRoomMembers caps the members of each room that has no limit of its
+// own; zero means unlimited.
+func WithMaxRoomMembers(n int) Option {
+	return func(cfg *Config) {
+		cfg.MaxRoomMembers = n
+	}
+}
+
+// WithModerator screens chat messages with m before they are delivered.
+func WithModerator(m Moderator) Option {
+	return func(cfg *Config) {
+		cfg.Moderator = m
+	}
+}
+
+// WithMessageFormat renders chat messages' delivered text with the
+// text/template format; see Config.MessageFormat.
+func WithMessageFormat(format string) Option {
+	return func(cfg *Config) {
+		cfg.MessageFormat = format
+	}
+}
+
+// WithMessageTTL discards messages that wait on a client's stream for longer
+// than ttl; zero disables expiry.
+func WithMessageTTL(ttl time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.MessageTTL = ttl
+	}
+}
+
+// WithDeadLetterSink reports every failed delivery to sink.
+func WithDeadLetterSink(sink DeadLetterSink) Option {
+	return func(cfg *Config) {
+		cfg.DeadLetterSink = sink
+	}
+}
+
+// WithClock makes the service read time from c, e.g. a FakeClock.
+func WithClock(c Clock) Option {
+	return func(cfg *Config) {
+		cfg.Clock = c
+	}
+}
+
+// WithTracer records spans for the service's calls with t.
+func WithTracer(t trace.Tracer) Option {
+	return func(cfg *Config) {
+		cfg.Tracer = t
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	errcom "chatbox/error"
+)
+
+func TestNewRejectsInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		field string
+	}{
+		{name: "zero buffer", opts: []Option{WithBufferSize(0)}, field: "buffer_size"},
+		{name: "negative buffer", opts: []Option{WithBufferSize(-1)}, field: "buffer_size"},
+		{name: "zero rate", opts: []Option{WithRateLimit(0, 5)}, field: "rate_limit"},
+		{name: "negative rate", opts: []Option{WithRateLimit(-1, 5)}, field: "rate_limit"},
+		{name: "zero burst", opts: []Option{WithRateLimit(1, 0)}, field: "rate_burst"},
+		{name: "negative idle timeout", opts: []Option{WithIdleTimeout(-time.Second)}, field: "idle_timeout"},
+		{name: "unknown delivery policy", opts: []Option{WithDeliveryPolicy("sometimes", time.Second)}, field: "delivery_policy"},
+		// Each option is valid alone; the last one breaks the combination.
+		{name: "valid then zero buffer", opts: []Option{WithBufferSize(8), WithRateLimit(rate.Inf, 1), WithBufferSize(0)}, field: "buffer_size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs, err := New(tt.opts...)
+			if err == nil {
+				cs.Close()
+				t.Fatal("New accepted the options")
+			}
+			wantCode(t, err, "ERR_INVALID_CONFIG")
+			if !strings.Contains(errcom.Message(err), tt.field) {
+				t.Errorf("error %q does not name %s", err, tt.field)
+			}
+
+			defer func() {
+				if r := recover(); r == nil {
+					t.Error("NewChatService did not panic")
+				} else if errcom.Code(r.(error)) != "ERR_INVALID_CONFIG" {
+					t.Errorf("NewChatService panicked with %v", r)
+				}
+			}()
+			NewChatService(tt.opts...)
+		})
+	}
+}
+
+func TestNewAcceptsDefaults(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("DefaultConfig invalid: %v", err)
+	}
+	NewChatService().Close()
+	cs, err := New(WithBufferSize(1), WithRateLimit(rate.Inf, 1))
+	if err != nil {
+		t.Fatalf("New with minimal limits: %v", err)
+	}
+	cs.Close()
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"chatbox/model"
+)
+
+// quarantine holds flagged messages awaiting moderator review, oldest first.
+type quarantine struct {
+	mu      sync.Mutex
+	nextID  uint64
+	pending []model.QuarantinedMessage
+}
+
+// hold queues msg for review, assigning its ID and now as its hold time. It
+// reports false when max messages are already pending.
+func (q *quarantine) hold(msg model.QuarantinedMessage, max int, now time.Time) (model.QuarantinedMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) >= max {
+		return model.QuarantinedMessage{}, false
+	}
+	q.nextID++
+	msg.ID = strconv.FormatUint(q.nextID, 10)
+	msg.HeldAt = now
+	q.pending = append(q.pending, msg)
+	return msg, true
+}
+
+// take removes and returns the pending message with the given ID.
+func (q *quarantine) take(id string) (model.QuarantinedMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, msg := range q.pending {
+		if msg.ID == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return msg, true
+		}
+	}
+	return model.QuarantinedMessage{}, false
+}
+
+func (q *quarantine) list() []model.QuarantinedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]model.QuarantinedMessage{}, q.pending...)
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	errcom "chatbox/error"
+)
+
+// sendQuota tracks a client's sends over a sliding window so that at most a
+// fixed number fall within any window.
+type sendQuota struct {
+	mu    sync.Mutex
+	sends []time.Time
+}
+
+// take records a send at now unless limit sends already fall within the
+// window before it. When it does not, wait is how long until one leaves the
+// window.
+func (q *sendQuota) take(now time.Time, limit int, window time.Duration) (wait time.Duration, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(q.sends) && !q.sends[i].After(cutoff) {
+		i++
+	}
+	q.sends = q.sends[i:]
+	if len(q.sends) >= limit {
+		return q.sends[0].Sub(cutoff), false
+	}
+	q.sends = append(q.sends, now)
+	return 0, true
+}
+
+// checkQuota charges a send to client against cfg.MessageQuota.
+func (s *chatService) checkQuota(client *Client) error {
+	if s.cfg.MessageQuota <= 0 {
+		return nil
+	}
+	wait, ok := client.quota.take(s.cfg.Clock.Now(), s.cfg.MessageQuota, s.cfg.QuotaWindow)
+	if ok {
+		return nil
+	}
+	return errcom.NewCustomErrorWithDetails("ERR_QUOTA_EXCEEDED", errors.New("message quota exceeded"), map[string]any{
+		"retry_after_ms": wait.Milliseconds(),
+		"quota":          s.cfg.MessageQuota,
+		"window_ms":      s.cfg.QuotaWindow.Milliseconds(),
+	})
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// receiptBook records which users acknowledged each chat message sent
+// through this instance. Messages are forgotten once they are older than
+// cfg.ReceiptTTL.
+type receiptBook struct {
+	mu   sync.Mutex
+	msgs map[string]*receipt
+}
+
+type receipt struct {
+	from  string
+	sent  time.Time
+	acked map[string]struct{}
+}
+
+func (b *receiptBook) track(id, from string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.msgs == nil {
+		b.msgs = make(map[string]*receipt)
+	}
+	b.msgs[id] = &receipt{from: from, sent: now, acked: make(map[string]struct{})}
+}
+
+// ack records that user consumed message id, reporting whether the message
+// is known.
+func (b *receiptBook) ack(id, user string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r, ok := b.msgs[id]
+	if ok {
+		r.acked[user] = struct{}{}
+	}
+	return ok
+}
+
+// get returns the sender of message id and the users that acknowledged it,
+// sorted.
+func (b *receiptBook) get(id string) (from string, acked []string, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r, ok := b.msgs[id]
+	if !ok {
+		return "", nil, false
+	}
+	acked = make([]string, 0, len(r.acked))
+	for user := range r.acked {
+		acked = append(acked, user)
+	}
+	sort.Strings(acked)
+	return r.from, acked, true
+}
+
+// forget drops message id, which was never delivered.
+func (b *receiptBook) forget(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.msgs, id)
+}
+
+// sweep forgets messages sent more than ttl before now.
+func (b *receiptBook) sweep(ttl time.Duration, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, r := range b.msgs {
+		if now.Sub(r.sent) > ttl {
+			delete(b.msgs, id)
+		}
+	}
+}
+
+// Ack records that req.ID consumed the message req.MessageID.
+func (s *chatService) Ack(ctx context.Context, req model.AckRequest) (*model.AckResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" || req.MessageID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("id and message_id are required"))
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, req.Token); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(req.ID)]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	if !s.receipts.ack(req.MessageID, client.ID) {
+		return nil, errcom.NewCustomError("ERR_MESSAGE_NOT_FOUND", errors.New("message unknown or its receipts have expired"))
+	}
+	return &model.AckResponse{Success: true, Message: "Message acknowledged"}, nil
+}
+
+// GetReceipts returns the users that acknowledged req.MessageID. Only its
+// sender may ask.
+func (s *chatService) GetReceipts(ctx context.Context, req model.ReceiptsRequest) (*model.ReceiptsResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" || req.MessageID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("id and message_id are required"))
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, req.Token); err != nil {
+		return nil, err
+	}
+
+	from, acked, ok := s.receipts.get(req.MessageID)
+	if !ok {
+		return nil, errcom.NewCustomError("ERR_MESSAGE_NOT_FOUND", errors.New("message unknown or its receipts have expired"))
+	}
+	if from != s.key(req.ID) {
+		return nil, errcom.NewCustomError("ERR_UNAUTHORIZED", errors.New("only the sender may read a message's receipts"))
+	}
+	return &model.ReceiptsResponse{MessageID: req.MessageID, AckedBy: acked}, nil
+}
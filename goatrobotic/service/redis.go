@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisChannel is the pub/sub channel every instance publishes room
+// broadcasts on.
+const redisChannel = "chat:broadcast"
+
+// redisBackend shares one chat between instances through Redis. User claims
+// are keys with a TTL that the owning instance keeps extending, so the IDs
+// of a crashed instance free up once the TTL passes.
+type redisBackend struct {
+	rdb      *redis.Client
+	instance string
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	owned map[string]struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// redisEnvelope is a room broadcast as published to Redis.
+type redisEnvelope struct {
+	Instance string        `json:"instance"`
+	Room     string        `json:"room"`
+	Msg      model.Message `json:"msg"`
+}
+
+// NewRedisBackend returns a Backend using rdb. Claims on user IDs expire
+// after ttl unless this instance is still running to renew them.
+func NewRedisBackend(rdb *redis.Client, ttl time.Duration) Backend {
+	id := make([]byte, 8)
+	rand.Read(id)
+	b := &redisBackend{
+		rdb:      rdb,
+		instance: hex.EncodeToString(id),
+		ttl:      ttl,
+		owned:    make(map[string]struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.renew()
+	return b
+}
+
+func userKey(id string) string {
+	return "chat:user:" + id
+}
+
+func (b *redisBackend) Register(ctx context.Context, id string) error {
+	ok, err := b.rdb.SetNX(ctx, userKey(id), b.instance, b.ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errcom.NewCustomError("ERR_ALREADY_JOINED", errors.New("user already joined"))
+	}
+	b.mu.Lock()
+	b.owned[id] = struct{}{}
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *redisBackend) Unregister(ctx context.Context, id string) error {
+	b.mu.Lock()
+	delete(b.owned, id)
+	b.mu.Unlock()
+	return b.rdb.Del(ctx, userKey(id)).Err()
+}
+
+// renew extends this instance's claims well before they expire.
+func (b *redisBackend) renew() {
+	ticker := time.NewTicker(b.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+		b.mu.Lock()
+		ids := make([]string, 0, len(b.owned))
+		for id := range b.owned {
+			ids = append(ids, id)
+		}
+		b.mu.Unlock()
+
+		pipe := b.rdb.Pipeline()
+		for _, id := range ids {
+			pipe.PExpire(context.Background(), userKey(id), b.ttl)
+		}
+		pipe.Exec(context.Background())
+	}
+}
+
+func (b *redisBackend) Publish(ctx context.Context, room string, msg model.Message) error {
+	data, err := json.Marshal(redisEnvelope{Instance: b.instance, Room: room, Msg: msg})
+	if err != nil {
+		return err
+	}
+	return b.rdb.Publish(ctx, redisChannel, data).Err()
+}
+
+// Subscribe skips the messages this instance published itself, which
+// local clients have already received.
+func (b *redisBackend) Subscribe(ctx context.Context, fn func(room string, msg model.Message)) error {
+	sub := b.rdb.Subscribe(ctx, redisChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var env redisEnvelope
+			if json.Unmarshal([]byte(m.Payload), &env) != nil || env.Instance == b.instance {
+				continue
+			}
+			fn(env.Room, env.Msg)
+		}
+	}
+}
+
+// Close stops renewing claims and releases those still held.
+func (b *redisBackend) Close() error {
+	b.once.Do(func() { close(b.done) })
+
+	b.mu.Lock()
+	keys := make([]string, 0, len(b.owned))
+	for id := range b.owned {
+		keys = append(keys, userKey(id))
+	}
+	b.owned = make(map[string]struct{})
+	b.mu.Unlock()
+
+	if len(keys) == 0 {
+		return nil
+	}
+	return b.rdb.Del(context.Background(), keys...).Err()
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// reportBook keeps the audit log of reported messages, oldest first, and the
+// report notices waiting for a moderator to come online in each room.
+type reportBook struct {
+	mu      sync.Mutex
+	nextID  uint64
+	log     []model.Report
+	pending map[string][]model.Message
+}
+
+// record assigns r an ID and appends it to the log, dropping the oldest
+// entries beyond max.
+func (b *reportBook) record(r model.Report, max int) model.Report {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	r.ID = strconv.FormatUint(b.nextID, 10)
+	b.log = append(b.log, r)
+	if len(b.log) > max {
+		b.log = append([]model.Report{}, b.log[len(b.log)-max:]...)
+	}
+	return r
+}
+
+func (b *reportBook) list() []model.Report {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]model.Report{}, b.log...)
+}
+
+// queue holds notice until a moderator of room is online, dropping the
+// oldest beyond max.
+func (b *reportBook) queue(room string, notice model.Message, max int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending == nil {
+		b.pending = make(map[string][]model.Message)
+	}
+	q := append(b.pending[room], notice)
+	if len(q) > max {
+		q = q[len(q)-max:]
+	}
+	b.pending[room] = q
+}
+
+// takePending removes and returns the notices queued for room.
+func (b *reportBook) takePending(room string) []model.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.pending[room]
+	delete(b.pending, room)
+	return q
+}
+
+// Report flags a message in the reporter's room to the room's moderators.
+// With none online the notice is queued for the next one.
+func (s *chatService) Report(ctx context.Context, req model.ReportRequest) (*model.ReportResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.From == "" || req.MessageID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("from and message_id are required"))
+	}
+	if err := checkLength("reason", req.Reason, s.cfg.MaxMessageLen); err != nil {
+		return nil, err
+	}
+	if err := s.checkIdentity(ctx, req.From); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.From, req.Token); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	reporter, exists := s.streams[s.key(req.From)]
+	var room, name string
+	if exists {
+		room, name = reporter.Room, reporter.Name
+	}
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_SENDER_NOT_FOUND", errors.New("sender not connected"))
+	}
+	var reported model.Message
+	found := false
+	for _, msg := range s.cfg.Store.Recent(room, math.MaxInt) {
+		if msg.ID == req.MessageID {
+			reported, found = msg, true
+			break
+		}
+	}
+	if !found {
+		return nil, errcom.NewCustomError("ERR_MESSAGE_NOT_FOUND", errors.New("no message with that ID in your room"))
+	}
+
+	report := s.reports.record(model.Report{
+		Room:       room,
+		From:       reporter.ID,
+		MessageID:  reported.ID,
+		Sender:     reported.From,
+		Text:       reported.Body,
+		Reason:     req.Reason,
+		ReportedAt: s.cfg.Clock.Now(),
+	}, s.cfg.ReportLogSize)
+	notice := model.Message{
+		Type:      model.MessageTypeReport,
+		Text:      "* " + name + " reported message " + reported.ID + " from " + reported.From + ": " + req.Reason,
+		System:    true,
+		Timestamp: s.cfg.Clock.Now(),
+	}
+
+	var moderators []*Client
+	s.mu.RLock()
+	for _, client := range s.streams {
+		if client.Room == room && client.Moderator && client != reporter {
+			moderators = append(moderators, client)
+		}
+	}
+	s.mu.RUnlock()
+	out := s.deliverTo(ctx, notice, room, true, moderators)
+
+	res := &model.ReportResponse{Success: true, Message: "Report sent to moderators", ReportID: report.ID}
+	if out.delivered+out.dropped == 0 {
+		s.reports.queue(room, notice, s.cfg.ReportLogSize)
+		res.Message = "Report queued until a moderator is online"
+		res.Queued = true
+	}
+	return res, nil
+}
+
+// SetModerator marks or unmarks a connected user as a moderator of their
+// room. A new moderator is sent the reports queued for the room.
+func (s *chatService) SetModerator(ctx context.Context, req model.ModeratorRequest) (*model.ModeratorResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+
+	s.mu.Lock()
+	client, exists := s.streams[s.key(req.ID)]
+	if !exists {
+		s.mu.Unlock()
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	client.Moderator = req.Moderator
+	room := client.Room
+	s.mu.Unlock()
+
+	res := &model.ModeratorResponse{Success: true, Message: "Moderator status updated"}
+	if req.Moderator {
+		for _, notice := range s.reports.takePending(room) {
+			if s.offer(ctx, client, notice) == delivered {
+				res.Reports++
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s *chatService) ListReports(ctx context.Context) []model.Report {
+	return s.reports.list()
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+
+	"golang.org/x/time/rate"
+)
+
+// roomLimits holds per-room overrides of global limits, keyed by room name.
+type roomLimits struct {
+	mu            sync.RWMutex
+	maxMessageLen map[string]int
+	maxMembers    map[string]int
+	maxRate       map[string]rate.Limit
+}
+
+// members counts the clients in room. The caller must hold s.mu.
+func (s *chatService) members(room string) int {
+	n := 0
+	for _, client := range s.streams {
+		if client.Room == room {
+			n++
+		}
+	}
+	return n
+}
+
+// participants counts the clients in room other than observers, as
+// reported in presence events. The caller must hold s.mu.
+func (s *chatService) participants(room string) int {
+	n := 0
+	for _, client := range s.streams {
+		if client.Room == room && !client.Observer {
+			n++
+		}
+	}
+	return n
+}
+
+// announce tells the rest of client's room about a change to client, unless
+// it is an observer. The caller must hold s.mu.
+func (s *chatService) announce(notice model.Message, client *Client) {
+	if !client.Observer {
+		s.broadcast(notice, client.Room, client.ID)
+	}
+}
+
+// rooms counts the rooms that have members. The caller must hold s.mu.
+func (s *chatService) rooms() int {
+	seen := make(map[string]struct{})
+	for _, client := range s.streams {
+		seen[client.Room] = struct{}{}
+	}
+	return len(seen)
+}
+
+// RoomDeleter is implemented by Stores that can drop a room's history, which
+// the cleanup loop does once a room has been empty for cfg.EmptyRoomTTL.
+type RoomDeleter interface {
+	DeleteRoom(room string)
+}
+
+// expireRooms deletes the history of rooms without members for
+// cfg.EmptyRoomTTL. emptySince records when each room was first seen empty.
+// The caller must hold s.mu so that no join can enter a room while its
+// history is deleted.
+func (s *chatService) expireRooms(emptySince map[string]time.Time, now time.Time) {
+	deleter, ok := s.cfg.Store.(RoomDeleter)
+	if !ok || s.cfg.EmptyRoomTTL <= 0 {
+		return
+	}
+	occupied := make(map[string]bool)
+	for _, client := range s.streams {
+		occupied[client.Room] = true
+	}
+	stored := s.cfg.Store.Usage()
+	for room := range emptySince {
+		if _, ok := stored[room]; !ok || occupied[room] {
+			delete(emptySince, room)
+		}
+	}
+	for room := range stored {
+		if occupied[room] {
+			continue
+		}
+		since, ok := emptySince[room]
+		if !ok {
+			emptySince[room] = now
+			continue
+		}
+		if now.Sub(since) >= s.cfg.EmptyRoomTTL {
+			deleter.DeleteRoom(room)
+			delete(emptySince, room)
+		}
+	}
+}
+
+// resolve finds the client registered as id. When room is set the client
+// must be in it, and ERR_ROOM_NOT_FOUND is returned if the room has no
+// members. The caller must hold s.mu.
+func (s *chatService) resolve(id, room string) (*Client, error) {
+	client, exists := s.streams[s.key(id)]
+	if room != "" && (!exists || client.Room != room) && s.members(room) == 0 {
+		return nil, errcom.NewCustomError("ERR_ROOM_NOT_FOUND", errors.New("room not found"))
+	}
+	if !exists || (room != "" && client.Room != room) {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	return client, nil
+}
+
+// maxMessageLen returns the message length limit in effect for room.
+func (s *chatService) maxMessageLen(room string) int {
+	s.limits.mu.RLock()
+	defer s.limits.mu.RUnlock()
+	if n, ok := s.limits.maxMessageLen[room]; ok {
+		return n
+	}
+	return s.cfg.MaxMessageLen
+}
+
+// maxMembers returns the member cap in effect for room; zero means
+// unlimited.
+func (s *chatService) maxMembers(room string) int {
+	s.limits.mu.RLock()
+	defer s.limits.mu.RUnlock()
+	if n, ok := s.limits.maxMembers[room]; ok {
+		return n
+	}
+	return s.cfg.MaxRoomMembers
+}
+
+// roomRate returns the broadcast rate limit in effect for room; zero means
+// unlimited.
+func (s *chatService) roomRate(room string) rate.Limit {
+	s.limits.mu.RLock()
+	defer s.limits.mu.RUnlock()
+	if r, ok := s.limits.maxRate[room]; ok {
+		return r
+	}
+	return s.cfg.RoomRateLimit
+}
+
+// checkRoomRate rejects a broadcast in room once the room as a whole exceeds
+// its rate limit, however much of their own budget the senders have left.
+func (s *chatService) checkRoomRate(room string) error {
+	limit := s.roomRate(room)
+	if limit <= 0 {
+		return nil
+	}
+	now := s.cfg.Clock.Now()
+	if limiter, ok := s.roomSends.allow(room, limit, s.cfg.RoomRateBurst, now); !ok {
+		s.stats.rateLimited.Add(1)
+		return errLimited("ERR_ROOM_RATE_LIMIT", limiter, now, "too many messages in this room")
+	}
+	return nil
+}
+
+// SetRoomLimits overrides the message length limit, member cap and
+// broadcast rate limit for a room. A limit of zero removes the override so
+// the room falls back to Config.MaxMessageLen, Config.MaxRoomMembers or
+// Config.RoomRateLimit.
+func (s *chatService) SetRoomLimits(ctx context.Context, req model.RoomLimitsRequest) (*model.RoomLimitsResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.Room == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_ROOM", errors.New("room is required"))
+	}
+	if err := checkLength("room", req.Room, s.cfg.MaxRoomNameLen); err != nil {
+		return nil, err
+	}
+	if req.MaxMessageLen < 0 {
+		return nil, errcom.NewCustomError("ERR_INVALID_LIMIT", errors.New("max_message_len must not be negative"))
+	}
+	if req.MaxMembers < 0 {
+		return nil, errcom.NewCustomError("ERR_INVALID_LIMIT", errors.New("max_members must not be negative"))
+	}
+	if req.MaxRate < 0 {
+		return nil, errcom.NewCustomError("ERR_INVALID_LIMIT", errors.New("max_rate must not be negative"))
+	}
+
+	s.limits.mu.Lock()
+	if req.MaxMessageLen == 0 {
+		delete(s.limits.maxMessageLen, req.Room)
+	} else {
+		s.limits.maxMessageLen[req.Room] = req.MaxMessageLen
+	}
+	if req.MaxMembers == 0 {
+		delete(s.limits.maxMembers, req.Room)
+	} else {
+		s.limits.maxMembers[req.Room] = req.MaxMembers
+	}
+	if req.MaxRate == 0 {
+		delete(s.limits.maxRate, req.Room)
+	} else {
+		s.limits.maxRate[req.Room] = rate.Limit(req.MaxRate)
+	}
+	s.limits.mu.Unlock()
+
+	return &model.RoomLimitsResponse{
+		Room:          req.Room,
+		MaxMessageLen: s.maxMessageLen(req.Room),
+		MaxMembers:    s.maxMembers(req.Room),
+		MaxRate:       float64(s.roomRate(req.Room)),
+	}, nil
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"chatbox/model"
+)
+
+// fillRooms joins one user into each of n rooms, named room00000 upwards,
+// with every tenth room in the "team" namespace instead.
+func fillRooms(t testing.TB, s ChatService, n int) {
+	t.Helper()
+	for i := range n {
+		room := fmt.Sprintf("room%05d", i)
+		if i%10 == 0 {
+			room = fmt.Sprintf("team%05d", i)
+		}
+		join(t, s, fmt.Sprint("user", i), room)
+	}
+}
+
+func TestListRoomsPages(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	fillRooms(t, s, 30)
+
+	var names []string
+	req := model.ListRoomsRequest{Limit: 4, Prefix: "room"}
+	for {
+		res, err := s.ListRooms(ctx, req)
+		if err != nil {
+			t.Fatalf("ListRooms: %v", err)
+		}
+		if res.Total != 27 {
+			t.Fatalf("Total = %d, want 27", res.Total)
+		}
+		for _, room := range res.Rooms {
+			names = append(names, room.Name)
+		}
+		if res.NextOffset == 0 {
+			break
+		}
+		req.Offset = res.NextOffset
+	}
+	if len(names) != 27 || names[0] != "room00001" || names[26] != "room00029" {
+		t.Fatalf("paged through %d rooms, %v; want room00001 to room00029 without team rooms", len(names), names)
+	}
+
+	_, err := s.ListRooms(ctx, model.ListRoomsRequest{Offset: -1})
+	wantCode(t, err, "ERR_INVALID_OFFSET")
+}
+
+func BenchmarkListRooms(b *testing.B) {
+	s := newTestService(b)
+	fillRooms(b, s, 20000)
+	ctx := context.Background()
+
+	cases := []struct {
+		name string
+		req  model.ListRoomsRequest
+	}{
+		{"first page", model.ListRoomsRequest{}},
+		{"deep page", model.ListRoomsRequest{Offset: 15000, Limit: 1000}},
+		{"prefix", model.ListRoomsRequest{Prefix: "team"}},
+		{"prefix deep page", model.ListRoomsRequest{Prefix: "team", Offset: 1500, Limit: 100}},
+		{"prefix no match", model.ListRoomsRequest{Prefix: "nothing"}},
+	}
+	for _, bc := range cases {
+		b.Run(bc.name, func(b *testing.B) {
+			for range b.N {
+				if _, err := s.ListRooms(ctx, bc.req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,179 +1,2131 @@
-// Package service provides an optimized concurrent chat service.
-package service
-
-import (
-	"context"
-	"errors"
-	"sync"
-	"time"
-
-	errcom "chatbox/error"
-	"chatbox/model"
-
-	"golang.org/x/time/rate"
-)
-
-type Client struct {
-	ID          string
-	Ch          chan string
-	LastSeen    time.Time
-	RateLimiter *rate.Limiter
-}
-
-type ChatService interface {
-	Join(ctx context.Context, req model.JoinRequest) (*model.JoinResponse, error)
-	SendMessage(ctx context.Context, req model.SendMessageRequest) (*model.SendMessageResponse, error)
-	Leave(ctx context.Context, req model.LeaveRequest) (*model.LeaveResponse, error)
-	GetMessage(ctx context.Context, req model.MessageRequest) (*model.MessageResponse, error)
-}
-
-type chatService struct {
-	mu      sync.RWMutex
-	streams map[string]*Client
-}
-
-func NewChatService() ChatService {
-	s := &chatService{
-		streams: make(map[string]*Client),
-	}
-	s.startCleanupLoop()
-	return s
-}
-
-// Background cleanup: remove users idle for 5 minutes
-func (s *chatService) startCleanupLoop() {
-	ticker := time.NewTicker(1 * time.Minute)
-	go func() {
-		for range ticker.C {
-			s.mu.Lock()
-			for id, client := range s.streams {
-				if time.Since(client.LastSeen) > 5*time.Minute {
-					close(client.Ch)
-					delete(s.streams, id)
-				}
-			}
-			s.mu.Unlock()
-		}
-	}()
-}
-
-func (s *chatService) Join(ctx context.Context, req model.JoinRequest) (*model.JoinResponse, error) {
-	if req.ID == "" {
-		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
-	}
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if _, exists := s.streams[req.ID]; exists {
-		return nil, errcom.NewCustomError("ERR_ALREADY_JOINED", errors.New("user already joined"))
-	}
-
-	s.streams[req.ID] = &Client{
-		ID:          req.ID,
-		Ch:          make(chan string, 10),
-		LastSeen:    time.Now(),
-		RateLimiter: rate.NewLimiter(1, 5),
-	}
-
-	return &model.JoinResponse{
-		Success: true,
-		Message: "User joined successfully",
-	}, nil
-}
-
-func (s *chatService) SendMessage(ctx context.Context, req model.SendMessageRequest) (*model.SendMessageResponse, error) {
-	if req.From == "" || req.Message == "" {
-		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("From and Message are required"))
-	}
-
-	if len(req.Message) > 500 {
-		return nil, errcom.NewCustomError("ERR_MESSAGE_TOO_LONG", errors.New("message must be under 500 characters"))
-	}
-
-	s.mu.RLock()
-	sender, exists := s.streams[req.From]
-	if !exists {
-		s.mu.RUnlock()
-		return nil, errcom.NewCustomError("ERR_SENDER_NOT_FOUND", errors.New("sender not connected"))
-	}
-	if !sender.RateLimiter.Allow() {
-		s.mu.RUnlock()
-		return nil, errcom.NewCustomError("ERR_RATE_LIMIT", errors.New("too many messages"))
-	}
-
-	message := req.From + ": " + req.Message
-	sentCount := 0
-	for id, client := range s.streams {
-		if id == req.From {
-			continue
-		}
-		go func(c *Client) {
-			select {
-			case c.Ch <- message:
-				// sent
-			default:
-				// drop if channel full
-			}
-		}(client)
-		sentCount++
-	}
-	s.mu.RUnlock()
-
-	if sentCount == 0 {
-		return nil, errcom.NewCustomError("ERR_NO_RECEIVERS", errors.New("no clients received the message"))
-	}
-
-	return &model.SendMessageResponse{
-		Success: true,
-		Message: "Message broadcasted to clients",
-	}, nil
-}
-
-func (s *chatService) Leave(ctx context.Context, req model.LeaveRequest) (*model.LeaveResponse, error) {
-	if req.ID == "" {
-		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
-	}
-
-	s.mu.Lock()
-	client, exists := s.streams[req.ID]
-	if !exists {
-		s.mu.Unlock()
-		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
-	}
-	delete(s.streams, req.ID)
-	s.mu.Unlock()
-
-	close(client.Ch)
-
-	return &model.LeaveResponse{
-		Success: true,
-		Message: "User disconnected successfully",
-	}, nil
-}
-
-func (s *chatService) GetMessage(ctx context.Context, req model.MessageRequest) (*model.MessageResponse, error) {
-	if req.ID == "" {
-		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
-	}
-
-	s.mu.RLock()
-	client, exists := s.streams[req.ID]
-	s.mu.RUnlock()
-
-	if !exists {
-		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
-	}
-
-	client.LastSeen = time.Now()
-
-	select {
-	case msg, ok := <-client.Ch:
-		if !ok {
-			return nil, errcom.NewCustomError("ERR_USER_DISCONNECTED", errors.New("user stream closed"))
-		}
-		return &model.MessageResponse{Message: msg}, nil
-	case <-time.After(10 * time.Second):
-		return nil, errcom.NewCustomError("ERR_NO_MESSAGES", errors.New("no messages received"))
-	}
-}
+// Package service provides an optimized concurrent chat service.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultRoom is the room clients join when they do not name one.
+const DefaultRoom = "general"
+
+type Client struct {
+	// ID is the key the client is registered under; Name is the ID as the
+	// client gave it, used when rendering messages.
+	ID          string
+	Name        string
+	Room        string
+	Attributes  map[string]string
+	RateLimiter *rate.Limiter
+	// Status is the client's presence status. autoAway is set when the
+	// cleanup loop, rather than the client, made it away.
+	Status   string
+	autoAway bool
+	// Moderator marks the client as a moderator of its room, who is sent
+	// the reports made there.
+	Moderator bool
+	// Observer marks a read-only client; see model.JoinRequest.Observer.
+	Observer bool
+
+	// sessions are the client's connections, each with its own token and
+	// mailbox; see Config.MaxSessions. sessionsMu guards the slice.
+	sessions   []*session
+	sessionsMu sync.Mutex
+	// clock is the service's clock.
+	clock Clock
+
+	// lastSeen is the UnixNano time of the client's last activity. It is
+	// written without s.mu held, so it must only be accessed atomically.
+	lastSeen atomic.Int64
+	// sent remembers the results of recent sends by idempotency key.
+	sent idempotencyCache
+	// quota counts recent sends against cfg.MessageQuota.
+	quota sendQuota
+	// dropped counts the messages dropped because box was full.
+	dropped atomic.Uint64
+	// dead holds messages dropped because box was full.
+	dead deadLetters
+	// blocked holds the senders whose messages the client does not want.
+	blocked blockSet
+	// lastTyping is the UnixNano time of the client's last typing event.
+	lastTyping atomic.Int64
+}
+
+type ChatService interface {
+	Join(ctx context.Context, req model.JoinRequest) (*model.JoinResponse, error)
+	SendMessage(ctx context.Context, req model.SendMessageRequest) (*model.SendMessageResponse, error)
+	// SendBatch sends several messages in order, reporting each outcome.
+	SendBatch(ctx context.Context, req model.SendBatchRequest) (*model.SendBatchResponse, error)
+	Leave(ctx context.Context, req model.LeaveRequest) (*model.LeaveResponse, error)
+	// Kick disconnects a user on a moderator's behalf.
+	Kick(ctx context.Context, req model.KickRequest) (*model.KickResponse, error)
+	// DisconnectAll disconnects every user, or every user in a room.
+	DisconnectAll(ctx context.Context, req model.DisconnectAllRequest) (*model.DisconnectAllResponse, error)
+	// Announce sends an operator's notice to every connected user.
+	Announce(ctx context.Context, req model.AnnounceRequest) (*model.AnnounceResponse, error)
+	// Ack and GetReceipts record and report who consumed a message.
+	Ack(ctx context.Context, req model.AckRequest) (*model.AckResponse, error)
+	GetReceipts(ctx context.Context, req model.ReceiptsRequest) (*model.ReceiptsResponse, error)
+	// Rename changes a user's display name.
+	Rename(ctx context.Context, req model.RenameRequest) (*model.RenameResponse, error)
+	// SetStatus changes a user's presence status.
+	SetStatus(ctx context.Context, req model.StatusRequest) (*model.StatusResponse, error)
+	// Ping keeps a user's session from going idle.
+	Ping(ctx context.Context, req model.PingRequest) (*model.PingResponse, error)
+	// Typing tells the rest of the room that a user is typing.
+	Typing(ctx context.Context, req model.TypingRequest) (*model.TypingResponse, error)
+	// Ban and Unban manage the IDs and IPs refused by Join.
+	Ban(ctx context.Context, req model.BanRequest) (*model.BanResponse, error)
+	Unban(ctx context.Context, req model.BanRequest) (*model.BanResponse, error)
+	GetMessage(ctx context.Context, req model.MessageRequest) (*model.MessageResponse, error)
+	GetMessages(ctx context.Context, req model.MessagesRequest) (*model.MessagesResponse, error)
+	// ReceiveMulti takes the messages buffered for several users at once.
+	ReceiveMulti(ctx context.Context, req model.MultiReceiveRequest) (*model.MultiReceiveResponse, error)
+	// Stream passes the client's messages to fn as they arrive, for
+	// long-lived transports. It returns when ctx is done, the client's
+	// stream closes, or fn fails.
+	Stream(ctx context.Context, id string, fn func(model.MessageResponse) error) error
+	// StartHeartbeat periodically pushes a heartbeat message to the client's
+	// stream until ctx is done or the client leaves. An empty token falls
+	// back to the one attached to ctx with WithToken.
+	StartHeartbeat(ctx context.Context, id, token string) error
+	// Authenticate returns the ID of the user a session token was issued
+	// to, for transports that accept the token as a credential.
+	Authenticate(ctx context.Context, token string) (string, error)
+	// EffectiveConfig returns the running configuration with secrets redacted.
+	EffectiveConfig(ctx context.Context) map[string]any
+	// SetDraining toggles draining mode, in which new joins are rejected
+	// while existing sessions keep working.
+	SetDraining(draining bool)
+	Draining() bool
+	GetUserRooms(ctx context.Context, id string) (*model.UserRoomsResponse, error)
+	GetUsers(ctx context.Context, req model.UsersRequest) (*model.UsersResponse, error)
+	GetHistory(ctx context.Context, req model.HistoryRequest) (*model.HistoryResponse, error)
+	// Search finds stored messages in a room by text and sender.
+	Search(ctx context.Context, req model.SearchRequest) (*model.HistoryResponse, error)
+	// ExportRoom returns a room's whole retained history.
+	ExportRoom(ctx context.Context, room string) (*model.HistoryResponse, error)
+	ListRooms(ctx context.Context, req model.ListRoomsRequest) (*model.ListRoomsResponse, error)
+	// SnapshotStats returns the current counters, atomically resetting them
+	// when reset is set so pollers can compute per-interval deltas.
+	SnapshotStats(reset bool) model.StatsSnapshot
+	// Stats returns the aggregate counters without resetting them or
+	// naming any room, so that they can be published.
+	Stats(ctx context.Context) model.ServiceStats
+	// ListQuarantine returns the messages awaiting moderator review.
+	ListQuarantine(ctx context.Context) []model.QuarantinedMessage
+	Moderate(ctx context.Context, req model.ModerateRequest) (*model.ModerateResponse, error)
+	// Report flags a message to the moderators of the reporter's room,
+	// SetModerator marks who they are and ListReports returns the audit
+	// log of reports.
+	Report(ctx context.Context, req model.ReportRequest) (*model.ReportResponse, error)
+	SetModerator(ctx context.Context, req model.ModeratorRequest) (*model.ModeratorResponse, error)
+	ListReports(ctx context.Context) []model.Report
+	SetRoomLimits(ctx context.Context, req model.RoomLimitsRequest) (*model.RoomLimitsResponse, error)
+	GetDeadLetters(ctx context.Context, id string) (*model.MessagesResponse, error)
+	// RegisterCommand adds a slash command available when
+	// Config.EnableCommands is set.
+	RegisterCommand(name string, fn CommandFunc)
+	// Subscribe streams join, leave, send and drop events to embedders
+	// until the returned cancel function is called or the service closes.
+	Subscribe() (<-chan model.ChatEvent, func())
+	Block(ctx context.Context, req model.BlockRequest) (*model.BlockResponse, error)
+	Unblock(ctx context.Context, req model.BlockRequest) (*model.BlockResponse, error)
+	SetAttributes(ctx context.Context, req model.AttributesRequest) (*model.AttributesResponse, error)
+	GetRateLimit(ctx context.Context, id string) (*model.RateLimitResponse, error)
+	// GetUnread counts the messages buffered for id without consuming them.
+	GetUnread(ctx context.Context, id string) (*model.UnreadResponse, error)
+	// Peek returns buffered messages without consuming them.
+	Peek(ctx context.Context, req model.PeekRequest) (*model.PeekResponse, error)
+	// Close stops background work and closes every client stream, so that
+	// blocked receives return ERR_SERVER_SHUTTING_DOWN. Calls made after
+	// Close fail with ERR_SERVICE_UNAVAILABLE.
+	Close() error
+	// Shutdown closes the service and waits until its background
+	// goroutines have exited or ctx is done.
+	Shutdown(ctx context.Context) error
+}
+
+type chatService struct {
+	mu       sync.RWMutex
+	streams  map[string]*Client
+	cfg      Config
+	stats    counters
+	words    *WordFilter
+	held     quarantine
+	limits   roomLimits
+	bans     banList
+	receipts receiptBook
+	joins    keyedLimiter
+	// roomSends limits broadcasts per room.
+	roomSends keyedLimiter
+	reports   reportBook
+	events    eventBus
+	// idPattern is cfg.UserIDPattern compiled, or nil when unset.
+	idPattern *regexp.Regexp
+	// format is cfg.MessageFormat compiled, or nil when unset.
+	format *template.Template
+
+	cmdMu    sync.RWMutex
+	commands map[string]CommandFunc
+
+	draining atomic.Bool
+	// waiting counts GetMessage calls currently blocked on a mailbox.
+	waiting atomic.Int64
+	// bufferedBytes is the size of the messages queued in every open
+	// mailbox, checked against cfg.MaxBufferedBytes.
+	bufferedBytes atomic.Int64
+
+	closed atomic.Bool
+	done   chan struct{}
+	// started is when the service was built, for its uptime.
+	started time.Time
+	// shared is set when the backend fans messages out to other
+	// instances, whose recipients are not counted locally.
+	shared bool
+
+	// lastMessageID is the sequence number of the most recent message ID
+	// assigned or relayed.
+	lastMessageID atomic.Uint64
+	// instance qualifies the message IDs assigned here when the chat is
+	// shared, and is empty otherwise.
+	instance string
+
+	// fair queues fan-outs for the fair delivery workers when
+	// cfg.FairDelivery is set.
+	fair *fairQueue
+
+	// loops tracks the cleanup, heartbeat and fair delivery goroutines.
+	loops sync.WaitGroup
+}
+
+// New builds a service from DefaultConfig adjusted by opts, failing with
+// ERR_INVALID_CONFIG if the result does not pass Config.Validate.
+func New(opts ...Option) (ChatService, error) {
+	cfg := DefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return NewChatServiceWithConfig(cfg), nil
+}
+
+// NewChatService is like New but panics if opts leave the configuration
+// invalid. With no options it cannot fail.
+func NewChatService(opts ...Option) ChatService {
+	s, err := New(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewChatServiceWithConfig builds a service using cfg. Unset fields fall back
+// to their DefaultConfig values.
+func NewChatServiceWithConfig(cfg Config) ChatService {
+	def := DefaultConfig()
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = def.IdleTimeout
+	}
+	if cfg.CleanupInterval <= 0 {
+		cfg.CleanupInterval = def.CleanupInterval
+	}
+	if cfg.ReceiveTimeout <= 0 {
+		cfg.ReceiveTimeout = def.ReceiveTimeout
+	}
+	if cfg.MaxReceiveWait <= 0 {
+		cfg.MaxReceiveWait = max(def.MaxReceiveWait, cfg.ReceiveTimeout)
+	}
+	if cfg.StaleAfter <= 0 {
+		cfg.StaleAfter = def.StaleAfter
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = def.HeartbeatInterval
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = def.BufferSize
+	}
+	if cfg.MaxMessageLen <= 0 {
+		cfg.MaxMessageLen = def.MaxMessageLen
+	}
+	if cfg.MaxAttachmentBytes <= 0 {
+		cfg.MaxAttachmentBytes = def.MaxAttachmentBytes
+	}
+	if cfg.MaxIDLen <= 0 {
+		cfg.MaxIDLen = def.MaxIDLen
+	}
+	if cfg.MaxRoomNameLen <= 0 {
+		cfg.MaxRoomNameLen = def.MaxRoomNameLen
+	}
+	if cfg.MaxNameLen <= 0 {
+		cfg.MaxNameLen = def.MaxNameLen
+	}
+	if cfg.MaxAttributes <= 0 {
+		cfg.MaxAttributes = def.MaxAttributes
+	}
+	if cfg.MaxAttributesBytes <= 0 {
+		cfg.MaxAttributesBytes = def.MaxAttributesBytes
+	}
+	if cfg.RateLimit <= 0 {
+		cfg.RateLimit = def.RateLimit
+	}
+	if cfg.RateBurst <= 0 {
+		cfg.RateBurst = def.RateBurst
+	}
+	if cfg.JoinRateLimit > 0 && cfg.JoinRateBurst <= 0 {
+		cfg.JoinRateBurst = def.JoinRateBurst
+	}
+	if cfg.RoomRateBurst <= 0 {
+		// Room overrides can enable the limit even when RoomRateLimit is
+		// zero, so the burst is always filled in.
+		cfg.RoomRateBurst = def.RoomRateBurst
+	}
+	if cfg.QuotaWindow <= 0 {
+		cfg.QuotaWindow = def.QuotaWindow
+	}
+	if cfg.MaxBatchSize <= 0 {
+		cfg.MaxBatchSize = def.MaxBatchSize
+	}
+	if cfg.MaxSessions <= 0 {
+		cfg.MaxSessions = def.MaxSessions
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		cfg.IdempotencyTTL = def.IdempotencyTTL
+	}
+	if cfg.IdempotencyKeys <= 0 {
+		cfg.IdempotencyKeys = def.IdempotencyKeys
+	}
+	if cfg.DeadLetterTTL <= 0 {
+		cfg.DeadLetterTTL = def.DeadLetterTTL
+	}
+	if cfg.EventBufferSize <= 0 {
+		cfg.EventBufferSize = def.EventBufferSize
+	}
+	if cfg.ReceiptTTL <= 0 {
+		cfg.ReceiptTTL = def.ReceiptTTL
+	}
+	if cfg.ReportLogSize <= 0 {
+		cfg.ReportLogSize = def.ReportLogSize
+	}
+	if cfg.QuarantineSize <= 0 {
+		cfg.QuarantineSize = def.QuarantineSize
+	}
+	if cfg.HistoryMaxBytes <= 0 {
+		cfg.HistoryMaxBytes = def.HistoryMaxBytes
+	}
+	if cfg.CommandPrefix == "" {
+		cfg.CommandPrefix = def.CommandPrefix
+	}
+	if cfg.ComplianceFilter == nil {
+		cfg.ComplianceFilter = NoopComplianceFilter
+	}
+	if cfg.Moderator == nil {
+		cfg.Moderator = NoopModerator
+	}
+	if cfg.DeadLetterSink == nil {
+		cfg.DeadLetterSink = NoopDeadLetterSink
+	}
+	if cfg.Tracer == nil {
+		cfg.Tracer = def.Tracer
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = SystemClock
+	}
+	if cfg.Store == nil {
+		cfg.Store = NewBoundedMemoryStore(HistoryLimits{
+			MaxBytes: cfg.HistoryMaxBytes,
+			MaxCount: cfg.HistoryLimit,
+			MaxAge:   cfg.HistoryMaxAge,
+			Clock:    cfg.Clock,
+		})
+	}
+	if cfg.Backend == nil {
+		cfg.Backend = NewMemoryBackend()
+	}
+	s := &chatService{
+		streams: make(map[string]*Client),
+		cfg:     cfg,
+		words:   NewWordFilter(cfg.BannedWords...),
+		limits:  roomLimits{maxMessageLen: make(map[string]int), maxMembers: make(map[string]int), maxRate: make(map[string]rate.Limit)},
+
+		commands: make(map[string]CommandFunc),
+		done:     make(chan struct{}),
+		started:  cfg.Clock.Now(),
+	}
+	s.stats.receiveWait.export = newReceiveWaitHistogram()
+	if cfg.UserIDPattern != "" {
+		// An invalid pattern is caught by Validate; unvalidated configs
+		// just go without one.
+		s.idPattern, _ = regexp.Compile("^(?:" + cfg.UserIDPattern + ")$")
+	}
+	if cfg.MessageFormat != "" {
+		// Likewise, an invalid format falls back to the default.
+		s.format, _ = parseMessageFormat(cfg.MessageFormat)
+	}
+	// A snapshot that cannot be restored has been moved aside; the
+	// service starts with empty history rather than not at all.
+	s.loadSnapshot()
+	s.registerBuiltinCommands()
+	s.shared = cfg.Backend != NewMemoryBackend()
+	if s.shared {
+		s.instance = newInstanceName()
+	}
+	s.startCleanupLoop()
+	s.startBackend()
+	if cfg.FairDelivery {
+		s.startFairDelivery()
+	}
+	return traced(s, cfg.Tracer)
+}
+
+// Background cleanup: remove users idle for longer than cfg.IdleTimeout
+func (s *chatService) startCleanupLoop() {
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		emptySince := make(map[string]time.Time)
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-s.cfg.Clock.After(s.cfg.CleanupInterval):
+			}
+			var evicted []string
+			now := s.cfg.Clock.Now()
+			s.mu.Lock()
+			for id, client := range s.streams {
+				if now.Sub(client.LastSeen()) > s.cfg.IdleTimeout {
+					client.closeStream()
+					delete(s.streams, id)
+					evicted = append(evicted, id)
+					s.emit(model.EventLeave, id, client.Room, model.ChatEvent{Reason: "idle"})
+					continue
+				}
+				client.sent.sweep(s.cfg.IdempotencyTTL, now)
+				s.updateAway(client, now)
+			}
+			s.expireRooms(emptySince, now)
+			s.mu.Unlock()
+			s.receipts.sweep(s.cfg.ReceiptTTL, now)
+			if expirer, ok := s.cfg.Store.(Expirer); ok {
+				expirer.Expire()
+			}
+			s.joins.sweep(now)
+			s.roomSends.sweep(now)
+			for _, id := range evicted {
+				s.cfg.Backend.Unregister(context.Background(), id)
+			}
+		}
+	}()
+}
+
+// recipientsOf looks up the connected users among ids, each once, and
+// returns the IDs that are not connected. The caller must hold s.mu.
+func (s *chatService) recipientsOf(ids []string) (found []*Client, notFound []string) {
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		key := s.key(id)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if client, ok := s.streams[key]; ok {
+			found = append(found, client)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+	return found, notFound
+}
+
+// audience returns the clients in room other than the one with ID except.
+// The caller must hold s.mu.
+func (s *chatService) audience(room, except string) []*Client {
+	var clients []*Client
+	for id, client := range s.streams {
+		if id != except && client.Room == room {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// deliverTo offers msg, sent in room, to recipients, stopping early once ctx
+// is done. A private message is counted for every recipient; a room message
+// is sent to observers but not counted for them. With cfg.FairDelivery the
+// work is queued behind room's earlier fan-outs and shared out with other
+// rooms'. The caller must not hold s.mu, as a DeliveryBlock offer waits for
+// room in a slow recipient's buffer.
+func (s *chatService) deliverTo(ctx context.Context, msg model.Message, room string, private bool, recipients []*Client) fanout {
+	fctx, cancel := s.fanoutContext(ctx)
+	defer cancel()
+	if s.fair != nil {
+		job := &fairJob{ctx: ctx, fctx: fctx, msg: msg, private: private, recipients: recipients, done: make(chan struct{})}
+		if s.fair.submit(room, job) {
+			<-job.done
+			return job.out
+		}
+	}
+	var out fanout
+	if !s.deliverEach(ctx, fctx, msg, private, recipients, &out) {
+		out.stop(ctx, fctx)
+	}
+	return out
+}
+
+// deliverEach offers msg to recipients in turn, adding the outcomes to out,
+// and reports whether it stopped early because fctx was done.
+func (s *chatService) deliverEach(ctx, fctx context.Context, msg model.Message, private bool, recipients []*Client, out *fanout) bool {
+	for _, client := range recipients {
+		if out.stop(ctx, fctx) {
+			return true
+		}
+		if d := s.offer(fctx, client, msg); private || !client.Observer {
+			out.add(d)
+		}
+	}
+	return false
+}
+
+// fanoutContext bounds ctx by cfg.MaxFanoutDuration, if set.
+func (s *chatService) fanoutContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.cfg.MaxFanoutDuration > 0 {
+		return context.WithTimeout(ctx, s.cfg.MaxFanoutDuration)
+	}
+	return context.WithCancel(ctx)
+}
+
+// overloaded reports whether more of out's recipients than
+// cfg.OverloadThreshold dropped the message.
+func (s *chatService) overloaded(out fanout) bool {
+	total := out.delivered + out.dropped
+	return s.cfg.OverloadThreshold > 0 && total > 0 &&
+		float64(out.dropped)/float64(total) > s.cfg.OverloadThreshold
+}
+
+// fanout tallies the outcome of offering a message to its recipients.
+type fanout struct {
+	delivered int
+	dropped   int
+	timedOut  bool
+	canceled  bool
+}
+
+// stop reports whether fctx, derived from the caller's ctx by
+// fanoutContext, is done, recording whether the caller canceled or the
+// fan-out budget ran out.
+func (f *fanout) stop(ctx, fctx context.Context) bool {
+	if fctx.Err() == nil {
+		return false
+	}
+	if ctx.Err() != nil {
+		f.canceled = true
+	} else {
+		f.timedOut = true
+	}
+	return true
+}
+
+func (f *fanout) add(d delivery) {
+	switch d {
+	case delivered:
+		f.delivered++
+	case dropped:
+		f.dropped++
+	}
+}
+
+// broadcast queues a notice for every client in room except the one with ID
+// except. The caller holds s.mu, so a full buffer is never waited on, even
+// under DeliveryBlock. If cfg.MaxFanoutDuration elapses first, the remaining
+// recipients are skipped and timedOut is set. Observers are sent msg but not
+// counted.
+func (s *chatService) broadcast(msg model.Message, room, except string) (out fanout) {
+	ctx := context.Background()
+	fctx, cancel := s.fanoutContext(ctx)
+	defer cancel()
+	for id, client := range s.streams {
+		if id == except || client.Room != room {
+			continue
+		}
+		if out.stop(ctx, fctx) {
+			return out
+		}
+		if d := s.offerWithin(fctx, client, msg, 0); !client.Observer {
+			out.add(d)
+		}
+	}
+	out.stop(ctx, fctx)
+	return out
+}
+
+// offer queues msg for client, applying cfg.DeliveryPolicy if the buffer is
+// full; a DeliveryBlock wait ends early once ctx is done. The caller must not
+// hold s.mu.
+func (s *chatService) offer(ctx context.Context, client *Client, msg model.Message) delivery {
+	var wait time.Duration
+	if s.cfg.DeliveryPolicy == DeliveryBlock {
+		wait = s.cfg.DeliveryTimeout
+	}
+	return s.offerWithin(ctx, client, msg, wait)
+}
+
+// offerWithin is offer, waiting at most wait for room in a full buffer.
+// Messages from a sender the client has blocked are not offered.
+func (s *chatService) offerWithin(ctx context.Context, client *Client, msg model.Message, wait time.Duration) delivery {
+	if msg.From != "" && client.blocked.has(msg.From) {
+		return senderBlocked
+	}
+	msg.Mentioned = slices.Contains(msg.Mentions, client.ID)
+	d := client.deliver(ctx, msg, wait)
+	if d == streamClosed {
+		s.deadLetter(client, msg, model.DeadLetterClientGone)
+	}
+	if d != dropped {
+		return d
+	}
+	s.deadLetter(client, msg, model.DeadLetterBufferFull)
+	s.stats.dropped.Add(1)
+	client.dropped.Add(1)
+	if s.cfg.DeliveryPolicy == DeliveryDisconnect {
+		client.closeStream()
+		// The caller may hold s.mu, so the client is removed once it is
+		// released.
+		go s.evict(client)
+		return d
+	}
+	if s.cfg.DeadLetterSize > 0 {
+		client.dead.push(msg, s.cfg.DeadLetterSize, s.cfg.Clock.Now())
+	}
+	return d
+}
+
+// deadLetter reports to cfg.DeadLetterSink and subscribers that msg could
+// not be delivered to client.
+func (s *chatService) deadLetter(client *Client, msg model.Message, reason string) {
+	s.cfg.DeadLetterSink.Record(model.DeadLetter{
+		MessageID:   msg.ID,
+		RecipientID: client.ID,
+		Reason:      reason,
+		At:          s.cfg.Clock.Now(),
+		Message:     msg,
+	})
+	s.emit(model.EventDrop, client.ID, client.Room, model.ChatEvent{MessageID: msg.ID, Reason: reason})
+}
+
+// evict removes a client whose stream was closed for falling behind, unless
+// it has already left or been replaced.
+func (s *chatService) evict(client *Client) {
+	s.mu.Lock()
+	if s.streams[client.ID] != client {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.streams, client.ID)
+	s.mu.Unlock()
+	s.cfg.Backend.Unregister(context.Background(), client.ID)
+	s.emit(model.EventLeave, client.ID, client.Room, model.ChatEvent{Reason: "evicted"})
+}
+
+// presenceMessage builds the event announcing that c joined or left, with
+// count being the number of participants after the change.
+func (s *chatService) presenceMessage(action string, c *Client, count int) model.Message {
+	verb := "joined"
+	switch action {
+	case model.PresenceLeave:
+		verb = "left"
+	case model.PresenceKick:
+		verb = "was removed"
+	}
+	return model.Message{
+		Type:      model.MessageTypePresence,
+		Text:      "* " + c.Name + " " + verb,
+		System:    true,
+		Timestamp: s.cfg.Clock.Now(),
+		Event: &model.PresenceEvent{
+			Type:       model.MessageTypePresence,
+			Action:     action,
+			ID:         c.ID,
+			Count:      count,
+			Attributes: c.Attributes,
+			Status:     c.Status,
+		},
+	}
+}
+
+// chatMessage builds the payload for body sent by the user with ID from,
+// rendered under their display name, and assigns it the next message ID,
+// under which its receipts are tracked.
+func (s *chatService) chatMessage(from, name, body string) model.Message {
+	id := s.nextMessageID()
+	s.receipts.track(id, from, s.cfg.Clock.Now())
+	now := s.cfg.Clock.Now()
+	return model.Message{
+		Type:      model.MessageTypeChat,
+		Text:      s.render(id, name, body, now),
+		ID:        id,
+		From:      from,
+		Body:      body,
+		Timestamp: now,
+		Mentions:  s.mentions(body),
+	}
+}
+
+func (s *chatService) Join(ctx context.Context, req model.JoinRequest) (*model.JoinResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	req.ID = strings.TrimSpace(req.ID)
+	if req.ID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+	if err := checkLength("id", req.ID, s.cfg.MaxIDLen); err != nil {
+		return nil, err
+	}
+	if err := checkUserID(req.ID); err != nil {
+		return nil, err
+	}
+	if err := checkIDPattern(req.ID, s.idPattern); err != nil {
+		return nil, err
+	}
+	if s.cfg.FilterNames {
+		if err := checkName("id", req.ID, s.words); err != nil {
+			return nil, err
+		}
+	}
+	room := req.Room
+	if room == "" {
+		room = DefaultRoom
+	}
+	if err := checkLength("room", room, s.cfg.MaxRoomNameLen); err != nil {
+		return nil, err
+	}
+	if err := checkRenderable("room", "ERR_INVALID_ROOM", room); err != nil {
+		return nil, err
+	}
+	if s.cfg.FilterNames {
+		if err := checkName("room", room, s.words); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkBanned(req.ID, req.IP); err != nil {
+		return nil, err
+	}
+	if err := s.checkJoinRate(req.IP); err != nil {
+		return nil, err
+	}
+	protocol, err := checkProtocol(req.Protocol)
+	if err != nil {
+		return nil, err
+	}
+	name := req.Name
+	if name == "" {
+		name = req.ID
+	} else if err := s.checkDisplayName(name); err != nil {
+		return nil, err
+	}
+	if err := s.checkAttributes(req.Attributes); err != nil {
+		return nil, err
+	}
+	key := s.key(req.ID)
+	if !req.AddSession {
+		if res, ok := s.resume(ctx, key, room, req.Token, protocol); ok {
+			return res, nil
+		}
+	}
+	if s.draining.Load() {
+		return nil, errcom.NewCustomError("ERR_DRAINING", errors.New("server is draining, try another instance"))
+	}
+
+	// A forced rejoin may find the ID still claimed by its own stale
+	// session, so the claim is only decisive when no local session exists.
+	registerErr := s.cfg.Backend.Register(ctx, key)
+	if registerErr != nil && !req.Force {
+		return nil, registerErr
+	}
+
+	s.mu.Lock()
+	if s.closed.Load() {
+		// Close ran since checkOpen; it has already emptied the streams,
+		// so the client must not be added after it.
+		s.mu.Unlock()
+		if registerErr == nil {
+			s.cfg.Backend.Unregister(ctx, key)
+		}
+		return nil, errUnavailable()
+	}
+	old, exists := s.streams[key]
+	if exists && !req.Force && s.cfg.MaxSessions > 1 && old.Room == room && s.ownsSession(ctx, old, req.Token) {
+		sess := newSession(s.cfg.BufferSize, &s.bufferedBytes)
+		added := old.addSession(sess, s.cfg.MaxSessions)
+		s.mu.Unlock()
+		if !added {
+			return nil, errcom.NewCustomError("ERR_TOO_MANY_SESSIONS", fmt.Errorf("user already has %d sessions", s.cfg.MaxSessions))
+		}
+		old.touch()
+		return &model.JoinResponse{
+			Success:  true,
+			Message:  "Session added",
+			Protocol: protocol,
+			Room:     room,
+			Token:    sess.token,
+		}, nil
+	}
+	if exists && (!req.Force || !old.stale(s.cfg.StaleAfter)) {
+		s.mu.Unlock()
+		return nil, errcom.NewCustomError("ERR_ALREADY_JOINED", errors.New("user already joined"))
+	}
+	if !exists && registerErr != nil {
+		s.mu.Unlock()
+		return nil, registerErr
+	}
+	if err := s.checkCapacity(room, exists, old); err != nil {
+		s.mu.Unlock()
+		if !exists {
+			s.cfg.Backend.Unregister(ctx, key)
+		}
+		return nil, err
+	}
+	if exists {
+		old.closeStream()
+		if old.Room != room {
+			delete(s.streams, key)
+			s.announce(s.presenceMessage(model.PresenceLeave, old, s.participants(old.Room)), old)
+		}
+	}
+
+	sess := newSession(s.cfg.BufferSize, &s.bufferedBytes)
+	client := &Client{
+		ID:          key,
+		Name:        name,
+		Room:        room,
+		Attributes:  maps.Clone(req.Attributes),
+		Status:      model.StatusOnline,
+		Observer:    req.Observer,
+		sessions:    []*session{sess},
+		RateLimiter: rate.NewLimiter(s.cfg.RateLimit, s.cfg.RateBurst),
+		clock:       s.cfg.Clock,
+	}
+	client.touch()
+	s.streams[key] = client
+	// The rest of the room never saw a session replaced in place leave,
+	// so it is not told about it rejoining either.
+	if (!exists || old.Room != room) && !client.Observer {
+		notice := s.presenceMessage(model.PresenceJoin, client, s.participants(room))
+		s.broadcast(notice, room, key)
+		s.mu.Unlock()
+		// Fan-out to other instances is best effort; local members have
+		// already been told.
+		s.cfg.Backend.Publish(ctx, room, notice)
+	} else {
+		s.mu.Unlock()
+	}
+	s.stats.joins.Add(1)
+	s.emit(model.EventJoin, key, room, model.ChatEvent{})
+
+	return &model.JoinResponse{
+		Success:  true,
+		Message:  "User joined successfully",
+		Protocol: protocol,
+		Room:     room,
+		Token:    sess.token,
+	}, nil
+}
+
+// resume returns the existing session under key when token, or the token
+// carried by ctx, is its own and it is in room, so that a client retrying a
+// join gets its session back instead of ERR_ALREADY_JOINED.
+func (s *chatService) resume(ctx context.Context, key, room, token string, protocol int) (*model.JoinResponse, bool) {
+	if token == "" {
+		token, _ = TokenFromContext(ctx)
+	}
+	if token == "" {
+		return nil, false
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[key]
+	s.mu.RUnlock()
+
+	if !exists || client.Room != room {
+		return nil, false
+	}
+	sess := client.sessionByToken(token)
+	if sess == nil {
+		return nil, false
+	}
+	client.touch()
+	return &model.JoinResponse{
+		Success:  true,
+		Message:  "Session resumed",
+		Protocol: protocol,
+		Room:     room,
+		Token:    sess.token,
+		Resumed:  true,
+	}, true
+}
+
+// checkCapacity rejects a join into room once the service or the room is
+// full. A session replacing old in place takes no new slot. The caller must
+// hold s.mu.
+func (s *chatService) checkCapacity(room string, exists bool, old *Client) error {
+	if !exists && s.cfg.MaxClients > 0 && len(s.streams) >= s.cfg.MaxClients {
+		return errcom.NewCustomError("ERR_SERVER_FULL", errors.New("server has reached its client limit"))
+	}
+	if exists && old.Room == room {
+		return nil
+	}
+	members := s.members(room)
+	if max := s.maxMembers(room); max > 0 && members >= max {
+		return errcom.NewCustomError("ERR_ROOM_FULL", errors.New("room has reached its member limit"))
+	}
+	if members == 0 && s.cfg.MaxRooms > 0 {
+		rooms := s.rooms()
+		if exists && s.members(old.Room) == 1 {
+			// Moving out of a room leaves it empty, freeing its slot.
+			rooms--
+		}
+		if rooms >= s.cfg.MaxRooms {
+			return errcom.NewCustomError("ERR_TOO_MANY_ROOMS", errors.New("server has reached its room limit"))
+		}
+	}
+	return nil
+}
+
+func (s *chatService) SendMessage(ctx context.Context, req model.SendMessageRequest) (*model.SendMessageResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.From == "" || (req.Message == "" && req.Attachment == nil) {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("From and Message are required"))
+	}
+	if req.Attachment != nil {
+		attachment := *req.Attachment
+		if err := checkAttachment(&attachment, s.cfg.MaxAttachmentBytes); err != nil {
+			return nil, err
+		}
+		req.Attachment = &attachment
+	}
+	if req.To != "" && len(req.ToList) > 0 {
+		return nil, errcom.NewCustomError("ERR_INVALID_RECIPIENTS", errors.New("to and to_list cannot both be set"))
+	}
+	if err := s.checkIdentity(ctx, req.From); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.From, req.Token); err != nil {
+		return nil, err
+	}
+	if s.observer(req.From) {
+		return nil, errObserverReadonly()
+	}
+	if req.Token != "" {
+		// Commands such as /leave act as the sender with the same token.
+		ctx = WithToken(ctx, req.Token)
+	}
+	text, err := checkMessage(req.Message, s.cfg.ControlChars)
+	if err != nil {
+		return nil, err
+	}
+	req.Message = text
+	if req.Attachment == nil && s.isCommand(req.Message) {
+		return s.runCommand(ctx, req.From, req.Message)
+	}
+	if s.cfg.DirectOnly && req.To == "" && len(req.ToList) == 0 {
+		return nil, errcom.NewCustomError("ERR_BROADCAST_DISABLED", errors.New("messages must name their recipients"))
+	}
+
+	s.mu.RLock()
+	sender, exists := s.streams[s.key(req.From)]
+	if !exists {
+		s.mu.RUnlock()
+		return nil, errcom.NewCustomError("ERR_SENDER_NOT_FOUND", errors.New("sender not connected"))
+	}
+	// Rename changes the name under the write lock, so read it now.
+	name := sender.Name
+	if maxLen := s.maxMessageLen(sender.Room); utf8.RuneCountInString(req.Message) > maxLen {
+		s.mu.RUnlock()
+		return nil, errcom.NewCustomError("ERR_MESSAGE_TOO_LONG", fmt.Errorf("message must be under %d characters", maxLen))
+	}
+	ids := req.ToList
+	if req.To != "" {
+		ids = []string{req.To}
+	}
+	private := len(ids) > 0
+	recipients, notFound := s.recipientsOf(ids)
+	if req.To != "" && len(notFound) > 0 {
+		s.mu.RUnlock()
+		return nil, errcom.NewCustomError("ERR_RECIPIENT_NOT_FOUND", errors.New("recipient not connected"))
+	}
+
+	text, redactions := s.cfg.ComplianceFilter(req.Message)
+	allowed, text, err := s.cfg.Moderator.Check(text)
+	if err != nil {
+		s.mu.RUnlock()
+		return nil, errcom.NewCustomError("ERR_MODERATION_FAILED", err)
+	}
+	if !allowed {
+		s.mu.RUnlock()
+		return nil, errcom.NewCustomError("ERR_MESSAGE_REJECTED", errors.New("message was rejected by moderation"))
+	}
+	dedupe := dedupeKey(req)
+	if dedupe != "" {
+		res, wait := sender.sent.claim(dedupe, s.cfg.IdempotencyTTL, s.cfg.Clock.Now())
+		switch {
+		case res != nil:
+			s.mu.RUnlock()
+			res.Duplicate = true
+			return res, nil
+		case wait != nil:
+			// The original is still being delivered; answer with its
+			// result, or send afresh if it failed.
+			s.mu.RUnlock()
+			select {
+			case <-wait:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return s.SendMessage(ctx, req)
+		}
+		// Only a send that succeeds is remembered; any other return lets
+		// a retry try again.
+		defer sender.sent.release(dedupe)
+	}
+	if now := s.cfg.Clock.Now(); !rateCharged(ctx) && !sender.RateLimiter.AllowN(now, 1) {
+		s.mu.RUnlock()
+		s.stats.rateLimited.Add(1)
+		return nil, errRateLimit(sender.RateLimiter, now, "too many messages")
+	}
+	if err := s.checkQuota(sender); err != nil {
+		s.mu.RUnlock()
+		s.stats.rateLimited.Add(1)
+		return nil, err
+	}
+	shed := false
+	if !private {
+		if err := s.checkRoomRate(sender.Room); err != nil {
+			s.mu.RUnlock()
+			return nil, err
+		}
+		if shed, err = s.checkMemory(); err != nil {
+			s.mu.RUnlock()
+			return nil, err
+		}
+	}
+
+	if s.cfg.ModerationAction != ModerationOff && s.words.Contains(text) {
+		switch s.cfg.ModerationAction {
+		case ModerationReject:
+			s.mu.RUnlock()
+			return nil, errcom.NewCustomError("ERR_MESSAGE_REJECTED", errors.New("message contains a word that is not allowed"))
+		case ModerationMask:
+			text = s.words.Mask(text)
+		case ModerationQuarantine:
+			s.mu.RUnlock()
+			held, ok := s.held.hold(model.QuarantinedMessage{
+				From:       sender.ID,
+				Name:       name,
+				Room:       sender.Room,
+				To:         req.To,
+				ToList:     req.ToList,
+				Text:       text,
+				Attachment: req.Attachment,
+			}, s.cfg.QuarantineSize, s.cfg.Clock.Now())
+			if !ok {
+				return nil, errcom.NewCustomError("ERR_QUARANTINE_FULL", errors.New("moderation queue is full"))
+			}
+			s.stats.redactions.Add(uint64(redactions))
+			return &model.SendMessageResponse{
+				Success:      true,
+				Message:      "Message held for moderator review",
+				QuarantineID: held.ID,
+			}, nil
+		}
+	}
+
+	if !private {
+		recipients = s.audience(sender.Room, sender.ID)
+	}
+	s.mu.RUnlock()
+
+	message := s.chatMessage(sender.ID, name, text)
+	message.Attachment = req.Attachment
+	var out fanout
+	if !shed {
+		out = s.deliverTo(ctx, message, sender.Room, private, recipients)
+	}
+	// A message nobody received is not recorded, published or counted, so
+	// that a retry after ERR_NO_RECEIVERS leaves no duplicate behind.
+	if out.delivered+out.dropped == 0 && !out.canceled && !shed && (private || (s.cfg.RequireReceivers && !s.shared)) {
+		s.receipts.forget(message.ID)
+		var details map[string]any
+		if len(notFound) > 0 {
+			details = map[string]any{"not_found": notFound}
+		}
+		return nil, errcom.NewCustomErrorWithDetails("ERR_NO_RECEIVERS", errors.New("no clients received the message"), details)
+	}
+	if req.Echo {
+		s.offer(ctx, sender, message)
+	}
+	if !private {
+		// Direct and group messages stay out of room history.
+		s.cfg.Store.Append(sender.Room, message)
+		s.cfg.Backend.Publish(ctx, sender.Room, message)
+	}
+	s.stats.messagesSent.Add(1)
+	s.stats.redactions.Add(uint64(redactions))
+	s.emit(model.EventSend, sender.ID, sender.Room, model.ChatEvent{MessageID: message.ID, Text: message.Body})
+
+	res := model.SendMessageResponse{
+		Success:   true,
+		Message:   "Message broadcasted to clients",
+		Delivered: out.delivered,
+		Dropped:   out.dropped,
+		TimedOut:  out.timedOut,
+		Canceled:  out.canceled,
+		MessageID: message.ID,
+		NotFound:  notFound,
+		Shed:      shed,
+	}
+	if shed {
+		res.Message = "Message recorded but not delivered: server is under memory pressure"
+	}
+	res.Overloaded = s.overloaded(out)
+	if dedupe != "" {
+		sender.sent.finish(dedupe, res, s.cfg.IdempotencyKeys, s.cfg.Clock.Now())
+	}
+	return &res, nil
+}
+
+func (s *chatService) Leave(ctx context.Context, req model.LeaveRequest) (*model.LeaveResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, req.Token); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	client, err := s.resolve(req.ID, req.Room)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	sess, err := s.sessionFor(ctx, client, req.Token)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if client.removeSession(sess) > 0 {
+		// The user stays in the room through its other sessions.
+		s.mu.Unlock()
+		sess.box.close()
+		res := &model.LeaveResponse{Success: true, Message: "Session closed"}
+		if req.Drain {
+			res.Messages = s.drain(sess)
+		}
+		return res, nil
+	}
+	delete(s.streams, client.ID)
+	notice := s.presenceMessage(model.PresenceLeave, client, s.participants(client.Room))
+	s.announce(notice, client)
+	s.mu.Unlock()
+	s.cfg.Backend.Unregister(ctx, client.ID)
+	if !client.Observer {
+		s.cfg.Backend.Publish(ctx, client.Room, notice)
+	}
+	s.stats.leaves.Add(1)
+	s.emit(model.EventLeave, client.ID, client.Room, model.ChatEvent{Reason: "left"})
+
+	client.closeStream()
+
+	res := &model.LeaveResponse{
+		Success: true,
+		Message: "User disconnected successfully",
+	}
+	if req.Drain {
+		res.Messages = s.drain(sess)
+	}
+	return res, nil
+}
+
+// drain returns the unexpired messages left in the closed sess. The mailbox
+// is closed, so the drain ends with what was buffered.
+func (s *chatService) drain(sess *session) []model.MessageResponse {
+	var msgs []model.MessageResponse
+	for _, msg := range sess.box.take(sess.box.capacity()) {
+		if !s.expired(msg) {
+			msgs = append(msgs, toMessageResponse(msg))
+		}
+	}
+	return msgs
+}
+
+// Ping refreshes the client's last-seen time so the cleanup loop keeps it,
+// leaving its mailbox untouched.
+func (s *chatService) Ping(ctx context.Context, req model.PingRequest) (*model.PingResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, req.Token); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(req.ID)]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	client.touch()
+	return &model.PingResponse{Success: true, Message: "pong"}, nil
+}
+
+// Kick behaves like Leave, except that the kicked client is sent a notice
+// before its stream is closed and the room is told it was removed.
+func (s *chatService) Kick(ctx context.Context, req model.KickRequest) (*model.KickResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+
+	s.mu.Lock()
+	client, exists := s.streams[s.key(req.ID)]
+	if !exists {
+		s.mu.Unlock()
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	delete(s.streams, client.ID)
+	notice := s.presenceMessage(model.PresenceKick, client, s.participants(client.Room))
+	s.announce(notice, client)
+	s.mu.Unlock()
+	s.cfg.Backend.Unregister(ctx, client.ID)
+	if !client.Observer {
+		s.cfg.Backend.Publish(ctx, client.Room, notice)
+	}
+	s.stats.leaves.Add(1)
+	s.emit(model.EventLeave, client.ID, client.Room, model.ChatEvent{Reason: "kicked"})
+
+	text := "* You were removed by a moderator"
+	if req.Reason != "" {
+		text += ": " + req.Reason
+	}
+	kicked := s.presenceMessage(model.PresenceKick, client, 0)
+	kicked.Text = text
+	kicked.Event.Count = 0
+	client.deliver(ctx, kicked, 0)
+	client.closeStream()
+
+	return &model.KickResponse{
+		Success: true,
+		Message: "User removed",
+	}, nil
+}
+
+// DisconnectAll removes every client on this instance, or every client in
+// req.Room, sending each a notice before closing its stream. Whole rooms
+// leave at once, so no leave notices are broadcast.
+func (s *chatService) DisconnectAll(ctx context.Context, req model.DisconnectAllRequest) (*model.DisconnectAllResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	var targets []*Client
+	for id, client := range s.streams {
+		if req.Room == "" || client.Room == req.Room {
+			targets = append(targets, client)
+			delete(s.streams, id)
+		}
+	}
+	s.mu.Unlock()
+
+	text := "* You were disconnected by the server"
+	if req.Reason != "" {
+		text += ": " + req.Reason
+	}
+	for _, client := range targets {
+		s.cfg.Backend.Unregister(ctx, client.ID)
+		notice := s.presenceMessage(model.PresenceKick, client, 0)
+		notice.Text = text
+		notice.Event.Count = 0
+		client.deliver(ctx, notice, 0)
+		client.closeStream()
+		s.emit(model.EventLeave, client.ID, client.Room, model.ChatEvent{Reason: "disconnected"})
+	}
+	s.stats.leaves.Add(uint64(len(targets)))
+
+	return &model.DisconnectAllResponse{
+		Success:      true,
+		Message:      fmt.Sprintf("Disconnected %d users", len(targets)),
+		Disconnected: len(targets),
+	}, nil
+}
+
+// Announce queues req.Message for every client on this instance, across all
+// rooms, ahead of their chat messages. It is not rate limited and is never
+// dropped for a full buffer; the oldest chat message gives way instead. The
+// announcement is recorded in the history of every room with members.
+func (s *chatService) Announce(ctx context.Context, req model.AnnounceRequest) (*model.AnnounceResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.Message == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_FIELD", errors.New("message is required"))
+	}
+	text, err := checkMessage(req.Message, s.cfg.ControlChars)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := model.Message{
+		Type:      model.MessageTypeAnnouncement,
+		Text:      "* " + text,
+		ID:        s.nextMessageID(),
+		Body:      text,
+		System:    true,
+		Timestamp: s.cfg.Clock.Now(),
+	}
+	delivered := 0
+	rooms := make(map[string]struct{})
+	s.mu.RLock()
+	for _, client := range s.streams {
+		ok, displaced := client.force(msg)
+		if ok {
+			delivered++
+		}
+		if displaced > 0 {
+			s.stats.dropped.Add(uint64(displaced))
+			client.dropped.Add(uint64(displaced))
+		}
+		rooms[client.Room] = struct{}{}
+	}
+	s.mu.RUnlock()
+	for room := range rooms {
+		s.cfg.Store.Append(room, msg)
+	}
+
+	return &model.AnnounceResponse{
+		Success:   true,
+		Message:   fmt.Sprintf("Announcement sent to %d users", delivered),
+		Delivered: delivered,
+		MessageID: msg.ID,
+	}, nil
+}
+
+// receiveWait parses a requested receive wait, defaulting to
+// cfg.ReceiveTimeout and clamping it to cfg.MaxReceiveWait.
+func (s *chatService) receiveWait(raw string) (time.Duration, error) {
+	if raw == "" {
+		return s.cfg.ReceiveTimeout, nil
+	}
+	wait, err := time.ParseDuration(raw)
+	if err != nil || wait <= 0 {
+		return 0, errcom.NewCustomError("ERR_INVALID_WAIT", fmt.Errorf("wait must be a positive duration such as 30s, got %q", raw))
+	}
+	return min(wait, s.cfg.MaxReceiveWait), nil
+}
+
+// GetMessage waits for the next message on the caller's session. Only one
+// receive may be in flight per session; a concurrent call fails immediately
+// with ERR_ALREADY_RECEIVING instead of racing the first for the message.
+// With req.Since set, stored room history after the cursor is returned first.
+func (s *chatService) GetMessage(ctx context.Context, req model.MessageRequest) (*model.MessageResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, req.Token); err != nil {
+		return nil, err
+	}
+	wait, err := s.receiveWait(req.Wait)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	client, err := s.resolve(req.ID, req.Room)
+	s.mu.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+	sess, err := s.sessionFor(ctx, client, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	if err := claimSession(sess); err != nil {
+		return nil, err
+	}
+	defer sess.receiving.Store(false)
+
+	client.touch()
+
+	if req.Since != "" {
+		msg, ok, err := s.replay(client.Room, req.Since)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res := toMessageResponse(msg)
+			return &res, nil
+		}
+	}
+
+	s.waiting.Add(1)
+	defer s.waiting.Add(-1)
+	defer func(start time.Time) { s.stats.receiveWait.observe(ctx, s.cfg.Clock.Now().Sub(start)) }(s.cfg.Clock.Now())
+
+	timeout := s.cfg.Clock.After(wait)
+	for {
+		msg, ok, wake := sess.box.next()
+		if !ok && wake != nil {
+			select {
+			case <-wake:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-timeout:
+				return nil, errcom.NewCustomErrorWithDetails("ERR_NO_MESSAGES", errors.New("no messages received"), map[string]any{
+					"retry_after_ms":  s.pollHint().Milliseconds(),
+					"poll_timeout_ms": wait.Milliseconds(),
+				})
+			}
+		}
+		if !ok {
+			if s.closed.Load() {
+				return nil, errShuttingDown()
+			}
+			return nil, errcom.NewCustomError("ERR_USER_DISCONNECTED", errors.New("user stream closed"))
+		}
+		if s.expired(msg) {
+			continue
+		}
+		res := toMessageResponse(msg)
+		return &res, nil
+	}
+}
+
+// expired reports whether msg waited on a stream for longer than
+// cfg.MessageTTL.
+func (s *chatService) expired(msg model.Message) bool {
+	return s.cfg.MessageTTL > 0 && !msg.Queued.IsZero() && s.cfg.Clock.Now().Sub(msg.Queued) > s.cfg.MessageTTL
+}
+
+// Re-poll backoff suggested to clients whose receive timed out.
+const (
+	pollHintBase = 100 * time.Millisecond
+	pollHintStep = 100 // waiting receivers per extra pollHintBase
+	pollHintMax  = 5 * time.Second
+)
+
+// pollHint suggests how long a client should wait before polling again,
+// backing off as more receivers are waiting on the service.
+func (s *chatService) pollHint() time.Duration {
+	hint := pollHintBase * time.Duration(1+s.waiting.Load()/pollHintStep)
+	if hint > pollHintMax {
+		hint = pollHintMax
+	}
+	return hint
+}
+
+// GetMessages drains up to req.Max buffered messages. When the buffer is empty
+// it waits up to ReceiveTimeout for the first one, then drains any that
+// arrived with it; a timeout yields an empty batch. With OrderNewest the
+// whole buffer is drained and only the newest req.Max messages are returned,
+// newest first; older ones are discarded. The drain is bounded by the buffer
+// size, so it never does more than BufferSize reads.
+func (s *chatService) GetMessages(ctx context.Context, req model.MessagesRequest) (*model.MessagesResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+	if req.Order == "" {
+		req.Order = model.OrderOldest
+	}
+	if req.Order != model.OrderOldest && req.Order != model.OrderNewest {
+		return nil, errcom.NewCustomError("ERR_INVALID_ORDER", fmt.Errorf("order must be %q or %q", model.OrderOldest, model.OrderNewest))
+	}
+	if req.Max <= 0 || req.Max > s.cfg.BufferSize {
+		req.Max = s.cfg.BufferSize
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, ""); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(req.ID)]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	sess, err := s.sessionFor(ctx, client, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := claimSession(sess); err != nil {
+		return nil, err
+	}
+	defer sess.receiving.Store(false)
+
+	client.touch()
+
+	var first []model.Message
+	if sess.box.len() == 0 {
+		s.waiting.Add(1)
+		timeout := s.cfg.Clock.After(s.cfg.ReceiveTimeout)
+		for first == nil {
+			msg, ok, wake := sess.box.next()
+			if !ok && wake != nil {
+				select {
+				case <-wake:
+					continue
+				case <-ctx.Done():
+					s.waiting.Add(-1)
+					return nil, ctx.Err()
+				case <-timeout:
+					s.waiting.Add(-1)
+					return &model.MessagesResponse{Messages: []model.MessageResponse{}}, nil
+				}
+			}
+			if !ok {
+				s.waiting.Add(-1)
+				if s.closed.Load() {
+					return nil, errShuttingDown()
+				}
+				return nil, errcom.NewCustomError("ERR_USER_DISCONNECTED", errors.New("user stream closed"))
+			}
+			if !s.expired(msg) {
+				first = append(first, msg)
+			}
+		}
+		s.waiting.Add(-1)
+	}
+
+	limit := req.Max - len(first)
+	if req.Order == model.OrderNewest {
+		limit = sess.box.capacity()
+	}
+	queued := sess.box.take(limit)
+	drained := first
+	for _, msg := range queued {
+		if !s.expired(msg) {
+			drained = append(drained, msg)
+		}
+	}
+
+	if req.Order == model.OrderNewest {
+		if len(drained) > req.Max {
+			drained = drained[len(drained)-req.Max:]
+		}
+		for i, j := 0, len(drained)-1; i < j; i, j = i+1, j-1 {
+			drained[i], drained[j] = drained[j], drained[i]
+		}
+	}
+
+	res := &model.MessagesResponse{Messages: make([]model.MessageResponse, 0, len(drained))}
+	for _, msg := range drained {
+		res.Messages = append(res.Messages, toMessageResponse(msg))
+	}
+	return res, nil
+}
+
+// Stream holds the session's receive slot for its whole duration, so a
+// concurrent GetMessage fails with ERR_ALREADY_RECEIVING. The client is
+// kept alive while the stream is open even if no messages arrive.
+func (s *chatService) Stream(ctx context.Context, id string, fn func(model.MessageResponse) error) error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if id == "" {
+		return errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+	if err := s.checkIdentity(ctx, id); err != nil {
+		return err
+	}
+	if err := s.checkToken(ctx, id, ""); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(id)]
+	s.mu.RUnlock()
+
+	if !exists {
+		return errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	sess, err := s.sessionFor(ctx, client, "")
+	if err != nil {
+		return err
+	}
+	if err := claimSession(sess); err != nil {
+		return err
+	}
+	defer sess.receiving.Store(false)
+
+	client.touch()
+	// An IdleTimeout under 2ns would make a zero interval, which spins.
+	interval := max(s.cfg.IdleTimeout/2, time.Millisecond)
+	keepAlive := s.cfg.Clock.After(interval)
+
+	for {
+		msg, ok, wake := sess.box.next()
+		if !ok && wake != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-keepAlive:
+				client.touch()
+				keepAlive = s.cfg.Clock.After(interval)
+			case <-wake:
+			}
+			continue
+		}
+		if !ok {
+			if s.closed.Load() {
+				return errShuttingDown()
+			}
+			return errcom.NewCustomError("ERR_USER_DISCONNECTED", errors.New("user stream closed"))
+		}
+		client.touch()
+		if s.expired(msg) {
+			continue
+		}
+		if err := fn(toMessageResponse(msg)); err != nil {
+			return err
+		}
+	}
+}
+
+func toMessageResponse(msg model.Message) model.MessageResponse {
+	return model.MessageResponse{
+		Message:    msg.Text,
+		Type:       msg.Type,
+		System:     msg.System,
+		ID:         msg.ID,
+		From:       msg.From,
+		Text:       msg.Body,
+		Timestamp:  msg.Timestamp,
+		Event:      msg.Event,
+		Mentioned:  msg.Mentioned,
+		Attachment: msg.Attachment,
+		Seq:        msg.Seq,
+	}
+}
+
+// StartHeartbeat lets long-lived stream connections confirm end-to-end that
+// the delivery pipeline is alive. Heartbeats are dropped rather than queued
+// when the client's buffer is full.
+func (s *chatService) StartHeartbeat(ctx context.Context, id, token string) error {
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if err := s.checkIdentity(ctx, id); err != nil {
+		return err
+	}
+	if err := s.checkToken(ctx, id, token); err != nil {
+		return err
+	}
+
+	// Registering with s.loops under the lock orders it before Close clears
+	// the streams, and so before any Shutdown waits on s.loops.
+	s.mu.RLock()
+	client, exists := s.streams[s.key(id)]
+	if exists {
+		s.loops.Add(1)
+	}
+	s.mu.RUnlock()
+
+	if !exists {
+		return errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+
+	go func() {
+		defer s.loops.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.done:
+				return
+			case <-s.cfg.Clock.After(s.cfg.HeartbeatInterval):
+			}
+			heartbeat := model.Message{
+				Type:      model.MessageTypeHeartbeat,
+				Text:      "* heartbeat",
+				System:    true,
+				Timestamp: s.cfg.Clock.Now(),
+			}
+			if client.deliver(ctx, heartbeat, 0) == streamClosed {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *chatService) EffectiveConfig(ctx context.Context) map[string]any {
+	return RedactedConfig(s.cfg)
+}
+
+func (s *chatService) SetDraining(draining bool) {
+	s.draining.Store(draining)
+}
+
+func (s *chatService) Draining() bool {
+	return s.draining.Load()
+}
+
+func (s *chatService) GetUserRooms(ctx context.Context, id string) (*model.UserRoomsResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(id)]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+
+	return &model.UserRoomsResponse{
+		ID:    client.ID,
+		Rooms: []string{client.Room},
+	}, nil
+}
+
+// GetUsers returns a snapshot of the connected users, sorted by ID.
+func (s *chatService) GetUsers(ctx context.Context, req model.UsersRequest) (*model.UsersResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.Limit < 0 {
+		return nil, errcom.NewCustomError("ERR_INVALID_LIMIT", errors.New("limit must not be negative"))
+	}
+	paged := req.Limit > 0 || req.Cursor != ""
+	if paged && req.Limit == 0 {
+		req.Limit = defaultUsersLimit
+	}
+	req.Limit = min(req.Limit, maxUsersLimit)
+	var after string
+	if req.Cursor != "" {
+		var err error
+		if after, err = decodeCursor(usersCursor, req.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.RLock()
+	users := make([]model.UserInfo, 0, len(s.streams))
+	for _, client := range s.streams {
+		if (req.Room != "" && client.Room != req.Room) || (after != "" && client.ID <= after) {
+			continue
+		}
+		info := model.UserInfo{
+			ID:       client.ID,
+			Name:     client.Name,
+			Room:     client.Room,
+			LastSeen: client.LastSeen(),
+			Status:   client.Status,
+			Observer: client.Observer,
+			Sessions: len(client.sessionList()),
+		}
+		if req.Stats {
+			info.Stats = &model.ClientStats{
+				Dropped:  client.dropped.Load(),
+				Buffered: client.buffered(),
+				Capacity: s.cfg.BufferSize,
+			}
+		}
+		users = append(users, info)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	res := &model.UsersResponse{Users: users}
+	if paged && len(users) > req.Limit {
+		res.Users = users[:req.Limit]
+		res.NextCursor = encodeCursor(usersCursor, res.Users[req.Limit-1].ID)
+	}
+	return res, nil
+}
+
+// Page sizes for GetUsers when paging.
+const (
+	defaultUsersLimit = 100
+	maxUsersLimit     = 1000
+)
+
+// Page sizes for ListRooms.
+const (
+	defaultRoomsLimit = 100
+	maxRoomsLimit     = 1000
+)
+
+// ListRooms only counts members while holding the read lock; filtering,
+// sorting and paging happen on the snapshot so senders aren't held up.
+func (s *chatService) ListRooms(ctx context.Context, req model.ListRoomsRequest) (*model.ListRoomsResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.Offset < 0 {
+		return nil, errcom.NewCustomError("ERR_INVALID_OFFSET", errors.New("offset must not be negative"))
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultRoomsLimit
+	}
+	if req.Limit > maxRoomsLimit {
+		req.Limit = maxRoomsLimit
+	}
+
+	s.mu.RLock()
+	counts := make(map[string]int)
+	for _, client := range s.streams {
+		counts[client.Room]++
+	}
+	s.mu.RUnlock()
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		if strings.HasPrefix(name, req.Prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	res := &model.ListRoomsResponse{Rooms: []model.RoomInfo{}, Total: len(names)}
+	if req.Offset >= len(names) {
+		return res, nil
+	}
+	end := req.Offset + req.Limit
+	if end < len(names) {
+		res.NextOffset = end
+	} else {
+		end = len(names)
+	}
+	for _, name := range names[req.Offset:end] {
+		res.Rooms = append(res.Rooms, model.RoomInfo{Name: name, Members: counts[name]})
+	}
+	return res, nil
+}
+
+func (s *chatService) SnapshotStats(reset bool) model.StatsSnapshot {
+	snap := s.stats.snapshot(reset)
+	snap.HistoryBytes = s.cfg.Store.Usage()
+	snap.UptimeSeconds = s.cfg.Clock.Now().Sub(s.started).Seconds()
+	snap.BufferedBytes = s.bufferedBytes.Load()
+	snap.MaxBufferedBytes = s.cfg.MaxBufferedBytes
+	rooms := make(map[string]struct{})
+	s.mu.RLock()
+	snap.Connected = len(s.streams)
+	for _, client := range s.streams {
+		rooms[client.Room] = struct{}{}
+	}
+	s.mu.RUnlock()
+	snap.Rooms = len(rooms)
+	return snap
+}
+
+// Stats reads each counter atomically and holds s.mu only to count clients
+// and rooms.
+func (s *chatService) Stats(ctx context.Context) model.ServiceStats {
+	s.mu.RLock()
+	clients, rooms := len(s.streams), s.rooms()
+	s.mu.RUnlock()
+	return model.ServiceStats{
+		Clients:       clients,
+		Rooms:         rooms,
+		MessagesSent:  s.stats.messagesSent.Load(),
+		Dropped:       s.stats.dropped.Load(),
+		RateLimited:   s.stats.rateLimited.Load(),
+		UptimeSeconds: s.cfg.Clock.Now().Sub(s.started).Seconds(),
+	}
+}
+
+func (s *chatService) ListQuarantine(ctx context.Context) []model.QuarantinedMessage {
+	return s.held.list()
+}
+
+// Moderate resolves a quarantined message. Approved messages are broadcast
+// to the room they were sent in as if the sender had just sent them.
+func (s *chatService) Moderate(ctx context.Context, req model.ModerateRequest) (*model.ModerateResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	held, ok := s.held.take(req.ID)
+	if !ok {
+		return nil, errcom.NewCustomError("ERR_QUARANTINE_NOT_FOUND", errors.New("no quarantined message with that ID"))
+	}
+	if !req.Approve {
+		return &model.ModerateResponse{
+			Success: true,
+			Message: "Message discarded",
+		}, nil
+	}
+
+	message := s.chatMessage(held.From, held.Name, held.Text)
+	message.Attachment = held.Attachment
+	ids := held.ToList
+	if held.To != "" {
+		ids = []string{held.To}
+	}
+	private := len(ids) > 0
+	s.mu.RLock()
+	recipients, _ := s.recipientsOf(ids)
+	if !private {
+		recipients = s.audience(held.Room, held.From)
+	}
+	s.mu.RUnlock()
+	out := s.deliverTo(ctx, message, held.Room, private, recipients)
+	if !private {
+		s.cfg.Store.Append(held.Room, message)
+		s.cfg.Backend.Publish(ctx, held.Room, message)
+	}
+	s.stats.messagesSent.Add(1)
+	s.emit(model.EventSend, held.From, held.Room, model.ChatEvent{MessageID: message.ID, Text: message.Body})
+
+	return &model.ModerateResponse{
+		Success:   true,
+		Message:   "Message approved and broadcast",
+		Delivered: out.delivered,
+		Dropped:   out.dropped,
+	}, nil
+}
+
+// observer reports whether id is connected as an observer.
+func (s *chatService) observer(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client, exists := s.streams[s.key(id)]
+	return exists && client.Observer
+}
+
+func errObserverReadonly() error {
+	return errcom.NewCustomError("ERR_OBSERVER_READONLY", errors.New("observers cannot send"))
+}
+
+// checkOpen fails with ERR_SERVICE_UNAVAILABLE once the service is closed,
+// so that calls after Close or Shutdown fail cleanly instead of acting on
+// torn-down state. Receives already waiting when the service closes are
+// woken with ERR_SERVER_SHUTTING_DOWN instead; the two codes tell a client
+// whether its call was cut short or arrived too late.
+func (s *chatService) checkOpen() error {
+	if s.closed.Load() {
+		return errUnavailable()
+	}
+	return nil
+}
+
+// errShuttingDown is returned by receives that were waiting when the service
+// closed.
+func errShuttingDown() error {
+	return errcom.NewCustomError("ERR_SERVER_SHUTTING_DOWN", errors.New("server is shutting down"))
+}
+
+func errUnavailable() error {
+	return errcom.NewCustomError("ERR_SERVICE_UNAVAILABLE", errors.New("service has been shut down"))
+}
+
+// Close is safe to call more than once; only the first call has any effect.
+func (s *chatService) Close() error {
+	if !s.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(s.done)
+	s.cfg.Backend.Close()
+	defer s.events.close()
+	if s.fair != nil {
+		s.fair.close()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, client := range s.streams {
+		client.closeStream()
+		delete(s.streams, id)
+	}
+	return nil
+}
+
+// Shutdown saves room history to cfg.SnapshotPath, if set, once the service
+// is closed.
+func (s *chatService) Shutdown(ctx context.Context) error {
+	s.Close()
+	saveErr := s.saveSnapshot()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.loops.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return saveErr
+	case <-ctx.Done():
+		return errors.Join(saveErr, ctx.Err())
+	}
+}
+
+// errRateLimit reports that limiter refused a request at now, with a hint of
+// how long until it would allow one.
+func errRateLimit(limiter *rate.Limiter, now time.Time, reason string) error {
+	return errLimited("ERR_RATE_LIMIT", limiter, now, reason)
+}
+
+// errLimited reports that limiter refused an action, with code, detailing
+// how long until it would succeed.
+func errLimited(code string, limiter *rate.Limiter, now time.Time, reason string) error {
+	var details map[string]any
+	if r := limiter.ReserveN(now, 1); r.OK() {
+		details = map[string]any{"retry_after_ms": r.DelayFrom(now).Milliseconds()}
+		r.CancelAt(now)
+	}
+	return errcom.NewCustomErrorWithDetails(code, errors.New(reason), details)
+}
+
+func (s *chatService) GetRateLimit(ctx context.Context, id string) (*model.RateLimitResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(id)]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+
+	limiter := client.RateLimiter
+	return &model.RateLimitResponse{
+		ID:     client.ID,
+		Tokens: limiter.Tokens(),
+		Burst:  limiter.Burst(),
+		Rate:   float64(limiter.Limit()),
+	}, nil
+}
+
+func (s *chatService) GetUnread(ctx context.Context, id string) (*model.UnreadResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(id)]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	// Without a session token this reports the fullest session.
+	unread := client.buffered()
+	if token, ok := TokenFromContext(ctx); ok {
+		if sess := client.sessionByToken(token); sess != nil {
+			unread = sess.box.len()
+		}
+	}
+	return &model.UnreadResponse{
+		ID:     client.ID,
+		Unread: unread,
+	}, nil
+}
+
+func (s *chatService) Peek(ctx context.Context, req model.PeekRequest) (*model.PeekResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, ""); err != nil {
+		return nil, err
+	}
+	if req.Max <= 0 || req.Max > s.cfg.BufferSize+PriorityBufferSize {
+		req.Max = s.cfg.BufferSize + PriorityBufferSize
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(req.ID)]
+	s.mu.RUnlock()
+
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+
+	sess, err := s.sessionFor(ctx, client, "")
+	if err != nil {
+		return nil, err
+	}
+	queued, ok := sess.box.peek()
+	if !ok {
+		return nil, errcom.NewCustomError("ERR_USER_DISCONNECTED", errors.New("user stream closed"))
+	}
+	res := &model.PeekResponse{ID: client.ID, Queued: len(queued), Messages: []model.MessageResponse{}}
+	for _, msg := range queued {
+		if len(res.Messages) == req.Max {
+			break
+		}
+		if !s.expired(msg) {
+			res.Messages = append(res.Messages, toMessageResponse(msg))
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// newTestService builds a service from opts and closes it when the test
+// ends. It returns the service unwrapped from its tracing layer so tests
+// can reach its internals.
+func newTestService(t testing.TB, opts ...Option) *chatService {
+	t.Helper()
+	cs, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { cs.Close() })
+	return unwrap(cs)
+}
+
+func unwrap(cs ChatService) *chatService {
+	if t, ok := cs.(*tracedService); ok {
+		cs = t.ChatService
+	}
+	return cs.(*chatService)
+}
+
+// join joins id into room and returns its session token.
+func join(t testing.TB, s ChatService, id, room string) string {
+	t.Helper()
+	res, err := s.Join(context.Background(), model.JoinRequest{ID: id, Room: room})
+	if err != nil {
+		t.Fatalf("Join(%q): %v", id, err)
+	}
+	return res.Token
+}
+
+// receive waits briefly for the next message for the session holding token.
+func receive(s ChatService, id, token string) (*model.MessageResponse, error) {
+	return s.GetMessage(context.Background(), model.MessageRequest{ID: id, Token: token, Wait: "50ms"})
+}
+
+// wantCode fails the test unless err carries code.
+func wantCode(t testing.TB, err error, code string) {
+	t.Helper()
+	if got := errcom.Code(err); got != code {
+		t.Fatalf("error = %v, want code %s", err, code)
+	}
+}
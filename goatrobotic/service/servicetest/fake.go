@@ -0,0 +1,310 @@
+// Package servicetest provides a fake ChatService for testing code that
+// embeds the chat service, such as HTTP handlers.
+package servicetest
+
+import (
+	"context"
+	"sync"
+
+	"chatbox/model"
+	"chatbox/service"
+)
+
+// Call is one recorded call to a Fake: the method name and its request, or
+// its arguments for methods that take no request.
+type Call struct {
+	Method string
+	Req    any
+}
+
+// response is a canned result for a method.
+type response struct {
+	value any
+	err   error
+}
+
+// Fake is a ChatService that records every call and answers with the result
+// set by On, or an empty response and no error. It is safe for concurrent
+// use.
+type Fake struct {
+	mu        sync.Mutex
+	calls     []Call
+	responses map[string]response
+	draining  bool
+}
+
+var _ service.ChatService = (*Fake)(nil)
+
+// New returns a Fake with no canned responses.
+func New() *Fake {
+	return &Fake{responses: make(map[string]response)}
+}
+
+// On makes method answer with value and err. value must have the method's
+// result type, e.g. *model.SendMessageResponse for SendMessage, or
+// []model.MessageResponse for Stream, whose messages are passed to fn.
+// Passing errcom.NewCustomError("ERR_RATE_LIMIT", ...) as err simulates a
+// rate-limited send.
+func (f *Fake) On(method string, value any, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[method] = response{value: value, err: err}
+}
+
+// Calls returns the calls made so far, oldest first.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call{}, f.calls...)
+}
+
+// CallsTo returns the calls made to method so far, oldest first.
+func (f *Fake) CallsTo(method string) []Call {
+	var out []Call
+	for _, call := range f.Calls() {
+		if call.Method == method {
+			out = append(out, call)
+		}
+	}
+	return out
+}
+
+func (f *Fake) record(method string, req any) response {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, Call{Method: method, Req: req})
+	return f.responses[method]
+}
+
+// respond records the call and returns the canned result for method, or a
+// new zero T.
+func respond[T any](f *Fake, method string, req any) (*T, error) {
+	res := f.record(method, req)
+	if res.err != nil {
+		return nil, res.err
+	}
+	if v, ok := res.value.(*T); ok {
+		return v, nil
+	}
+	return new(T), nil
+}
+
+func (f *Fake) Join(ctx context.Context, req model.JoinRequest) (*model.JoinResponse, error) {
+	return respond[model.JoinResponse](f, "Join", req)
+}
+
+func (f *Fake) SendMessage(ctx context.Context, req model.SendMessageRequest) (*model.SendMessageResponse, error) {
+	return respond[model.SendMessageResponse](f, "SendMessage", req)
+}
+
+func (f *Fake) SendBatch(ctx context.Context, req model.SendBatchRequest) (*model.SendBatchResponse, error) {
+	return respond[model.SendBatchResponse](f, "SendBatch", req)
+}
+
+func (f *Fake) Leave(ctx context.Context, req model.LeaveRequest) (*model.LeaveResponse, error) {
+	return respond[model.LeaveResponse](f, "Leave", req)
+}
+
+func (f *Fake) Kick(ctx context.Context, req model.KickRequest) (*model.KickResponse, error) {
+	return respond[model.KickResponse](f, "Kick", req)
+}
+
+func (f *Fake) Ack(ctx context.Context, req model.AckRequest) (*model.AckResponse, error) {
+	return respond[model.AckResponse](f, "Ack", req)
+}
+
+func (f *Fake) GetReceipts(ctx context.Context, req model.ReceiptsRequest) (*model.ReceiptsResponse, error) {
+	return respond[model.ReceiptsResponse](f, "GetReceipts", req)
+}
+
+func (f *Fake) Rename(ctx context.Context, req model.RenameRequest) (*model.RenameResponse, error) {
+	return respond[model.RenameResponse](f, "Rename", req)
+}
+
+func (f *Fake) DisconnectAll(ctx context.Context, req model.DisconnectAllRequest) (*model.DisconnectAllResponse, error) {
+	return respond[model.DisconnectAllResponse](f, "DisconnectAll", req)
+}
+
+func (f *Fake) Announce(ctx context.Context, req model.AnnounceRequest) (*model.AnnounceResponse, error) {
+	return respond[model.AnnounceResponse](f, "Announce", req)
+}
+
+func (f *Fake) SetStatus(ctx context.Context, req model.StatusRequest) (*model.StatusResponse, error) {
+	return respond[model.StatusResponse](f, "SetStatus", req)
+}
+
+func (f *Fake) Ping(ctx context.Context, req model.PingRequest) (*model.PingResponse, error) {
+	return respond[model.PingResponse](f, "Ping", req)
+}
+
+func (f *Fake) Typing(ctx context.Context, req model.TypingRequest) (*model.TypingResponse, error) {
+	return respond[model.TypingResponse](f, "Typing", req)
+}
+
+func (f *Fake) Ban(ctx context.Context, req model.BanRequest) (*model.BanResponse, error) {
+	return respond[model.BanResponse](f, "Ban", req)
+}
+
+func (f *Fake) Unban(ctx context.Context, req model.BanRequest) (*model.BanResponse, error) {
+	return respond[model.BanResponse](f, "Unban", req)
+}
+
+func (f *Fake) GetMessage(ctx context.Context, req model.MessageRequest) (*model.MessageResponse, error) {
+	return respond[model.MessageResponse](f, "GetMessage", req)
+}
+
+func (f *Fake) GetMessages(ctx context.Context, req model.MessagesRequest) (*model.MessagesResponse, error) {
+	return respond[model.MessagesResponse](f, "GetMessages", req)
+}
+
+func (f *Fake) ReceiveMulti(ctx context.Context, req model.MultiReceiveRequest) (*model.MultiReceiveResponse, error) {
+	return respond[model.MultiReceiveResponse](f, "ReceiveMulti", req)
+}
+
+func (f *Fake) Stream(ctx context.Context, id string, fn func(model.MessageResponse) error) error {
+	res := f.record("Stream", id)
+	msgs, _ := res.value.([]model.MessageResponse)
+	for _, msg := range msgs {
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return res.err
+}
+
+func (f *Fake) StartHeartbeat(ctx context.Context, id, token string) error {
+	return f.record("StartHeartbeat", id).err
+}
+
+func (f *Fake) Authenticate(ctx context.Context, token string) (string, error) {
+	res := f.record("Authenticate", token)
+	id, _ := res.value.(string)
+	return id, res.err
+}
+
+func (f *Fake) EffectiveConfig(ctx context.Context) map[string]any {
+	m, _ := f.record("EffectiveConfig", nil).value.(map[string]any)
+	return m
+}
+
+func (f *Fake) SetDraining(draining bool) {
+	f.record("SetDraining", draining)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.draining = draining
+}
+
+func (f *Fake) Draining() bool {
+	f.record("Draining", nil)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.draining
+}
+
+func (f *Fake) GetUserRooms(ctx context.Context, id string) (*model.UserRoomsResponse, error) {
+	return respond[model.UserRoomsResponse](f, "GetUserRooms", id)
+}
+
+func (f *Fake) GetUsers(ctx context.Context, req model.UsersRequest) (*model.UsersResponse, error) {
+	return respond[model.UsersResponse](f, "GetUsers", req)
+}
+
+func (f *Fake) GetHistory(ctx context.Context, req model.HistoryRequest) (*model.HistoryResponse, error) {
+	return respond[model.HistoryResponse](f, "GetHistory", req)
+}
+
+func (f *Fake) Search(ctx context.Context, req model.SearchRequest) (*model.HistoryResponse, error) {
+	return respond[model.HistoryResponse](f, "Search", req)
+}
+
+func (f *Fake) ExportRoom(ctx context.Context, room string) (*model.HistoryResponse, error) {
+	return respond[model.HistoryResponse](f, "ExportRoom", room)
+}
+
+func (f *Fake) ListRooms(ctx context.Context, req model.ListRoomsRequest) (*model.ListRoomsResponse, error) {
+	return respond[model.ListRoomsResponse](f, "ListRooms", req)
+}
+
+func (f *Fake) SnapshotStats(reset bool) model.StatsSnapshot {
+	s, _ := f.record("SnapshotStats", reset).value.(model.StatsSnapshot)
+	return s
+}
+
+func (f *Fake) Stats(ctx context.Context) model.ServiceStats {
+	s, _ := f.record("Stats", nil).value.(model.ServiceStats)
+	return s
+}
+
+func (f *Fake) ListQuarantine(ctx context.Context) []model.QuarantinedMessage {
+	q, _ := f.record("ListQuarantine", nil).value.([]model.QuarantinedMessage)
+	return q
+}
+
+func (f *Fake) Report(ctx context.Context, req model.ReportRequest) (*model.ReportResponse, error) {
+	return respond[model.ReportResponse](f, "Report", req)
+}
+
+func (f *Fake) SetModerator(ctx context.Context, req model.ModeratorRequest) (*model.ModeratorResponse, error) {
+	return respond[model.ModeratorResponse](f, "SetModerator", req)
+}
+
+func (f *Fake) ListReports(ctx context.Context) []model.Report {
+	r, _ := f.record("ListReports", nil).value.([]model.Report)
+	return r
+}
+
+func (f *Fake) Moderate(ctx context.Context, req model.ModerateRequest) (*model.ModerateResponse, error) {
+	return respond[model.ModerateResponse](f, "Moderate", req)
+}
+
+func (f *Fake) SetRoomLimits(ctx context.Context, req model.RoomLimitsRequest) (*model.RoomLimitsResponse, error) {
+	return respond[model.RoomLimitsResponse](f, "SetRoomLimits", req)
+}
+
+func (f *Fake) GetDeadLetters(ctx context.Context, id string) (*model.MessagesResponse, error) {
+	return respond[model.MessagesResponse](f, "GetDeadLetters", id)
+}
+
+func (f *Fake) RegisterCommand(name string, fn service.CommandFunc) {
+	f.record("RegisterCommand", name)
+}
+
+// Subscribe returns a channel that receives nothing until cancel closes it.
+func (f *Fake) Subscribe() (<-chan model.ChatEvent, func()) {
+	f.record("Subscribe", nil)
+	ch := make(chan model.ChatEvent)
+	var once sync.Once
+	return ch, func() { once.Do(func() { close(ch) }) }
+}
+
+func (f *Fake) Block(ctx context.Context, req model.BlockRequest) (*model.BlockResponse, error) {
+	return respond[model.BlockResponse](f, "Block", req)
+}
+
+func (f *Fake) Unblock(ctx context.Context, req model.BlockRequest) (*model.BlockResponse, error) {
+	return respond[model.BlockResponse](f, "Unblock", req)
+}
+
+func (f *Fake) SetAttributes(ctx context.Context, req model.AttributesRequest) (*model.AttributesResponse, error) {
+	return respond[model.AttributesResponse](f, "SetAttributes", req)
+}
+
+func (f *Fake) GetRateLimit(ctx context.Context, id string) (*model.RateLimitResponse, error) {
+	return respond[model.RateLimitResponse](f, "GetRateLimit", id)
+}
+
+func (f *Fake) GetUnread(ctx context.Context, id string) (*model.UnreadResponse, error) {
+	return respond[model.UnreadResponse](f, "GetUnread", id)
+}
+
+func (f *Fake) Peek(ctx context.Context, req model.PeekRequest) (*model.PeekResponse, error) {
+	return respond[model.PeekResponse](f, "Peek", req)
+}
+
+func (f *Fake) Close() error {
+	return f.record("Close", nil).err
+}
+
+func (f *Fake) Shutdown(ctx context.Context) error {
+	return f.record("Shutdown", nil).err
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"slices"
+	"sync/atomic"
+
+	errcom "chatbox/error"
+)
+
+// session is one connection of a client, such as one of the devices a user
+// is signed in on. Each has its own token and mailbox, so every session
+// receives every message sent to the client.
+type session struct {
+	token string
+	box   *mailbox
+	// receiving is set while a receive is waiting on box.
+	receiving atomic.Bool
+}
+
+// newSession returns a session whose mailbox counts its messages in usage.
+func newSession(bufferSize int, usage *atomic.Int64) *session {
+	return &session{
+		token: newToken(),
+		box:   newMailbox(bufferSize, PriorityBufferSize, usage),
+	}
+}
+
+// sessionList returns the client's sessions, oldest first. The slice is
+// replaced rather than modified, so it may be read after c.sessionsMu is
+// released.
+func (c *Client) sessionList() []*session {
+	c.sessionsMu.Lock()
+	defer c.sessionsMu.Unlock()
+	return c.sessions
+}
+
+// addSession adds sess to the client unless it already has max sessions.
+func (c *Client) addSession(sess *session, max int) bool {
+	c.sessionsMu.Lock()
+	defer c.sessionsMu.Unlock()
+	if len(c.sessions) >= max {
+		return false
+	}
+	c.sessions = append(slices.Clip(c.sessions), sess)
+	return true
+}
+
+// removeSession removes sess from the client and reports how many sessions
+// remain.
+func (c *Client) removeSession(sess *session) int {
+	c.sessionsMu.Lock()
+	defer c.sessionsMu.Unlock()
+	c.sessions = slices.DeleteFunc(slices.Clone(c.sessions), func(other *session) bool {
+		return other == sess
+	})
+	return len(c.sessions)
+}
+
+// sessionByToken returns the session token was issued to, or nil.
+func (c *Client) sessionByToken(token string) *session {
+	for _, sess := range c.sessionList() {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(sess.token)) == 1 {
+			return sess
+		}
+	}
+	return nil
+}
+
+// buffered counts the messages queued in the client's fullest session.
+func (c *Client) buffered() int {
+	n := 0
+	for _, sess := range c.sessionList() {
+		n = max(n, sess.box.len())
+	}
+	return n
+}
+
+// sessionFor returns the session of client that token, or the token carried
+// by ctx when token is empty, was issued to.
+func (s *chatService) sessionFor(ctx context.Context, client *Client, token string) (*session, error) {
+	if token == "" {
+		token, _ = TokenFromContext(ctx)
+	}
+	if sess := client.sessionByToken(token); sess != nil {
+		return sess, nil
+	}
+	return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("session not connected"))
+}
+
+// ownsSession reports whether the caller may open another session for
+// client: it presents the token of one of client's sessions, or ctx carries
+// the authenticated identity, which checkIdentity has already matched.
+func (s *chatService) ownsSession(ctx context.Context, client *Client, token string) bool {
+	if _, ok := IdentityFromContext(ctx); ok {
+		return true
+	}
+	if token == "" {
+		token, _ = TokenFromContext(ctx)
+	}
+	return client.sessionByToken(token) != nil
+}
+
+// claimSession marks a receive as waiting on sess, failing with
+// ERR_ALREADY_RECEIVING if another already is.
+func claimSession(sess *session) error {
+	if !sess.receiving.CompareAndSwap(false, true) {
+		return errcom.NewCustomError("ERR_ALREADY_RECEIVING", errors.New("another receive is already waiting for this user"))
+	}
+	return nil
+}
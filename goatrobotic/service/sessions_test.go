@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chatbox/model"
+)
+
+func TestJoinSecondSessionRequiresOwnership(t *testing.T) {
+	s := newTestService(t, WithMaxSessions(2))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	receive(s, "alice", alice) // bob's join notice
+
+	_, err := s.Join(ctx, model.JoinRequest{ID: "alice", Room: "lobby"})
+	wantCode(t, err, "ERR_ALREADY_JOINED")
+	_, err = s.Join(ctx, model.JoinRequest{ID: "alice", Room: "lobby", Token: "forged", AddSession: true})
+	wantCode(t, err, "ERR_ALREADY_JOINED")
+
+	res, err := s.Join(ctx, model.JoinRequest{ID: "alice", Room: "lobby", Token: alice, AddSession: true})
+	if err != nil {
+		t.Fatalf("Join with AddSession: %v", err)
+	}
+	second := res.Token
+	if second == alice {
+		t.Fatal("AddSession resumed the existing session instead of opening one")
+	}
+
+	if _, err := s.SendMessage(ctx, model.SendMessageRequest{From: "bob", Token: bob, To: "alice", Message: "secret"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	for _, token := range []string{alice, second} {
+		msg, err := receive(s, "alice", token)
+		if err != nil || msg.Text != "secret" {
+			t.Fatalf("session receive = %+v, %v; want the DM", msg, err)
+		}
+	}
+}
+
+func TestJoinSecondSessionWithIdentity(t *testing.T) {
+	s := newTestService(t, WithMaxSessions(2))
+	join(t, s, "alice", "lobby")
+	ctx := WithIdentity(context.Background(), "alice")
+	if _, err := s.Join(ctx, model.JoinRequest{ID: "alice", Room: "lobby"}); err != nil {
+		t.Fatalf("authenticated Join: %v", err)
+	}
+	_, err := s.Join(ctx, model.JoinRequest{ID: "alice", Room: "lobby"})
+	wantCode(t, err, "ERR_TOO_MANY_SESSIONS")
+}
+
+func TestLeaveClosesOneSession(t *testing.T) {
+	s := newTestService(t, WithMaxSessions(2))
+	ctx := context.Background()
+	first := join(t, s, "alice", "lobby")
+	res, err := s.Join(ctx, model.JoinRequest{ID: "alice", Room: "lobby", Token: first, AddSession: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Leave(ctx, model.LeaveRequest{ID: "alice", Token: first}); err != nil {
+		t.Fatalf("Leave: %v", err)
+	}
+	users, _ := s.GetUsers(ctx, model.UsersRequest{})
+	if len(users.Users) != 1 || users.Users[0].Sessions != 1 {
+		t.Fatalf("users after one session left = %+v, want alice with 1 session", users.Users)
+	}
+	if _, err := s.Leave(ctx, model.LeaveRequest{ID: "alice", Token: res.Token}); err != nil {
+		t.Fatalf("Leave: %v", err)
+	}
+	users, _ = s.GetUsers(ctx, model.UsersRequest{})
+	if len(users.Users) != 0 {
+		t.Fatalf("users after last session left = %+v, want none", users.Users)
+	}
+}
+
+// TestLeaveTwice leaves the same user twice, directly and after the cleanup
+// loop evicted them. The second leave must report ERR_USER_NOT_FOUND rather
+// than close the stream again.
+func TestLeaveTwice(t *testing.T) {
+	t.Run("leave", func(t *testing.T) {
+		s := newTestService(t)
+		ctx := context.Background()
+		alice := join(t, s, "alice", "lobby")
+		if _, err := s.Leave(ctx, model.LeaveRequest{ID: "alice", Token: alice}); err != nil {
+			t.Fatalf("first Leave: %v", err)
+		}
+		_, err := s.Leave(ctx, model.LeaveRequest{ID: "alice", Token: alice})
+		wantCode(t, err, "ERR_USER_NOT_FOUND")
+	})
+	t.Run("after eviction", func(t *testing.T) {
+		clock := NewFakeClock(epoch)
+		s := newTestService(t, WithClock(clock), WithCleanupInterval(time.Minute), WithIdleTimeout(time.Minute))
+		alice := join(t, s, "alice", "lobby")
+		waitForTimers(t, clock, 1)
+		clock.Advance(2 * time.Minute)
+		if connected(s, "alice") {
+			t.Fatal("client still connected after its idle timeout")
+		}
+		_, err := s.Leave(context.Background(), model.LeaveRequest{ID: "alice", Token: alice})
+		wantCode(t, err, "ERR_USER_NOT_FOUND")
+	})
+}
+
+func TestConcurrentReceive(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	receive(s, "alice", alice) // bob's join notice
+
+	type result struct {
+		msg *model.MessageResponse
+		err error
+	}
+	results := make(chan result, 2)
+	start := make(chan struct{})
+	for range 2 {
+		go func() {
+			<-start
+			msg, err := s.GetMessage(ctx, model.MessageRequest{ID: "alice", Token: alice, Wait: "2s"})
+			results <- result{msg, err}
+		}()
+	}
+	close(start)
+
+	// The loser is turned away at once, before anything is sent.
+	first := <-results
+	wantCode(t, first.err, "ERR_ALREADY_RECEIVING")
+	if _, err := s.SendMessage(ctx, model.SendMessageRequest{From: "bob", Token: bob, To: "alice", Message: "hi"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	second := <-results
+	if second.err != nil || second.msg.Text != "hi" {
+		t.Fatalf("winning receive = %+v, %v; want the message", second.msg, second.err)
+	}
+}
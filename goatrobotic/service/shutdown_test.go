@@ -0,0 +1,292 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// TestShutdownStopsGoroutines runs every background loop the service has,
+// then checks that Shutdown leaves no goroutine behind.
+func TestShutdownStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	cs, err := New(WithCleanupInterval(time.Millisecond), WithFairDelivery(true))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	s := unwrap(cs)
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	if err := s.StartHeartbeat(ctx, "alice", alice); err != nil {
+		t.Fatalf("StartHeartbeat: %v", err)
+	}
+	if _, err := s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, Message: "hi"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	receiving := make(chan struct{})
+	go func() {
+		defer close(receiving)
+		s.GetMessage(ctx, model.MessageRequest{ID: "bob", Token: bob, Wait: "1m"})
+		s.GetMessage(ctx, model.MessageRequest{ID: "bob", Token: bob, Wait: "1m"})
+		s.GetMessage(ctx, model.MessageRequest{ID: "bob", Token: bob, Wait: "1m"})
+	}()
+	streaming := make(chan struct{})
+	go func() {
+		defer close(streaming)
+		s.Stream(WithToken(ctx, alice), "alice", func(model.MessageResponse) error { return nil })
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	<-receiving
+	<-streaming
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines after Shutdown, want at most %d:\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCallsAfterShutdown(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	token := join(t, s, "alice", "lobby")
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	calls := map[string]func() error{
+		"Join": func() error {
+			_, err := s.Join(ctx, model.JoinRequest{ID: "bob"})
+			return err
+		},
+		"SendMessage": func() error {
+			_, err := s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: token, Message: "hi"})
+			return err
+		},
+		"SendBatch": func() error {
+			_, err := s.SendBatch(ctx, model.SendBatchRequest{From: "alice", Token: token, Messages: []string{"hi"}})
+			return err
+		},
+		"Leave": func() error {
+			_, err := s.Leave(ctx, model.LeaveRequest{ID: "alice", Token: token})
+			return err
+		},
+		"Kick": func() error {
+			_, err := s.Kick(ctx, model.KickRequest{ID: "alice"})
+			return err
+		},
+		"DisconnectAll": func() error {
+			_, err := s.DisconnectAll(ctx, model.DisconnectAllRequest{})
+			return err
+		},
+		"Announce": func() error {
+			_, err := s.Announce(ctx, model.AnnounceRequest{Message: "hi"})
+			return err
+		},
+		"Ack": func() error {
+			_, err := s.Ack(ctx, model.AckRequest{ID: "alice", MessageID: "1"})
+			return err
+		},
+		"GetReceipts": func() error {
+			_, err := s.GetReceipts(ctx, model.ReceiptsRequest{ID: "alice", MessageID: "1"})
+			return err
+		},
+		"Rename": func() error {
+			_, err := s.Rename(ctx, model.RenameRequest{ID: "alice", Name: "Alice"})
+			return err
+		},
+		"SetStatus": func() error {
+			_, err := s.SetStatus(ctx, model.StatusRequest{ID: "alice"})
+			return err
+		},
+		"Ping": func() error {
+			_, err := s.Ping(ctx, model.PingRequest{ID: "alice"})
+			return err
+		},
+		"Typing": func() error {
+			_, err := s.Typing(ctx, model.TypingRequest{ID: "alice"})
+			return err
+		},
+		"Ban": func() error {
+			_, err := s.Ban(ctx, model.BanRequest{ID: "bob"})
+			return err
+		},
+		"Unban": func() error {
+			_, err := s.Unban(ctx, model.BanRequest{ID: "bob"})
+			return err
+		},
+		"GetMessage": func() error {
+			_, err := s.GetMessage(ctx, model.MessageRequest{ID: "alice", Token: token})
+			return err
+		},
+		"GetMessages": func() error {
+			_, err := s.GetMessages(ctx, model.MessagesRequest{ID: "alice"})
+			return err
+		},
+		"ReceiveMulti": func() error {
+			_, err := s.ReceiveMulti(ctx, model.MultiReceiveRequest{IDs: []string{"alice"}})
+			return err
+		},
+		"Stream": func() error {
+			return s.Stream(ctx, "alice", func(model.MessageResponse) error { return nil })
+		},
+		"StartHeartbeat": func() error {
+			return s.StartHeartbeat(ctx, "alice", token)
+		},
+		"GetUserRooms": func() error {
+			_, err := s.GetUserRooms(ctx, "alice")
+			return err
+		},
+		"GetUsers": func() error {
+			_, err := s.GetUsers(ctx, model.UsersRequest{})
+			return err
+		},
+		"GetHistory": func() error {
+			_, err := s.GetHistory(ctx, model.HistoryRequest{Room: "lobby"})
+			return err
+		},
+		"Search": func() error {
+			_, err := s.Search(ctx, model.SearchRequest{Room: "lobby", Query: "hi"})
+			return err
+		},
+		"ExportRoom": func() error {
+			_, err := s.ExportRoom(ctx, "lobby")
+			return err
+		},
+		"ListRooms": func() error {
+			_, err := s.ListRooms(ctx, model.ListRoomsRequest{})
+			return err
+		},
+		"Moderate": func() error {
+			_, err := s.Moderate(ctx, model.ModerateRequest{ID: "1"})
+			return err
+		},
+		"Report": func() error {
+			_, err := s.Report(ctx, model.ReportRequest{From: "alice", MessageID: "1"})
+			return err
+		},
+		"SetModerator": func() error {
+			_, err := s.SetModerator(ctx, model.ModeratorRequest{ID: "alice"})
+			return err
+		},
+		"SetRoomLimits": func() error {
+			_, err := s.SetRoomLimits(ctx, model.RoomLimitsRequest{Room: "lobby"})
+			return err
+		},
+		"GetDeadLetters": func() error {
+			_, err := s.GetDeadLetters(ctx, "alice")
+			return err
+		},
+		"Block": func() error {
+			_, err := s.Block(ctx, model.BlockRequest{ID: "alice", Target: "bob"})
+			return err
+		},
+		"Unblock": func() error {
+			_, err := s.Unblock(ctx, model.BlockRequest{ID: "alice", Target: "bob"})
+			return err
+		},
+		"SetAttributes": func() error {
+			_, err := s.SetAttributes(ctx, model.AttributesRequest{ID: "alice"})
+			return err
+		},
+		"GetRateLimit": func() error {
+			_, err := s.GetRateLimit(ctx, "alice")
+			return err
+		},
+		"GetUnread": func() error {
+			_, err := s.GetUnread(ctx, "alice")
+			return err
+		},
+		"Peek": func() error {
+			_, err := s.Peek(ctx, model.PeekRequest{ID: "alice"})
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			err := call()
+			wantCode(t, err, "ERR_SERVICE_UNAVAILABLE")
+			if !errors.Is(err, errcom.ErrServiceUnavailable) {
+				t.Errorf("errors.Is(%v, ErrServiceUnavailable) = false", err)
+			}
+			if got := errcom.HTTPStatus(err); got != 503 {
+				t.Errorf("HTTPStatus = %d, want 503", got)
+			}
+		})
+	}
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("second Shutdown: %v", err)
+	}
+}
+
+// TestCloseWakesBlockedReceive checks that receives waiting when the service
+// closes return ERR_SERVER_SHUTTING_DOWN straight away rather than running
+// out their wait.
+func TestCloseWakesBlockedReceive(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	bob := join(t, s, "bob", "lobby")
+	receive(s, "alice", alice) // bob's join notice
+
+	done := make(chan error, 2)
+	go func() {
+		_, err := s.GetMessage(ctx, model.MessageRequest{ID: "alice", Token: alice, Wait: "1m"})
+		done <- err
+	}()
+	go func() {
+		done <- s.Stream(WithToken(ctx, bob), "bob", func(model.MessageResponse) error { return nil })
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	s.Close()
+	for range 2 {
+		select {
+		case err := <-done:
+			wantCode(t, err, "ERR_SERVER_SHUTTING_DOWN")
+			if !errors.Is(err, errcom.ErrServerShuttingDown) {
+				t.Errorf("errors.Is(%v, ErrServerShuttingDown) = false", err)
+			}
+			if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+				t.Errorf("receive returned %v after Close, want within 100ms", elapsed)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("receive still blocked a second after Close")
+		}
+	}
+}
+
+// TestShutdownCodes checks that a receive cut short by Close and a receive
+// made after it fail with their own codes.
+func TestShutdownCodes(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := s.GetMessage(ctx, model.MessageRequest{ID: "alice", Token: alice, Wait: "1m"})
+		blocked <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+
+	wantCode(t, <-blocked, "ERR_SERVER_SHUTTING_DOWN")
+	_, err := s.GetMessage(ctx, model.MessageRequest{ID: "alice", Token: alice, Wait: "1m"})
+	wantCode(t, err, "ERR_SERVICE_UNAVAILABLE")
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"chatbox/model"
+)
+
+// Snapshotter is implemented by Stores whose history can be saved to
+// Config.SnapshotPath on shutdown and restored from it on startup.
+type Snapshotter interface {
+	// Snapshot returns each room's history, oldest first.
+	Snapshot() map[string][]model.Message
+	// Restore replaces the history with rooms.
+	Restore(rooms map[string][]model.Message)
+}
+
+// snapshotVersion is the version of the snapshot file format written by
+// saveSnapshot.
+const snapshotVersion = 1
+
+// snapshotFile is the JSON document kept at Config.SnapshotPath.
+type snapshotFile struct {
+	Version int                        `json:"version"`
+	Rooms   map[string][]model.Message `json:"rooms"`
+}
+
+// loadSnapshot restores history from cfg.SnapshotPath. A missing file is not
+// an error. A file that cannot be read is moved aside to <path>.corrupt so the
+// next save does not overwrite it, and history starts empty.
+func (s *chatService) loadSnapshot() error {
+	st, ok := s.cfg.Store.(Snapshotter)
+	if s.cfg.SnapshotPath == "" || !ok {
+		return nil
+	}
+	data, err := os.ReadFile(s.cfg.SnapshotPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshotFile
+	if err := json.Unmarshal(data, &snap); err != nil || snap.Version != snapshotVersion {
+		if err == nil {
+			err = fmt.Errorf("unknown snapshot version %d", snap.Version)
+		}
+		os.Rename(s.cfg.SnapshotPath, s.cfg.SnapshotPath+".corrupt")
+		return fmt.Errorf("snapshot %s: %w", s.cfg.SnapshotPath, err)
+	}
+	st.Restore(snap.Rooms)
+	// New messages continue after the restored ones so cursors still work.
+	for _, msgs := range snap.Rooms {
+		for _, msg := range msgs {
+			s.observeMessageID(msg.ID)
+		}
+	}
+	return nil
+}
+
+// saveSnapshot writes the history to cfg.SnapshotPath through a temporary
+// file, so a crash mid-save leaves the previous snapshot intact.
+func (s *chatService) saveSnapshot() error {
+	st, ok := s.cfg.Store.(Snapshotter)
+	if s.cfg.SnapshotPath == "" || !ok {
+		return nil
+	}
+	data, err := json.Marshal(snapshotFile{Version: snapshotVersion, Rooms: st.Snapshot()})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.cfg.SnapshotPath), filepath.Base(s.cfg.SnapshotPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.cfg.SnapshotPath)
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"chatbox/model"
+)
+
+func TestStats(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	s := newTestService(t, WithClock(clock), WithBufferSize(1), WithRateLimit(rate.Every(time.Hour), 2))
+	ctx := context.Background()
+	alice := join(t, s, "alice", "lobby")
+	join(t, s, "bob", "lobby")
+	carol := join(t, s, "carol", "kitchen")
+
+	// bob's buffer takes the first message and drops the second; the third
+	// is over alice's rate limit.
+	for i := range 3 {
+		_, err := s.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, Message: "hi"})
+		if i == 2 {
+			wantCode(t, err, "ERR_RATE_LIMIT")
+		} else if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+	clock.Advance(90 * time.Second)
+
+	want := model.ServiceStats{Clients: 3, Rooms: 2, MessagesSent: 2, Dropped: 1, RateLimited: 1, UptimeSeconds: 90}
+	if got := s.Stats(ctx); got != want {
+		t.Fatalf("Stats = %+v, want %+v", got, want)
+	}
+
+	if _, err := s.Leave(ctx, model.LeaveRequest{ID: "carol", Token: carol}); err != nil {
+		t.Fatal(err)
+	}
+	s.SnapshotStats(true)
+	want = model.ServiceStats{Clients: 2, Rooms: 1, UptimeSeconds: 90}
+	if got := s.Stats(ctx); got != want {
+		t.Fatalf("Stats after a leave and reset = %+v, want %+v", got, want)
+	}
+}
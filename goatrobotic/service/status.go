@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// SetStatus changes a client's presence status and announces it to the room.
+// An explicit status is kept until changed again, even across inactivity.
+func (s *chatService) SetStatus(ctx context.Context, req model.StatusRequest) (*model.StatusResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+	if req.Status != model.StatusOnline && req.Status != model.StatusAway {
+		return nil, errcom.NewCustomError("ERR_INVALID_STATUS", errors.New("status must be online or away"))
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, req.Token); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, exists := s.streams[s.key(req.ID)]
+	if !exists {
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	client.autoAway = false
+	s.setStatus(client, req.Status)
+	return &model.StatusResponse{
+		Success: true,
+		Message: "Status set to " + req.Status,
+	}, nil
+}
+
+// setStatus records status for client and, if it changed, tells the rest of
+// its room. The caller must hold s.mu.
+func (s *chatService) setStatus(client *Client, status string) {
+	if client.Status == status {
+		return
+	}
+	client.Status = status
+	notice := s.presenceMessage(model.PresenceStatus, client, s.participants(client.Room))
+	notice.Text = "* " + client.Name + " is now " + status
+	s.announce(notice, client)
+}
+
+// updateAway marks client away once it has been inactive for
+// cfg.AwayAfter, and online again when it comes back. Statuses set
+// explicitly are left alone. The caller must hold s.mu.
+func (s *chatService) updateAway(client *Client, now time.Time) {
+	if s.cfg.AwayAfter <= 0 {
+		return
+	}
+	idle := now.Sub(client.LastSeen()) > s.cfg.AwayAfter
+	switch {
+	case idle && client.Status == model.StatusOnline:
+		client.autoAway = true
+		s.setStatus(client, model.StatusAway)
+	case !idle && client.autoAway:
+		client.autoAway = false
+		s.setStatus(client, model.StatusOnline)
+	}
+}
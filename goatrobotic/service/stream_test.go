@@ -0,0 +1,20 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chatbox/model"
+)
+
+func TestStreamTinyIdleTimeout(t *testing.T) {
+	s := newTestService(t, WithIdleTimeout(time.Nanosecond))
+	alice := join(t, s, "alice", "lobby")
+	ctx, cancel := context.WithTimeout(WithToken(context.Background(), alice), 20*time.Millisecond)
+	defer cancel()
+	err := s.Stream(ctx, "alice", func(model.MessageResponse) error { return nil })
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Stream = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedService records a span named chat.<Method> around each user-facing
+// call of the service it wraps. Administrative and introspection methods are
+// passed through untraced.
+type tracedService struct {
+	ChatService
+	tracer trace.Tracer
+}
+
+// traced wraps s so its calls are traced with tracer.
+func traced(s ChatService, tracer trace.Tracer) ChatService {
+	return &tracedService{ChatService: s, tracer: tracer}
+}
+
+func (t *tracedService) start(ctx context.Context, method, id string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("chat.user_id", id))
+	return t.tracer.Start(ctx, "chat."+method, trace.WithAttributes(attrs...))
+}
+
+// end records err, with its error code, on span and ends it.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.SetAttributes(attribute.String("chat.error_code", errcom.Code(err)))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *tracedService) Join(ctx context.Context, req model.JoinRequest) (*model.JoinResponse, error) {
+	ctx, span := t.start(ctx, "Join", req.ID, attribute.String("chat.room", req.Room))
+	res, err := t.ChatService.Join(ctx, req)
+	if err == nil {
+		span.SetAttributes(attribute.String("chat.room", res.Room))
+	}
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) SendMessage(ctx context.Context, req model.SendMessageRequest) (*model.SendMessageResponse, error) {
+	ctx, span := t.start(ctx, "SendMessage", req.From)
+	res, err := t.ChatService.SendMessage(ctx, req)
+	if err == nil {
+		span.SetAttributes(
+			attribute.Int("chat.delivered", res.Delivered),
+			attribute.Int("chat.dropped", res.Dropped),
+		)
+	}
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) SendBatch(ctx context.Context, req model.SendBatchRequest) (*model.SendBatchResponse, error) {
+	ctx, span := t.start(ctx, "SendBatch", req.From, attribute.Int("chat.batch_size", len(req.Messages)))
+	res, err := t.ChatService.SendBatch(ctx, req)
+	if err == nil {
+		span.SetAttributes(
+			attribute.Int("chat.sent", res.Sent),
+			attribute.Int("chat.rejected", res.Rejected),
+		)
+	}
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) Leave(ctx context.Context, req model.LeaveRequest) (*model.LeaveResponse, error) {
+	ctx, span := t.start(ctx, "Leave", req.ID, attribute.String("chat.room", req.Room))
+	res, err := t.ChatService.Leave(ctx, req)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) Kick(ctx context.Context, req model.KickRequest) (*model.KickResponse, error) {
+	ctx, span := t.start(ctx, "Kick", req.ID)
+	res, err := t.ChatService.Kick(ctx, req)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) SetStatus(ctx context.Context, req model.StatusRequest) (*model.StatusResponse, error) {
+	ctx, span := t.start(ctx, "SetStatus", req.ID, attribute.String("chat.status", req.Status))
+	res, err := t.ChatService.SetStatus(ctx, req)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) Ping(ctx context.Context, req model.PingRequest) (*model.PingResponse, error) {
+	ctx, span := t.start(ctx, "Ping", req.ID)
+	res, err := t.ChatService.Ping(ctx, req)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) Typing(ctx context.Context, req model.TypingRequest) (*model.TypingResponse, error) {
+	ctx, span := t.start(ctx, "Typing", req.ID)
+	res, err := t.ChatService.Typing(ctx, req)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) Report(ctx context.Context, req model.ReportRequest) (*model.ReportResponse, error) {
+	ctx, span := t.start(ctx, "Report", req.From)
+	res, err := t.ChatService.Report(ctx, req)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) GetMessage(ctx context.Context, req model.MessageRequest) (*model.MessageResponse, error) {
+	ctx, span := t.start(ctx, "GetMessage", req.ID, attribute.String("chat.room", req.Room))
+	res, err := t.ChatService.GetMessage(ctx, req)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) GetMessages(ctx context.Context, req model.MessagesRequest) (*model.MessagesResponse, error) {
+	ctx, span := t.start(ctx, "GetMessages", req.ID)
+	res, err := t.ChatService.GetMessages(ctx, req)
+	if err == nil {
+		span.SetAttributes(attribute.Int("chat.messages", len(res.Messages)))
+	}
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) ReceiveMulti(ctx context.Context, req model.MultiReceiveRequest) (*model.MultiReceiveResponse, error) {
+	ctx, span := t.start(ctx, "ReceiveMulti", "", attribute.Int("chat.users", len(req.IDs)))
+	res, err := t.ChatService.ReceiveMulti(ctx, req)
+	if err == nil {
+		span.SetAttributes(attribute.Int("chat.not_found", len(res.NotFound)))
+	}
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) Stream(ctx context.Context, id string, fn func(model.MessageResponse) error) error {
+	ctx, span := t.start(ctx, "Stream", id)
+	err := t.ChatService.Stream(ctx, id, fn)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) Block(ctx context.Context, req model.BlockRequest) (*model.BlockResponse, error) {
+	ctx, span := t.start(ctx, "Block", req.ID)
+	res, err := t.ChatService.Block(ctx, req)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) Unblock(ctx context.Context, req model.BlockRequest) (*model.BlockResponse, error) {
+	ctx, span := t.start(ctx, "Unblock", req.ID)
+	res, err := t.ChatService.Unblock(ctx, req)
+	end(span, err)
+	return res, err
+}
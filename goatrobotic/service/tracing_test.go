@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"chatbox/model"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttrs returns the attributes of span by key.
+func spanAttrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracingSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	cs, err := New(WithTracer(provider.Tracer("test")))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { cs.Close() })
+
+	// The caller's span is the parent of the service's.
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	alice := join(t, cs, "alice", "lobby")
+	join(t, cs, "bob", "lobby")
+	if _, err := cs.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: alice, Message: "hi"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	_, err = cs.SendMessage(ctx, model.SendMessageRequest{From: "alice", Token: "wrong", Message: "hi"})
+	wantCode(t, err, "ERR_UNAUTHORIZED")
+	parent.End()
+	// Introspection is not traced.
+	cs.Stats(ctx)
+
+	spans := recorder.Ended()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	want := []string{"chat.Join", "chat.Join", "chat.SendMessage", "chat.SendMessage", "request"}
+	if len(names) != len(want) {
+		t.Fatalf("spans = %q, want %q", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("spans = %q, want %q", names, want)
+		}
+	}
+
+	joined := spanAttrs(spans[0])
+	if joined["chat.user_id"].AsString() != "alice" || joined["chat.room"].AsString() != "lobby" {
+		t.Errorf("Join attributes = %v", joined)
+	}
+
+	sent, failed := spans[2], spans[3]
+	if sent.Parent().SpanID() != parent.SpanContext().SpanID() || sent.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Error("SendMessage span is not a child of the caller's span")
+	}
+	attrs := spanAttrs(sent)
+	if attrs["chat.user_id"].AsString() != "alice" || attrs["chat.delivered"].AsInt64() != 1 || attrs["chat.dropped"].AsInt64() != 0 {
+		t.Errorf("SendMessage attributes = %v", attrs)
+	}
+	if sent.Status().Code == codes.Error {
+		t.Errorf("successful send has status %v", sent.Status())
+	}
+
+	attrs = spanAttrs(failed)
+	if attrs["chat.error_code"].AsString() != "ERR_UNAUTHORIZED" || failed.Status().Code != codes.Error {
+		t.Errorf("failed send has attributes %v and status %v", attrs, failed.Status())
+	}
+	if events := failed.Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("failed send recorded events %v, want the error", events)
+	}
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// typingDebounce is the shortest interval between two typing events from the
+// same client.
+const typingDebounce = 2 * time.Second
+
+// Typing broadcasts an ephemeral typing event to the other members of
+// req.ID's room. It is not recorded in history.
+func (s *chatService) Typing(ctx context.Context, req model.TypingRequest) (*model.TypingResponse, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if req.ID == "" {
+		return nil, errcom.NewCustomError("ERR_MISSING_USER_ID", errors.New("user ID is required"))
+	}
+	if err := s.checkIdentity(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	if err := s.checkToken(ctx, req.ID, req.Token); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	client, exists := s.streams[s.key(req.ID)]
+	if !exists {
+		s.mu.RUnlock()
+		return nil, errcom.NewCustomError("ERR_USER_NOT_FOUND", errors.New("user not connected"))
+	}
+	if client.Observer {
+		s.mu.RUnlock()
+		return nil, errObserverReadonly()
+	}
+	now := s.cfg.Clock.Now()
+	last := client.lastTyping.Load()
+	if now.UnixNano()-last < int64(typingDebounce) || !client.lastTyping.CompareAndSwap(last, now.UnixNano()) {
+		s.mu.RUnlock()
+		return &model.TypingResponse{Success: true}, nil
+	}
+	msg := model.Message{
+		Type:      model.MessageTypeTyping,
+		Text:      "* " + client.Name + " is typing",
+		System:    true,
+		From:      client.ID,
+		Timestamp: now,
+	}
+	s.broadcast(msg, client.Room, client.ID)
+	s.mu.RUnlock()
+	client.touch()
+	s.cfg.Backend.Publish(ctx, client.Room, msg)
+
+	return &model.TypingResponse{Success: true, Sent: true}, nil
+}
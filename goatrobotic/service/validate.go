@@ -0,0 +1,124 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	errcom "chatbox/error"
+	"chatbox/model"
+)
+
+// checkLength rejects value when it is longer than max characters, naming the
+// offending field and the limit in the error.
+func checkLength(field, value string, max int) error {
+	if utf8.RuneCountInString(value) > max {
+		return errcom.NewCustomError("ERR_FIELD_TOO_LONG", fmt.Errorf("%s must be at most %d characters", field, max))
+	}
+	return nil
+}
+
+// senderSeparator joins the sender ID and the text in a rendered message.
+const senderSeparator = ": "
+
+// checkUserID rejects IDs that could forge a sender prefix in a rendered
+// message, such as "alice: admin", or that carry control characters.
+func checkUserID(id string) error {
+	return checkRenderable("user ID", "ERR_INVALID_USER_ID", id)
+}
+
+// checkIDPattern rejects IDs that do not match pattern, if there is one.
+func checkIDPattern(id string, pattern *regexp.Regexp) error {
+	if pattern != nil && !pattern.MatchString(id) {
+		return errcom.NewCustomError("ERR_INVALID_USER_ID", fmt.Errorf("user ID must match %s", pattern))
+	}
+	return nil
+}
+
+// checkRenderable rejects values rendered as a message's sender that contain
+// the sender separator or control characters, failing with code.
+func checkRenderable(field, code, value string) error {
+	if strings.Contains(value, senderSeparator) {
+		return errcom.NewCustomError(code, fmt.Errorf("%s must not contain %q", field, senderSeparator))
+	}
+	if !utf8.ValidString(value) || strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return errcom.NewCustomError(code, fmt.Errorf("%s must not contain control characters", field))
+	}
+	return nil
+}
+
+// isScreenedControl reports whether r is a C0 control character other than
+// newline or tab.
+func isScreenedControl(r rune) bool {
+	return r < 0x20 && r != '\n' && r != '\t'
+}
+
+// checkMessage rejects text that is not valid UTF-8 and applies policy to its
+// control characters, returning the text to deliver.
+func checkMessage(text string, policy ControlCharPolicy) (string, error) {
+	if !utf8.ValidString(text) {
+		return "", errcom.NewCustomError("ERR_INVALID_MESSAGE", errors.New("message must be valid UTF-8"))
+	}
+	if policy == ControlCharsAllow || strings.IndexFunc(text, isScreenedControl) < 0 {
+		return text, nil
+	}
+	if policy == ControlCharsReject {
+		return "", errcom.NewCustomError("ERR_INVALID_MESSAGE", errors.New("message must not contain control characters"))
+	}
+	text = strings.Map(func(r rune) rune {
+		if isScreenedControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	if text == "" {
+		return "", errcom.NewCustomError("ERR_INVALID_MESSAGE", errors.New("message is empty once control characters are removed"))
+	}
+	return text, nil
+}
+
+// checkAttachment validates an attachment's type and encoding and that it
+// decodes to at most max bytes, filling in its size.
+func checkAttachment(a *model.Attachment, max int) error {
+	if a.MIMEType == "" || a.Data == "" {
+		return errcom.NewCustomError("ERR_INVALID_ATTACHMENT", errors.New("attachment needs a mime_type and data"))
+	}
+	if base64.StdEncoding.DecodedLen(len(a.Data)) > max+2 {
+		return errcom.NewCustomError("ERR_ATTACHMENT_TOO_LARGE", fmt.Errorf("attachment must be at most %d bytes", max))
+	}
+	data, err := base64.StdEncoding.DecodeString(a.Data)
+	if err != nil {
+		return errcom.NewCustomError("ERR_INVALID_ATTACHMENT", errors.New("attachment data must be base64"))
+	}
+	if len(data) > max {
+		return errcom.NewCustomError("ERR_ATTACHMENT_TOO_LARGE", fmt.Errorf("attachment must be at most %d bytes", max))
+	}
+	a.Size = len(data)
+	return nil
+}
+
+// checkName rejects a user or room name containing a banned word.
+func checkName(field, value string, words *WordFilter) error {
+	if words.Contains(value) {
+		return errcom.NewCustomError("ERR_NAME_NOT_ALLOWED", fmt.Errorf("%s contains a word that is not allowed", field))
+	}
+	return nil
+}
+
+// checkProtocol rejects client protocol versions outside the supported
+// range. Zero means the client did not declare one and gets the current one.
+func checkProtocol(version int) (int, error) {
+	if version == 0 {
+		return model.ProtocolVersion, nil
+	}
+	if version < model.MinProtocolVersion || version > model.ProtocolVersion {
+		return 0, errcom.NewCustomErrorWithDetails("ERR_UNSUPPORTED_PROTOCOL",
+			fmt.Errorf("protocol %d is not supported", version),
+			map[string]any{"min": model.MinProtocolVersion, "max": model.ProtocolVersion})
+	}
+	return version, nil
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"strings"
+	"unicode"
+)
+
+// WordFilter matches text against a list of banned words. Matching is
+// case-insensitive and on whole words, so "class" does not match "ass".
+type WordFilter struct {
+	words map[string]struct{}
+}
+
+// NewWordFilter builds a filter for the given words.
+func NewWordFilter(words ...string) *WordFilter {
+	f := &WordFilter{words: make(map[string]struct{}, len(words))}
+	for _, w := range words {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			f.words[w] = struct{}{}
+		}
+	}
+	return f
+}
+
+// Contains reports whether text contains any banned word.
+func (f *WordFilter) Contains(text string) bool {
+	if len(f.words) == 0 {
+		return false
+	}
+	for _, w := range strings.FieldsFunc(text, isWordSeparator) {
+		if _, banned := f.words[strings.ToLower(w)]; banned {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// Mask replaces every banned word in text with asterisks.
+func (f *WordFilter) Mask(text string) string {
+	if len(f.words) == 0 {
+		return text
+	}
+	var b strings.Builder
+	word := []rune{}
+	flush := func() {
+		if _, banned := f.words[strings.ToLower(string(word))]; banned {
+			b.WriteString(strings.Repeat("*", len(word)))
+		} else {
+			b.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+	for _, r := range text {
+		if isWordSeparator(r) {
+			flush()
+			b.WriteRune(r)
+			continue
+		}
+		word = append(word, r)
+	}
+	flush()
+	return b.String()
+}